@@ -0,0 +1,349 @@
+package balancer
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/shengzhch/learn/rpc"
+)
+
+/*
+客户端负载均衡：把调用分散到多个 rpc.Server 上。
+每个后端维护一个 rpc.Client，第一次使用时建立连接，连接出错后丢弃，下次使用时重连。
+丢弃的连接等正在进行的调用结束后才关闭，所以 rpc.ErrShutdown 总是表示请求没有发出。
+
+重试：
+  - 连接建立失败或连接已经关闭（rpc.ErrShutdown）时请求还没有发出，总是换一个后端重试
+  - 请求发出后连接断开，只有 SetIdempotent 登记过的方法才换后端重试
+  - 服务端返回的错误（rpc.ServerError）不重试
+
+开启 SetHealthCheck 后，后台定时对每个后端调用 RPC.Ping（服务端需要 SetKeepAlive(true)），
+失败的后端被剔除，不再参与选择，直到再次检查成功；调用时连接出错也会立即剔除。
+*/
+
+var ErrNoBackend = errors.New("balancer: no available backend")
+
+//一个服务端
+type Backend struct {
+	network, addr string
+
+	outstanding int64 //未完成的调用数
+	ejected     int32 //被健康检查剔除
+
+	mu   sync.Mutex
+	conn *backendConn
+}
+
+//后端的一个连接，active 和 dropped 由 Backend.mu 保护
+type backendConn struct {
+	client  *rpc.Client
+	active  int //正在使用的调用数
+	dropped bool
+}
+
+func (b *Backend) Addr() string { return b.addr }
+
+func (b *Backend) Outstanding() int64 { return atomic.LoadInt64(&b.outstanding) }
+
+func (b *Backend) Healthy() bool { return atomic.LoadInt32(&b.ejected) == 0 }
+
+//取得连接，用完后调用 release
+func (b *Backend) dial() (*backendConn, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.conn == nil {
+		client, err := rpc.Dial(b.network, b.addr)
+		if err != nil {
+			return nil, err
+		}
+		b.conn = &backendConn{client: client}
+	}
+	b.conn.active++
+	return b.conn, nil
+}
+
+func (b *Backend) release(c *backendConn) {
+	b.mu.Lock()
+	c.active--
+	closeNow := c.dropped && c.active == 0
+	b.mu.Unlock()
+	if closeNow {
+		c.client.Close()
+	}
+}
+
+//丢弃出错的连接，之后的调用重新建立连接；
+//其他调用可能还在这个连接上等待结果，直接关闭会让它们得到 ErrShutdown 而被误认为没有发出
+func (b *Backend) drop(c *backendConn) {
+	b.mu.Lock()
+	if b.conn == c {
+		b.conn = nil
+	}
+	c.dropped = true
+	closeNow := c.active == 0
+	b.mu.Unlock()
+	if closeNow {
+		c.client.Close()
+	}
+}
+
+//丢弃当前的连接
+func (b *Backend) dropCurrent() {
+	b.mu.Lock()
+	c := b.conn
+	b.mu.Unlock()
+	if c != nil {
+		b.drop(c)
+	}
+}
+
+type Balancer struct {
+	picker     Picker
+	retries    int
+	idempotent map[string]bool
+	breaker    *rpc.CircuitBreaker
+
+	mu       sync.RWMutex
+	backends []*Backend //按地址排序
+
+	checking  bool
+	stopCheck chan struct{} //关闭时停止当前的健康检查
+	closeOnce sync.Once
+}
+
+func New(picker Picker, endpoints []rpc.Endpoint) *Balancer {
+	b := &Balancer{picker: picker, retries: 2, idempotent: make(map[string]bool)}
+	b.Update(endpoints)
+	return b
+}
+
+//最多换几次后端，默认 2
+func (b *Balancer) SetRetries(n int) {
+	b.retries = n
+}
+
+//登记可以安全重试的方法，"Service.Method" 形式
+func (b *Balancer) SetIdempotent(serviceMethods ...string) {
+	for _, m := range serviceMethods {
+		b.idempotent[m] = true
+	}
+}
+
+//按后端地址和方法熔断，熔断中的后端不参与选择；所有后端都熔断时返回 *rpc.CircuitOpenError
+func (b *Balancer) SetBreaker(cb *rpc.CircuitBreaker) {
+	b.breaker = cb
+}
+
+//开启健康检查，每个 interval 检查一次，超时也是 interval；再次调用时替换之前的检查
+func (b *Balancer) SetHealthCheck(interval time.Duration) {
+	stop := make(chan struct{})
+	b.mu.Lock()
+	if b.stopCheck != nil {
+		close(b.stopCheck)
+	}
+	b.checking = true
+	b.stopCheck = stop
+	b.mu.Unlock()
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				b.check(interval)
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+//替换后端列表，地址相同的后端保留连接和状态，可以配合 registry.Resolver 使用
+func (b *Balancer) Update(endpoints []rpc.Endpoint) {
+	b.mu.Lock()
+	old := make(map[string]*Backend, len(b.backends))
+	for _, be := range b.backends {
+		old[be.network+" "+be.addr] = be
+	}
+	backends := make([]*Backend, 0, len(endpoints))
+	for _, ep := range endpoints {
+		key := ep.Network + " " + ep.Addr
+		be, ok := old[key]
+		if ok {
+			delete(old, key)
+		} else {
+			be = &Backend{network: ep.Network, addr: ep.Addr}
+		}
+		backends = append(backends, be)
+	}
+	sort.Slice(backends, func(i, j int) bool { return backends[i].addr < backends[j].addr })
+	b.backends = backends
+	if u, ok := b.picker.(backendsUpdater); ok {
+		u.update(backends)
+	}
+	b.mu.Unlock()
+
+	for _, be := range old {
+		be.dropCurrent()
+	}
+}
+
+func (b *Balancer) Backends() []*Backend {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return append([]*Backend(nil), b.backends...)
+}
+
+//停止健康检查并关闭所有连接，可以重复调用
+func (b *Balancer) Close() error {
+	b.closeOnce.Do(func() {
+		b.mu.Lock()
+		if b.stopCheck != nil {
+			close(b.stopCheck)
+			b.stopCheck = nil
+		}
+		b.mu.Unlock()
+		b.Update(nil)
+	})
+	return nil
+}
+
+type keyCtx struct{}
+
+//为调用指定一致性哈希使用的 key
+func WithKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, keyCtx{}, key)
+}
+
+//没有可选的后端时返回 ErrNoBackend，或者跳过的后端的熔断错误
+func (b *Balancer) pick(key, serviceMethod string, tried map[*Backend]bool) (*Backend, error) {
+	b.mu.RLock()
+	var list []*Backend
+	err := ErrNoBackend
+	for _, be := range b.backends {
+		if !be.Healthy() || tried[be] {
+			continue
+		}
+		if b.breaker != nil {
+			if openErr := b.breaker.Check(be.addr, serviceMethod); openErr != nil {
+				err = openErr
+				continue
+			}
+		}
+		list = append(list, be)
+	}
+	b.mu.RUnlock()
+	if len(list) == 0 {
+		return nil, err
+	}
+	return b.picker.Pick(list, key), nil
+}
+
+func (b *Balancer) Call(ctx context.Context, serviceMethod string, args interface{}, reply interface{}) error {
+	key, _ := ctx.Value(keyCtx{}).(string)
+	tried := make(map[*Backend]bool)
+	var err error
+	for attempt := 0; attempt <= b.retries; attempt++ {
+		be, pickErr := b.pick(key, serviceMethod, tried)
+		if be == nil {
+			if err == nil {
+				err = pickErr
+			}
+			return err
+		}
+		tried[be] = true
+
+		done := func(error) {}
+		if b.breaker != nil {
+			var openErr error
+			if done, openErr = b.breaker.Allow(be.addr, serviceMethod); openErr != nil {
+				//pick 之后熔断器刚刚拒绝，换一个后端，不算重试
+				if err == nil {
+					err = openErr
+				}
+				attempt--
+				continue
+			}
+		}
+
+		var c *backendConn
+		c, err = be.dial()
+		if err != nil {
+			done(err)
+			b.eject(be)
+			continue
+		}
+
+		atomic.AddInt64(&be.outstanding, 1)
+		err = c.client.CallContext(ctx, serviceMethod, args, reply)
+		atomic.AddInt64(&be.outstanding, -1)
+		be.release(c)
+		done(err)
+		if err == nil || !connError(err) {
+			return err
+		}
+		be.drop(c)
+		b.eject(be)
+		if err != rpc.ErrShutdown && !b.idempotent[serviceMethod] {
+			return err
+		}
+	}
+	return err
+}
+
+//连接层面的错误，服务端返回的错误和 ctx 的错误除外
+func connError(err error) bool {
+	if err == rpc.ErrShutdown || err == io.EOF || err == io.ErrUnexpectedEOF {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+//只在开启健康检查时剔除，由检查负责恢复
+func (b *Balancer) eject(be *Backend) {
+	b.mu.RLock()
+	checking := b.checking
+	b.mu.RUnlock()
+	if checking {
+		atomic.StoreInt32(&be.ejected, 1)
+	}
+}
+
+func (b *Balancer) check(timeout time.Duration) {
+	var wg sync.WaitGroup
+	for _, be := range b.Backends() {
+		wg.Add(1)
+		go func(be *Backend) {
+			defer wg.Done()
+			if err := ping(be, timeout); err != nil {
+				atomic.StoreInt32(&be.ejected, 1)
+			} else {
+				atomic.StoreInt32(&be.ejected, 0)
+			}
+		}(be)
+	}
+	wg.Wait()
+}
+
+func ping(be *Backend, timeout time.Duration) error {
+	c, err := be.dial()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	var reply int64
+	err = c.client.CallContext(ctx, "RPC.Ping", time.Now().UnixNano(), &reply)
+	be.release(c)
+	if err != nil {
+		be.drop(c)
+	}
+	return err
+}
@@ -0,0 +1,400 @@
+package balancer
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/shengzhch/learn/rpc"
+)
+
+//返回所在服务端的编号
+type Who struct {
+	id      int
+	release chan struct{}
+}
+
+func (w *Who) Name(args int, reply *int) error {
+	*reply = w.id
+	return nil
+}
+
+func (w *Who) Wait(args int, reply *int) error {
+	<-w.release
+	*reply = w.id
+	return nil
+}
+
+//记录连接的监听器，用来模拟服务端宕机
+type testServer struct {
+	net.Listener
+	mu    sync.Mutex
+	conns []net.Conn
+}
+
+func (s *testServer) Accept() (net.Conn, error) {
+	c, err := s.Listener.Accept()
+	if err == nil {
+		s.mu.Lock()
+		s.conns = append(s.conns, c)
+		s.mu.Unlock()
+	}
+	return c, err
+}
+
+func (s *testServer) kill() {
+	s.Listener.Close()
+	s.mu.Lock()
+	for _, c := range s.conns {
+		c.Close()
+	}
+	s.mu.Unlock()
+}
+
+func startServers(t *testing.T, n int) ([]*testServer, []rpc.Endpoint, chan struct{}) {
+	release := make(chan struct{})
+	var servers []*testServer
+	var endpoints []rpc.Endpoint
+	for i := 0; i < n; i++ {
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		server := rpc.NewServer()
+		server.RegisterName(&Who{id: i, release: release}, "Who")
+		server.SetKeepAlive(true)
+		ts := &testServer{Listener: lis}
+		go server.Accept(ts)
+		servers = append(servers, ts)
+		endpoints = append(endpoints, rpc.Endpoint{Network: "tcp", Addr: lis.Addr().String()})
+	}
+	t.Cleanup(func() {
+		for _, s := range servers {
+			s.kill()
+		}
+	})
+	return servers, endpoints, release
+}
+
+//编号到 Backend 地址的对应
+func ids(t *testing.T, servers []*testServer) map[string]int {
+	m := make(map[string]int)
+	for i, s := range servers {
+		m[s.Addr().String()] = i
+	}
+	return m
+}
+
+func TestRoundRobin(t *testing.T) {
+	_, endpoints, _ := startServers(t, 3)
+	b := New(RoundRobin(), endpoints)
+	defer b.Close()
+
+	counts := make(map[int]int)
+	for i := 0; i < 9; i++ {
+		var id int
+		if err := b.Call(context.Background(), "Who.Name", 0, &id); err != nil {
+			t.Fatal(err)
+		}
+		counts[id]++
+	}
+	for i := 0; i < 3; i++ {
+		if counts[i] != 3 {
+			t.Errorf("counts = %v, want 3 each", counts)
+		}
+	}
+}
+
+func TestLeastOutstanding(t *testing.T) {
+	_, endpoints, release := startServers(t, 3)
+	b := New(LeastOutstanding(), endpoints)
+	defer b.Close()
+
+	//前三个阻塞的调用应当分到三个不同的后端
+	results := make(chan int, 3)
+	for i := 0; i < 3; i++ {
+		go func() {
+			var id int
+			b.Call(context.Background(), "Who.Wait", 0, &id)
+			results <- id
+		}()
+		deadline := time.Now().Add(2 * time.Second)
+		for total(b) != i+1 && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	for _, be := range b.Backends() {
+		if be.Outstanding() != 1 {
+			t.Errorf("%s outstanding = %d, want 1", be.Addr(), be.Outstanding())
+		}
+	}
+	close(release)
+	seen := make(map[int]bool)
+	for i := 0; i < 3; i++ {
+		seen[<-results] = true
+	}
+	if len(seen) != 3 {
+		t.Errorf("calls went to %v", seen)
+	}
+}
+
+func total(b *Balancer) int {
+	n := 0
+	for _, be := range b.Backends() {
+		n += int(be.Outstanding())
+	}
+	return n
+}
+
+func TestConsistentHash(t *testing.T) {
+	servers, endpoints, _ := startServers(t, 3)
+	b := New(ConsistentHash(50), endpoints)
+	defer b.Close()
+
+	owner := func(key string) int {
+		var id int
+		if err := b.Call(WithKey(context.Background(), key), "Who.Name", 0, &id); err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+
+	before := make(map[string]int)
+	for i := 0; i < 50; i++ {
+		key := "user-" + strconv.Itoa(i)
+		before[key] = owner(key)
+		if again := owner(key); again != before[key] {
+			t.Fatalf("%s moved from %d to %d", key, before[key], again)
+		}
+	}
+
+	//去掉一个后端，只有原来属于它的 key 换位置
+	removed := ids(t, servers)[endpoints[2].Addr]
+	b.Update(endpoints[:2])
+	for key, id := range before {
+		if id != removed && owner(key) != id {
+			t.Errorf("%s moved after removing backend %d", key, removed)
+		}
+	}
+}
+
+//后端被去掉又重新加入后，环中是新的 *Backend
+func TestConsistentHashUpdate(t *testing.T) {
+	_, endpoints, _ := startServers(t, 2)
+	b := New(ConsistentHash(50), endpoints)
+	defer b.Close()
+	b.Update(endpoints[:1])
+	b.Update(endpoints)
+
+	current := make(map[*Backend]bool)
+	for _, be := range b.Backends() {
+		current[be] = true
+	}
+	for i := 0; i < 50; i++ {
+		be, err := b.pick("user-"+strconv.Itoa(i), "Who.Name", nil)
+		if err != nil || !current[be] {
+			t.Fatalf("picked stale backend %p (%v)", be, err)
+		}
+	}
+}
+
+//重复的 SetHealthCheck 替换之前的检查，Close 可以重复调用
+func TestHealthCheckAndClose(t *testing.T) {
+	_, endpoints, _ := startServers(t, 1)
+	b := New(RoundRobin(), endpoints)
+	b.SetHealthCheck(time.Hour)
+	first := b.stopCheck
+	b.SetHealthCheck(time.Hour)
+	select {
+	case <-first:
+	default:
+		t.Error("previous health check still running")
+	}
+	second := b.stopCheck
+	b.Close()
+	b.Close()
+	select {
+	case <-second:
+	default:
+		t.Error("health check not stopped by Close")
+	}
+}
+
+func TestFailover(t *testing.T) {
+	servers, endpoints, _ := startServers(t, 2)
+	b := New(RoundRobin(), endpoints)
+	defer b.Close()
+	b.SetIdempotent("Who.Name")
+
+	//先建立到两个后端的连接
+	for i := 0; i < 2; i++ {
+		var id int
+		if err := b.Call(context.Background(), "Who.Name", 0, &id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	servers[0].kill()
+	time.Sleep(10 * time.Millisecond)
+
+	for i := 0; i < 4; i++ {
+		var id int
+		if err := b.Call(context.Background(), "Who.Name", 0, &id); err != nil {
+			t.Fatalf("idempotent call %d: %v", i, err)
+		}
+		if id != 1 {
+			t.Errorf("call %d served by %d", i, id)
+		}
+	}
+}
+
+func TestNoRetryForNonIdempotent(t *testing.T) {
+	servers, endpoints, release := startServers(t, 2)
+	defer close(release)
+	b := New(RoundRobin(), endpoints)
+	defer b.Close()
+
+	errc := make(chan error, 1)
+	go func() {
+		var id int
+		errc <- b.Call(context.Background(), "Who.Wait", 0, &id)
+	}()
+	deadline := time.Now().Add(2 * time.Second)
+	for total(b) != 1 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	//请求已经发出后连接断开，不能换后端重试
+	owner := ids(t, servers)
+	for _, be := range b.Backends() {
+		if be.Outstanding() == 1 {
+			servers[owner[be.Addr()]].kill()
+		}
+	}
+	select {
+	case err := <-errc:
+		if err == nil {
+			t.Error("non-idempotent call was retried on another backend")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("call did not fail")
+	}
+}
+
+func TestHealthCheckEjection(t *testing.T) {
+	servers, endpoints, _ := startServers(t, 3)
+	b := New(RoundRobin(), endpoints)
+	defer b.Close()
+	b.SetHealthCheck(10 * time.Millisecond)
+
+	dead := servers[1].Addr().String()
+	servers[1].kill()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		healthy := 0
+		for _, be := range b.Backends() {
+			if be.Healthy() {
+				healthy++
+			} else if be.Addr() != dead {
+				t.Fatalf("%s ejected", be.Addr())
+			}
+		}
+		if healthy == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("dead backend not ejected")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	for i := 0; i < 6; i++ {
+		var id int
+		if err := b.Call(context.Background(), "Who.Name", 0, &id); err != nil {
+			t.Fatal(err)
+		}
+		if id == 1 {
+			t.Error("call routed to ejected backend")
+		}
+	}
+}
+
+func TestBreakerSkipsBackend(t *testing.T) {
+	servers, endpoints, _ := startServers(t, 2)
+	b := New(RoundRobin(), endpoints)
+	defer b.Close()
+	cb := rpc.NewCircuitBreaker()
+	cb.SetThreshold(0.5, 1)
+	cb.SetCooldown(time.Minute)
+	b.SetBreaker(cb)
+	//熔断的后端在选择时就被跳过，不占用重试次数
+	b.SetRetries(0)
+
+	//打开第一个后端的熔断
+	done, err := cb.Allow(servers[0].Addr().String(), "Who.Name")
+	if err != nil {
+		t.Fatal(err)
+	}
+	done(rpc.ErrShutdown)
+
+	for i := 0; i < 4; i++ {
+		var id int
+		if err := b.Call(context.Background(), "Who.Name", 0, &id); err != nil {
+			t.Fatalf("call %d: %v", i, err)
+		}
+		if id != 1 {
+			t.Errorf("call %d served by %d", i, id)
+		}
+	}
+
+	for cb.State(servers[1].Addr().String(), "Who.Name") != rpc.BreakerOpen {
+		done, _ = cb.Allow(servers[1].Addr().String(), "Who.Name")
+		done(rpc.ErrShutdown)
+	}
+	if err := b.Call(context.Background(), "Who.Name", 0, new(int)); !errors.Is(err, rpc.ErrCircuitOpen) {
+		t.Errorf("all backends open: err = %v", err)
+	}
+}
+
+//替换后端列表时正在进行的调用在原来的连接上完成，不会得到 ErrShutdown 被换后端重发
+func TestUpdateDrainsInFlight(t *testing.T) {
+	servers, endpoints, release := startServers(t, 2)
+	b := New(RoundRobin(), endpoints)
+	defer b.Close()
+
+	results := make(chan int, 1)
+	errc := make(chan error, 1)
+	go func() {
+		var id int
+		errc <- b.Call(context.Background(), "Who.Wait", 0, &id)
+		results <- id
+	}()
+	deadline := time.Now().Add(2 * time.Second)
+	for total(b) != 1 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	owner := ids(t, servers)
+	var busy int
+	var rest []rpc.Endpoint
+	for _, be := range b.Backends() {
+		if be.Outstanding() == 1 {
+			busy = owner[be.Addr()]
+		} else {
+			rest = append(rest, rpc.Endpoint{Network: "tcp", Addr: be.Addr()})
+		}
+	}
+	b.Update(rest)
+	close(release)
+
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+	if id := <-results; id != busy {
+		t.Errorf("call resent to backend %d, started on %d", id, busy)
+	}
+}
@@ -0,0 +1,108 @@
+package balancer
+
+import (
+	"hash/crc32"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+//从可用的后端中选一个，backends 非空，按地址排序
+type Picker interface {
+	Pick(backends []*Backend, key string) *Backend
+}
+
+//轮询
+func RoundRobin() Picker {
+	return &roundRobin{}
+}
+
+type roundRobin struct {
+	next uint32
+}
+
+func (p *roundRobin) Pick(backends []*Backend, key string) *Backend {
+	n := atomic.AddUint32(&p.next, 1)
+	return backends[int(n-1)%len(backends)]
+}
+
+//未完成调用最少的后端，相同时取靠前的
+func LeastOutstanding() Picker {
+	return leastOutstanding{}
+}
+
+type leastOutstanding struct{}
+
+func (leastOutstanding) Pick(backends []*Backend, key string) *Backend {
+	best := backends[0]
+	for _, b := range backends[1:] {
+		if b.Outstanding() < best.Outstanding() {
+			best = b
+		}
+	}
+	return best
+}
+
+//需要完整后端列表的 Picker，Balancer.Update 时调用
+type backendsUpdater interface {
+	update(backends []*Backend)
+}
+
+/*
+按 key 一致性哈希，key 由 WithKey 放在 context 中。
+每个后端在环上有 replicas 个虚拟节点，后端增减时只有少量 key 换到别的后端。
+环按 Balancer 的全部后端构建，只在 Update 时重建；被剔除或已经试过的后端在沿环查找时跳过，
+所以换后端重试不会重建环，同一个 key 总是按同样的顺序换到后面的后端。
+*/
+func ConsistentHash(replicas int) Picker {
+	if replicas <= 0 {
+		replicas = 100
+	}
+	return &consistentHash{replicas: replicas}
+}
+
+type consistentHash struct {
+	replicas int
+
+	mu   sync.Mutex
+	ring []ringNode //只整体替换，不原地修改
+}
+
+type ringNode struct {
+	hash    uint32
+	backend *Backend
+}
+
+func (p *consistentHash) update(backends []*Backend) {
+	ring := make([]ringNode, 0, len(backends)*p.replicas)
+	for _, b := range backends {
+		for i := 0; i < p.replicas; i++ {
+			ring = append(ring, ringNode{crc32.ChecksumIEEE([]byte(b.Addr() + "#" + strconv.Itoa(i))), b})
+		}
+	}
+	sort.Slice(ring, func(i, j int) bool { return ring[i].hash < ring[j].hash })
+	p.mu.Lock()
+	p.ring = ring
+	p.mu.Unlock()
+}
+
+func (p *consistentHash) Pick(backends []*Backend, key string) *Backend {
+	p.mu.Lock()
+	ring := p.ring
+	p.mu.Unlock()
+
+	candidates := make(map[*Backend]bool, len(backends))
+	for _, b := range backends {
+		candidates[b] = true
+	}
+	h := crc32.ChecksumIEEE([]byte(key))
+	i := sort.Search(len(ring), func(i int) bool { return ring[i].hash >= h })
+	for n := 0; n < len(ring); n++ {
+		if b := ring[(i+n)%len(ring)].backend; candidates[b] {
+			return b
+		}
+	}
+	//环中没有候选的后端，只在不经过 Balancer.Update 使用时发生
+	return backends[0]
+}
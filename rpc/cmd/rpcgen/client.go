@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"go/format"
+	"text/template"
+)
+
+var clientTemplate = template.Must(template.New("client").Parse(`// Code generated by rpcgen. DO NOT EDIT.
+
+package {{.Package}}
+
+import (
+{{- range .Imports}}
+	{{.}}{{end}}
+)
+
+//在 rpc.Client 上按服务提供强类型的调用
+type RPCClient struct {
+	*rpc.Client
+}
+
+func NewRPCClient(c *rpc.Client) *RPCClient {
+	return &RPCClient{c}
+}
+{{range .Services}}
+func (c *RPCClient) {{.Name}}() *{{.Name}}Client {
+	return &{{.Name}}Client{c.Client, "{{.Name}}"}
+}
+{{end}}
+{{- range .Services}}
+//{{.Name}} 服务的客户端
+type {{.Name}}Client struct {
+	c    *rpc.Client
+	name string
+}
+
+//name 为服务端注册的服务名，使用 RegisterName 时需要指定
+func New{{.Name}}Client(c *rpc.Client, name string) *{{.Name}}Client {
+	return &{{.Name}}Client{c, name}
+}
+{{$svc := .Name}}{{range .Methods}}
+func (c *{{$svc}}Client) {{.Name}}(ctx context.Context, args {{.ArgType}}) (*{{.ReplyType}}, error) {
+	reply := new({{.ReplyType}})
+	if err := c.c.CallContext(ctx, c.name+".{{.Name}}", args, reply); err != nil {
+		return nil, err
+	}
+	return reply, nil
+}
+{{end}}{{end}}`))
+
+//为 types 生成客户端
+func generateClient(p *pkgInfo, types []string) ([]byte, error) {
+	services, err := p.services(types)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	err = clientTemplate.Execute(&buf, map[string]interface{}{
+		"Package":  p.name,
+		"Imports":  p.importList("context", rpcPath),
+		"Services": services,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return format.Source(buf.Bytes())
+}
@@ -0,0 +1,96 @@
+/*
+rpcgen 为 rpc 服务类型生成代码，配合 go generate 使用：
+
+	//go:generate go run github.com/shengzhch/learn/cmd/rpcgen -type Arith
+
+默认生成 <type>_rpcstub.go，其中为 *Arith 的每个满足 rpc 要求的方法生成分发函数，
+并在 init 中通过 rpc.RegisterStubs 登记，Server 注册 *Arith 时调用不再经过反射。
+
+-client 改为生成 <type>_rpcclient.go，在 *rpc.Client 上提供强类型的调用：
+
+	c := arith.NewRPCClient(client)
+	product, err := c.Arith().Multiply(ctx, &arith.Args{A: 7, B: 8})
+
+-openrpc file 同时为 jsonrpc 传输生成 OpenRPC 文档，内容和运行时 Server.OpenRPC 的结果一致。
+*/
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	typeNames = flag.String("type", "", "comma-separated list of service type names; must be set")
+	output    = flag.String("output", "", "output file name; default <type>_rpcstub.go or <type>_rpcclient.go")
+	client    = flag.Bool("client", false, "generate typed client instead of server stubs")
+	openRPC   = flag.String("openrpc", "", "also write an OpenRPC document for the jsonrpc transport to this file")
+	version   = flag.String("version", "1.0.0", "API version recorded in the OpenRPC document")
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage of rpcgen:\n")
+	fmt.Fprintf(os.Stderr, "\trpcgen -type T [directory]\n")
+	flag.PrintDefaults()
+}
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("rpcgen: ")
+	flag.Usage = usage
+	flag.Parse()
+	if *typeNames == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	types := strings.Split(*typeNames, ",")
+
+	dir := "."
+	if args := flag.Args(); len(args) > 0 {
+		dir = args[0]
+	}
+
+	pkg, err := parsePackage(dir)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	generate, suffix := generateStubs, "_rpcstub.go"
+	if *client {
+		generate, suffix = generateClient, "_rpcclient.go"
+	}
+	src, err := generate(pkg, types)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	name := *output
+	if name == "" {
+		name = strings.ToLower(types[0]) + suffix
+	}
+	if err := os.WriteFile(outputPath(dir, name), src, 0644); err != nil {
+		log.Fatal(err)
+	}
+
+	if *openRPC != "" {
+		doc, err := generateOpenRPC(pkg, dir, types, *version)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := os.WriteFile(outputPath(dir, *openRPC), doc, 0644); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+//相对路径相对于包目录，绝对路径原样使用
+func outputPath(dir, name string) string {
+	if filepath.IsAbs(name) {
+		return name
+	}
+	return filepath.Join(dir, name)
+}
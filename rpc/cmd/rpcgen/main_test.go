@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/shengzhch/learn/openrpc"
+)
+
+func TestGenerateStubs(t *testing.T) {
+	pkg, err := parsePackage("testdata/arith")
+	if err != nil {
+		t.Fatal(err)
+	}
+	src, err := generateStubs(pkg, []string{"Arith"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := string(src)
+
+	for _, want := range []string{
+		"package arith",
+		`"time"`,
+		`"github.com/shengzhch/learn/rpc"`,
+		"rcvr.(*Arith).Multiply(args.(*Args), reply.(*int))",
+		"rcvr.(*Arith).Divide(*args.(*Args), reply.(*Quotient))",
+		"rcvr.(*Arith).Sleep(ctx, *args.(*time.Duration), reply.(*bool))",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("generated code missing %q:\n%s", want, out)
+		}
+	}
+	for _, unwanted := range []string{"Reset", "private"} {
+		if strings.Contains(out, unwanted) {
+			t.Errorf("generated code should not contain %q", unwanted)
+		}
+	}
+
+	if _, err := generateStubs(pkg, []string{"Missing"}); err == nil {
+		t.Error("expected error for unknown type")
+	}
+}
+
+func TestGenerateClient(t *testing.T) {
+	pkg, err := parsePackage("testdata/arith")
+	if err != nil {
+		t.Fatal(err)
+	}
+	src, err := generateClient(pkg, []string{"Arith"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := string(src)
+
+	for _, want := range []string{
+		"func (c *RPCClient) Arith() *ArithClient",
+		"func (c *ArithClient) Multiply(ctx context.Context, args *Args) (*int, error)",
+		"func (c *ArithClient) Divide(ctx context.Context, args Args) (*Quotient, error)",
+		"func (c *ArithClient) Sleep(ctx context.Context, args time.Duration) (*bool, error)",
+		`c.c.CallContext(ctx, c.name+".Divide", args, reply)`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("generated code missing %q:\n%s", want, out)
+		}
+	}
+}
+
+//生成的代码和包里原有的代码一起能通过编译
+func TestGeneratedCodeCompiles(t *testing.T) {
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go command not found")
+	}
+	pkg, err := parsePackage("testdata/arith")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//生成的文件通过 -overlay 加入编译，不写进 testdata
+	tmp := t.TempDir()
+	replace := map[string]string{}
+	for name, generate := range map[string]func(*pkgInfo, []string) ([]byte, error){
+		"arith_rpcstub.go":   generateStubs,
+		"arith_rpcclient.go": generateClient,
+	} {
+		src, err := generate(pkg, []string{"Arith"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		file := filepath.Join(tmp, name)
+		if err := os.WriteFile(file, src, 0644); err != nil {
+			t.Fatal(err)
+		}
+		abs, _ := filepath.Abs(filepath.Join("testdata/arith", name))
+		replace[abs] = file
+	}
+	overlay, _ := json.Marshal(map[string]interface{}{"Replace": replace})
+	overlayFile := filepath.Join(tmp, "overlay.json")
+	if err := os.WriteFile(overlayFile, overlay, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := exec.Command("go", "vet", "-overlay", overlayFile, "./testdata/arith").CombinedOutput()
+	if err != nil {
+		t.Fatalf("generated code does not compile: %v\n%s", err, out)
+	}
+}
+
+func TestOutputPath(t *testing.T) {
+	abs := filepath.Join(os.TempDir(), "arith.json")
+	if got := outputPath("testdata/arith", abs); got != abs {
+		t.Errorf("absolute path joined: %q", got)
+	}
+	if got := outputPath("testdata/arith", "arith.json"); got != filepath.Join("testdata/arith", "arith.json") {
+		t.Errorf("relative path = %q", got)
+	}
+}
+
+func TestGenerateOpenRPC(t *testing.T) {
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go command not found")
+	}
+	pkg, err := parsePackage("testdata/arith")
+	if err != nil {
+		t.Fatal(err)
+	}
+	src, err := generateOpenRPC(pkg, "testdata/arith", []string{"Arith"}, "1.0.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var doc openrpc.Document
+	if err := json.Unmarshal(src, &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.Info.Title != "arith" || doc.Info.Version != "1.0.0" {
+		t.Errorf("info = %+v", doc.Info)
+	}
+	if len(doc.Methods) != 3 || doc.Methods[0].Name != "Arith.Divide" {
+		t.Fatalf("unexpected methods: %s", src)
+	}
+	if ref := doc.Methods[0].Params[0].Schema.Ref; ref != "#/components/schemas/Args" {
+		t.Errorf("Divide params ref = %q", ref)
+	}
+	args := doc.Components.Schemas["Args"]
+	if args == nil || args.Properties["A"].Type != "integer" || args.Properties["B"].Type != "integer" {
+		t.Errorf("unexpected Args schema: %s", src)
+	}
+	quo := doc.Components.Schemas["Quotient"]
+	if quo == nil || quo.Properties["quo"] == nil || quo.Properties["Rem"] == nil {
+		t.Errorf("unexpected Quotient schema: %s", src)
+	}
+	if typ := doc.Methods[2].Params[0].Schema.Type; typ != "integer" {
+		t.Errorf("time.Duration schema type = %q", typ)
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"go/format"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+/*
+为 jsonrpc 传输生成 OpenRPC 文档。
+schema 和运行时的 Server.OpenRPC 用同一套反射规则：生成一个临时程序，
+导入服务所在的包并注册各服务，go run 之后把 Server.OpenRPC 的结果写出。
+所以服务所在的包不能是 main 包。
+*/
+
+var openRPCTemplate = template.Must(template.New("openrpc").Parse(`// Code generated by rpcgen. DO NOT EDIT.
+
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+
+	"github.com/shengzhch/learn/rpc"
+	svcpkg "{{.ImportPath}}"
+)
+
+func main() {
+	server := rpc.NewServer()
+{{- range .Services}}
+	if err := server.RegisterName(new(svcpkg.{{.}}), "{{.}}"); err != nil {
+		log.Fatal(err)
+	}
+{{- end}}
+	doc := server.OpenRPC()
+	doc.Info.Title = {{printf "%q" .Title}}
+	doc.Info.Version = {{printf "%q" .Version}}
+	body, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		log.Fatal(err)
+	}
+	os.Stdout.Write(body)
+}
+`))
+
+func generateOpenRPC(p *pkgInfo, dir string, types []string, version string) ([]byte, error) {
+	if p.name == "main" {
+		return nil, errors.New("cannot generate OpenRPC document for package main")
+	}
+	services, err := p.services(types)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, len(services))
+	for i, svc := range services {
+		names[i] = svc.Name
+	}
+
+	importPath, err := goCommand(dir, "list", "-f", "{{.ImportPath}}", ".")
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	err = openRPCTemplate.Execute(&buf, map[string]interface{}{
+		"ImportPath": strings.TrimSpace(string(importPath)),
+		"Services":   names,
+		"Title":      p.name,
+		"Version":    version,
+	})
+	if err != nil {
+		return nil, err
+	}
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		return nil, err
+	}
+
+	//临时程序放在包目录下才能按同一个模块解析导入，"_" 开头的目录会被 ./... 忽略
+	tmp, err := os.MkdirTemp(dir, "_rpcgen")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmp)
+	if err := os.WriteFile(filepath.Join(tmp, "main.go"), src, 0644); err != nil {
+		return nil, err
+	}
+	return goCommand(tmp, "run", ".")
+}
+
+//在 dir 下执行 go 命令，返回标准输出
+func goCommand(dir string, args ...string) ([]byte, error) {
+	cmd := exec.Command("go", args...)
+	cmd.Dir = dir
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, errors.New("go " + strings.Join(args, " ") + ": " + err.Error() + "\n" + stderr.String())
+	}
+	return out, nil
+}
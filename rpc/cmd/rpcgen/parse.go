@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+//从源码中解析出的服务
+type service struct {
+	Name    string
+	Methods []*method
+}
+
+type method struct {
+	Name      string
+	WithCtx   bool   //第一个参数是 context.Context
+	ArgType   string //源码中的写法，例如 "*Args"、"int"
+	ReplyType string //去掉 * 之后的类型
+}
+
+func (m *method) ArgIsPtr() bool {
+	return strings.HasPrefix(m.ArgType, "*")
+}
+
+type pkgInfo struct {
+	fset    *token.FileSet
+	name    string
+	files   []*ast.File
+	imports map[string]string //包名 -> 导入路径，方法签名中用到的
+}
+
+func parsePackage(dir string) (*pkgInfo, error) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseDir(fset, dir, func(fi os.FileInfo) bool {
+		return !strings.HasSuffix(fi.Name(), "_test.go")
+	}, 0)
+	if err != nil {
+		return nil, err
+	}
+	if len(pkgs) != 1 {
+		return nil, errors.New("expected exactly one package in " + dir)
+	}
+
+	p := &pkgInfo{fset: fset, imports: make(map[string]string)}
+	for name, pkg := range pkgs {
+		p.name = name
+		names := make([]string, 0, len(pkg.Files))
+		for fn := range pkg.Files {
+			names = append(names, fn)
+		}
+		sort.Strings(names)
+		for _, fn := range names {
+			p.files = append(p.files, pkg.Files[fn])
+		}
+	}
+	return p, nil
+}
+
+func isExported(name string) bool {
+	r, _ := utf8.DecodeRuneInString(name)
+	return unicode.IsUpper(r)
+}
+
+func (p *pkgInfo) expr(e ast.Expr) string {
+	var buf bytes.Buffer
+	format.Node(&buf, p.fset, e)
+	return buf.String()
+}
+
+//类型表达式是否是导出类型或内建类型（与 rpc 的 isExportedOrBuiltinType 一致）
+func exportedOrBuiltin(e ast.Expr) bool {
+	for {
+		star, ok := e.(*ast.StarExpr)
+		if !ok {
+			break
+		}
+		e = star.X
+	}
+	switch t := e.(type) {
+	case *ast.Ident:
+		return isExported(t.Name) || builtinTypes[t.Name]
+	case *ast.SelectorExpr:
+		return isExported(t.Sel.Name)
+	case *ast.ArrayType, *ast.MapType:
+		//未命名的复合类型 PkgPath 为空
+		return true
+	}
+	return false
+}
+
+var builtinTypes = map[string]bool{
+	"bool": true, "string": true, "error": true, "byte": true, "rune": true,
+	"int": true, "int8": true, "int16": true, "int32": true, "int64": true,
+	"uint": true, "uint8": true, "uint16": true, "uint32": true, "uint64": true, "uintptr": true,
+	"float32": true, "float64": true, "complex64": true, "complex128": true,
+}
+
+//记录类型表达式中引用的包
+func (p *pkgInfo) collectImports(file *ast.File, e ast.Expr) {
+	ast.Inspect(e, func(n ast.Node) bool {
+		sel, ok := n.(*ast.SelectorExpr)
+		if !ok {
+			return true
+		}
+		id, ok := sel.X.(*ast.Ident)
+		if !ok {
+			return true
+		}
+		for _, imp := range file.Imports {
+			path, _ := strconv.Unquote(imp.Path.Value)
+			name := path[strings.LastIndex(path, "/")+1:]
+			if imp.Name != nil {
+				name = imp.Name.Name
+			}
+			if name == id.Name {
+				p.imports[name] = path
+			}
+		}
+		return false
+	})
+}
+
+func isContext(e ast.Expr) bool {
+	sel, ok := e.(*ast.SelectorExpr)
+	if !ok {
+		return false
+	}
+	id, ok := sel.X.(*ast.Ident)
+	return ok && id.Name == "context" && sel.Sel.Name == "Context"
+}
+
+//找出 *typeName 上满足 rpc 要求的方法，规则与 suitableMethods 相同
+func (p *pkgInfo) service(typeName string) (*service, error) {
+	svc := &service{Name: typeName}
+	found := false
+	for _, file := range p.files {
+		for _, decl := range file.Decls {
+			if gd, ok := decl.(*ast.GenDecl); ok && gd.Tok == token.TYPE {
+				for _, spec := range gd.Specs {
+					if spec.(*ast.TypeSpec).Name.Name == typeName {
+						found = true
+					}
+				}
+			}
+
+			fd, ok := decl.(*ast.FuncDecl)
+			if !ok || fd.Recv == nil || !fd.Name.IsExported() {
+				continue
+			}
+			recv := fd.Recv.List[0].Type
+			if star, ok := recv.(*ast.StarExpr); ok {
+				recv = star.X
+			}
+			if id, ok := recv.(*ast.Ident); !ok || id.Name != typeName {
+				continue
+			}
+
+			var params []ast.Expr
+			for _, f := range fd.Type.Params.List {
+				n := len(f.Names)
+				if n == 0 {
+					n = 1
+				}
+				for i := 0; i < n; i++ {
+					params = append(params, f.Type)
+				}
+			}
+
+			m := &method{Name: fd.Name.Name}
+			if len(params) == 3 && isContext(params[0]) {
+				m.WithCtx = true
+				params = params[1:]
+			}
+			if len(params) != 2 {
+				continue
+			}
+			reply, ok := params[1].(*ast.StarExpr)
+			if !ok || !exportedOrBuiltin(params[0]) || !exportedOrBuiltin(params[1]) {
+				continue
+			}
+			results := fd.Type.Results
+			if results == nil || len(results.List) != 1 || len(results.List[0].Names) > 1 {
+				continue
+			}
+			if id, ok := results.List[0].Type.(*ast.Ident); !ok || id.Name != "error" {
+				continue
+			}
+
+			m.ArgType = p.expr(params[0])
+			m.ReplyType = p.expr(reply.X)
+			p.collectImports(file, params[0])
+			p.collectImports(file, reply.X)
+			svc.Methods = append(svc.Methods, m)
+		}
+	}
+
+	if !found {
+		return nil, errors.New("type " + typeName + " not found in package " + p.name)
+	}
+	if len(svc.Methods) == 0 {
+		return nil, errors.New("type " + typeName + " has no exported methods of suitable type")
+	}
+	sort.Slice(svc.Methods, func(i, j int) bool { return svc.Methods[i].Name < svc.Methods[j].Name })
+	return svc, nil
+}
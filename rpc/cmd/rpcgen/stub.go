@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"go/format"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+var stubTemplate = template.Must(template.New("stub").Parse(`// Code generated by rpcgen. DO NOT EDIT.
+
+package {{.Package}}
+
+import (
+{{- range .Imports}}
+	{{.}}{{end}}
+)
+{{range .Services}}
+func init() {
+	rpc.RegisterStubs((*{{.Name}})(nil), map[string]rpc.MethodStub{ {{- $svc := .Name}}{{range .Methods}}
+		"{{.Name}}": func(rcvr interface{}, ctx context.Context, args, reply interface{}) error {
+			return rcvr.(*{{$svc}}).{{.Name}}({{if .WithCtx}}ctx, {{end}}{{if .ArgIsPtr}}args.({{.ArgType}}){{else}}*args.(*{{.ArgType}}){{end}}, reply.(*{{.ReplyType}}))
+		},{{end}}
+	})
+}
+{{end}}`))
+
+const rpcPath = "github.com/shengzhch/learn/rpc"
+
+//生成文件需要的导入，标准库在前，其他包在后，中间空一行
+func (p *pkgInfo) importList(extra ...string) []string {
+	paths := map[string]string{}
+	for name, path := range p.imports {
+		paths[path] = name
+	}
+	for _, path := range extra {
+		paths[path] = path[strings.LastIndex(path, "/")+1:]
+	}
+
+	var std, other []string
+	for path, name := range paths {
+		spec := `"` + path + `"`
+		if !hasSuffixName(path, name) {
+			spec = name + " " + spec
+		}
+		if strings.Contains(strings.Split(path, "/")[0], ".") {
+			other = append(other, spec)
+		} else {
+			std = append(std, spec)
+		}
+	}
+	sort.Strings(std)
+	sort.Strings(other)
+	if len(std) > 0 && len(other) > 0 {
+		std = append(std, "")
+	}
+	return append(std, other...)
+}
+
+func hasSuffixName(path, name string) bool {
+	return len(path) >= len(name) && path[len(path)-len(name):] == name &&
+		(len(path) == len(name) || path[len(path)-len(name)-1] == '/')
+}
+
+func (p *pkgInfo) services(types []string) ([]*service, error) {
+	var list []*service
+	for _, name := range types {
+		svc, err := p.service(name)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, svc)
+	}
+	return list, nil
+}
+
+//为 types 生成分发函数
+func generateStubs(p *pkgInfo, types []string) ([]byte, error) {
+	services, err := p.services(types)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	err = stubTemplate.Execute(&buf, map[string]interface{}{
+		"Package":  p.name,
+		"Imports":  p.importList("context", rpcPath),
+		"Services": services,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return format.Source(buf.Bytes())
+}
@@ -0,0 +1,48 @@
+package arith
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+//go:generate go run github.com/shengzhch/learn/cmd/rpcgen -type Arith
+
+type Args struct {
+	A, B int
+}
+
+type Quotient struct {
+	Quo int `json:"quo"`
+	Rem int
+}
+
+type Arith int
+
+func (t *Arith) Multiply(args *Args, reply *int) error {
+	*reply = args.A * args.B
+	return nil
+}
+
+func (t *Arith) Divide(args Args, quo *Quotient) error {
+	if args.B == 0 {
+		return errors.New("divide by zero")
+	}
+	quo.Quo = args.A / args.B
+	quo.Rem = args.A % args.B
+	return nil
+}
+
+func (t *Arith) Sleep(ctx context.Context, d time.Duration, reply *bool) error {
+	select {
+	case <-time.After(d):
+		*reply = true
+	case <-ctx.Done():
+	}
+	return nil
+}
+
+//不满足要求的方法不会生成
+func (t *Arith) Reset() {}
+
+func (t *Arith) private(args *Args, reply *int) error { return nil }
@@ -0,0 +1,69 @@
+/*
+rpcreplay 把 rpc.Recorder 录制的调用发送到一个 jsonrpc 服务端，打印结果与录制不一致的调用：
+
+	rpcreplay -addr localhost:1234 calls.jsonl
+
+有不一致时退出码为 1。
+*/
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/shengzhch/learn/jsonrpc"
+	"github.com/shengzhch/learn/replay"
+	"github.com/shengzhch/learn/rpc"
+)
+
+var (
+	network = flag.String("net", "tcp", "network of the jsonrpc server")
+	addr    = flag.String("addr", "", "address of the jsonrpc server; must be set")
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage of rpcreplay:\n")
+	fmt.Fprintf(os.Stderr, "\trpcreplay -addr host:port recording\n")
+	flag.PrintDefaults()
+}
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("rpcreplay: ")
+	flag.Usage = usage
+	flag.Parse()
+	if *addr == "" || flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	f, err := os.Open(flag.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+	records, err := rpc.ReadRecords(f)
+	f.Close()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	client, err := jsonrpc.Dial(*network, *addr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer client.Close()
+
+	diffs, err := replay.ReplayClient(client, records)
+	for _, d := range diffs {
+		fmt.Print(d)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("%d calls, %d differ\n", len(records), len(diffs))
+	if len(diffs) > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,145 @@
+package jsonrpc
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+
+	"github.com/shengzhch/learn/rpc"
+)
+
+//(rpc.Request -- clientRequest -- rpc.Response -- clientResponse)
+
+type clientCodec struct {
+	dec *json.Decoder
+	enc *json.Encoder
+	c   io.Closer
+
+	req  clientRequest
+	resp clientResponse
+
+	//JSON-RPC 的 id 直接使用 rpc 的 Seq，这里记录 Seq 对应的方法名
+	mutex   sync.Mutex
+	pending map[uint64]string
+}
+
+func NewClientCodec(conn io.ReadWriteCloser) rpc.ClientCodec {
+	return &clientCodec{
+		dec:     json.NewDecoder(conn),
+		enc:     json.NewEncoder(conn),
+		c:       conn,
+		pending: make(map[uint64]string),
+	}
+}
+
+type clientRequest struct {
+	Method string         `json:"method"`
+	Params [1]interface{} `json:"params"`
+	Id     uint64         `json:"id"`
+	Meta   rpc.Metadata   `json:"meta,omitempty"`
+}
+
+func (c *clientCodec) WriteRequest(r *rpc.Request, param interface{}) error {
+	c.mutex.Lock()
+	c.pending[r.Seq] = r.ServiceMethod
+	c.mutex.Unlock()
+	c.req.Method = r.ServiceMethod
+	c.req.Params[0] = param
+	c.req.Id = r.Seq
+	c.req.Meta = r.Meta
+	return c.enc.Encode(&c.req)
+}
+
+//响应和服务端推送的通知共用一个结构，有 method 时为通知
+type clientResponse struct {
+	Id     *json.RawMessage `json:"id"`
+	Result *json.RawMessage `json:"result"`
+	Error  interface{}      `json:"error"`
+	Method string           `json:"method"`
+	Params *json.RawMessage `json:"params"`
+	Meta   rpc.Metadata     `json:"meta"`
+}
+
+func (r *clientResponse) reset() {
+	r.Id = nil
+	r.Result = nil
+	r.Error = nil
+	r.Method = ""
+	r.Params = nil
+	r.Meta = nil
+}
+
+func (c *clientCodec) ReadResponseHeader(r *rpc.Response) error {
+	c.resp.reset()
+	if err := c.dec.Decode(&c.resp); err != nil {
+		return err
+	}
+
+	if c.resp.Method != "" {
+		r.ServiceMethod = c.resp.Method
+		r.Seq = rpc.NotifySeqBit
+		r.Error = ""
+		return nil
+	}
+
+	var seq uint64
+	if c.resp.Id == nil || json.Unmarshal(*c.resp.Id, &seq) != nil {
+		return errors.New("invalid response id")
+	}
+
+	c.mutex.Lock()
+	r.ServiceMethod = c.pending[seq]
+	delete(c.pending, seq)
+	c.mutex.Unlock()
+
+	r.Error = ""
+	r.Seq = seq
+	r.Meta = c.resp.Meta
+	if c.resp.Error != nil || c.resp.Result == nil {
+		x, ok := c.resp.Error.(string)
+		if !ok {
+			return fmt.Errorf("invalid error %v", c.resp.Error)
+		}
+		if x == "" {
+			x = "unspecified error"
+		}
+		r.Error = x
+	}
+	return nil
+}
+
+func (c *clientCodec) ReadResponseBody(x interface{}) error {
+	if x == nil {
+		return nil
+	}
+
+	//推送的消息体在 params[0]
+	if c.resp.Method != "" {
+		if c.resp.Params == nil {
+			return errMissingParams
+		}
+		var params [1]interface{}
+		params[0] = x
+		return json.Unmarshal(*c.resp.Params, &params)
+	}
+	return json.Unmarshal(*c.resp.Result, x)
+}
+
+func (c *clientCodec) Close() error {
+	return c.c.Close()
+}
+
+func NewClient(conn io.ReadWriteCloser) *rpc.Client {
+	return rpc.NewClientWithCodec(NewClientCodec(conn))
+}
+
+func Dial(network, address string) (*rpc.Client, error) {
+	conn, err := net.Dial(network, address)
+	if err != nil {
+		return nil, err
+	}
+	return NewClient(conn), err
+}
@@ -0,0 +1,175 @@
+package jsonrpc
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/shengzhch/learn/rpc"
+)
+
+type Event struct {
+	N int
+}
+
+type Pusher int
+
+func (p *Pusher) Push(ctx context.Context, n int, reply *int) error {
+	c, _ := rpc.ConnFromContext(ctx)
+	for i := 0; i < n; i++ {
+		if err := c.Notify("Pusher.Event", &Event{N: i}); err != nil {
+			return err
+		}
+	}
+	*reply = n
+	return nil
+}
+
+func TestClientRoundTrip(t *testing.T) {
+	server := rpc.NewServer()
+	server.Register(new(Arith))
+	server.Register(new(Pusher))
+	cli, srv := net.Pipe()
+	go server.ServeCodec(NewServerCodec(srv))
+	client := NewClient(cli)
+	defer client.Close()
+
+	var sum int
+	if err := client.Call("Arith.Add", &Args{1, 2}, &sum); err != nil || sum != 3 {
+		t.Fatalf("Arith.Add = %d, %v", sum, err)
+	}
+	if _, ok := client.Call("Arith.Nope", &Args{}, &sum).(rpc.ServerError); !ok {
+		t.Error("unknown method should return a ServerError")
+	}
+
+	//并发调用按 id 对应到各自的结果
+	calls := make([]*rpc.Call, 10)
+	for i := range calls {
+		calls[i] = client.Go("Arith.Add", &Args{i, i}, new(int), nil)
+	}
+	for i, call := range calls {
+		<-call.Done
+		if call.Error != nil || *call.Reply.(*int) != 2*i {
+			t.Errorf("call %d = %d, %v", i, *call.Reply.(*int), call.Error)
+		}
+	}
+}
+
+func TestClientNotify(t *testing.T) {
+	server := rpc.NewServer()
+	server.Register(new(Pusher))
+	cli, srv := net.Pipe()
+	go server.ServeCodec(NewServerCodec(srv))
+	client := NewClient(cli)
+	defer client.Close()
+
+	events := make(chan *Event, 10)
+	client.OnNotify("Pusher.Event", func(e *Event) { events <- e })
+
+	var n int
+	if err := client.Call("Pusher.Push", 2, &n); err != nil || n != 2 {
+		t.Fatalf("Push = %d, %v", n, err)
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case e := <-events:
+			if e.N != i {
+				t.Errorf("event %d = %+v", i, e)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("event %d not received", i)
+		}
+	}
+}
+
+func TestServerSizeLimits(t *testing.T) {
+	tests := []struct {
+		name    string
+		request string
+		id      string
+		err     string
+	}{
+		//请求体过大时 id 已经读到
+		{"body", `{"method":"Arith.Add","params":[{"A":1,"B":2,"C":"` + strings.Repeat("x", 100) + `"}],"id":7}`, "7", "body exceeds 50 bytes"},
+		//整个请求超过请求头和请求体之和，用 null 回复
+		{"header", `{"method":"Arith.Add","params":[{"C":"` + strings.Repeat("x", 300) + `"}],"id":7}`, "null", "header exceeds 250 bytes"},
+	}
+	for _, tt := range tests {
+		server := rpc.NewServer()
+		server.Register(new(Arith))
+		server.SetMaxHeaderSize(200)
+		server.SetMaxBodySize(50)
+		cli, srv := net.Pipe()
+		go server.ServeCodec(NewServerCodec(srv))
+
+		go cli.Write([]byte(tt.request + "\n"))
+		r := bufio.NewReader(cli)
+		var resp struct {
+			Id    json.RawMessage
+			Error string
+		}
+		line, err := r.ReadBytes('\n')
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if err := json.Unmarshal(line, &resp); err != nil {
+			t.Fatalf("%s: %s: %v", tt.name, line, err)
+		}
+		if string(resp.Id) != tt.id || !strings.Contains(resp.Error, tt.err) {
+			t.Errorf("%s: response %s, want id %s and error %q", tt.name, line, tt.id, tt.err)
+		}
+		//之后连接被关闭
+		if _, err := r.ReadByte(); err == nil {
+			t.Errorf("%s: connection still open", tt.name)
+		}
+		cli.Close()
+	}
+}
+
+type Who int
+
+func (w *Who) Addr(ctx context.Context, args int, reply *string) error {
+	if p, ok := rpc.PeerFromContext(ctx); ok {
+		*reply = p.Addr
+	}
+	return nil
+}
+
+//ServerConn 使用 DefaultServer，-count 大于 1 时只能注册一次
+var registerWho sync.Once
+
+//ServerConn 把对端信息交给处理函数
+func TestServerConnPeer(t *testing.T) {
+	var err error
+	registerWho.Do(func() { err = rpc.RegisterName("JSONWho", new(Who)) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+	go func() {
+		conn, err := lis.Accept()
+		if err == nil {
+			ServerConn(conn)
+		}
+	}()
+
+	conn, err := net.Dial("tcp", lis.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := NewClient(conn)
+	defer client.Close()
+	var addr string
+	if err := client.Call("JSONWho.Addr", 0, &addr); err != nil || addr != conn.LocalAddr().String() {
+		t.Errorf("peer = %q, %v, want %q", addr, err, conn.LocalAddr())
+	}
+}
@@ -0,0 +1,171 @@
+package jsonrpc
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/shengzhch/learn/rpc"
+)
+
+/*
+通过 HTTP POST 承载 JSON-RPC 请求，支持单个请求和批量请求（JSON 数组）。
+没有 id（或 id 为 null）的请求是通知：照常执行，但不返回响应；全部是通知时回复 204。
+请求体的大小受 server 的 SetMaxHeaderSize + SetMaxBodySize 限制（批量请求整体计算），
+server 没有设置请求体限制时使用 MaxHTTPBodySize。
+*/
+
+const contentType = "application/json; charset=utf-8"
+
+//server 没有设置请求体限制时，HTTP 请求体的最大字节数
+var MaxHTTPBodySize int64 = 10 << 20
+
+type httpHandler struct {
+	server *rpc.Server
+}
+
+//返回一个 http.Handler，请求交由 server 分发
+func HTTPHandler(server *rpc.Server) http.Handler {
+	if server == nil {
+		server = rpc.DefaultServer
+	}
+	return &httpHandler{server: server}
+}
+
+//内存中的连接：读请求体，响应写入缓冲
+type bufConn struct {
+	io.Reader
+	bytes.Buffer
+}
+
+func (c *bufConn) Read(p []byte) (int, error) { return c.Reader.Read(p) }
+func (c *bufConn) Close() error               { return nil }
+
+func (h *httpHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		writeError(w, http.StatusMethodNotAllowed, "jsonrpc: method must be POST")
+		return
+	}
+
+	if ct := req.Header.Get("Content-Type"); ct != "" {
+		if mt, _, err := mime.ParseMediaType(ct); err != nil || mt != "application/json" {
+			writeError(w, http.StatusUnsupportedMediaType, "jsonrpc: content type must be application/json")
+			return
+		}
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, req.Body, h.maxBytes()))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeError(w, http.StatusRequestEntityTooLarge, "jsonrpc: request body exceeds "+strconv.FormatInt(tooLarge.Limit, 10)+" bytes")
+			return
+		}
+		writeError(w, http.StatusBadRequest, "jsonrpc: reading body: "+err.Error())
+		return
+	}
+
+	peer := rpc.HTTPPeer(req)
+	body = bytes.TrimSpace(body)
+	if !json.Valid(body) {
+		writeError(w, http.StatusBadRequest, "jsonrpc: parse error")
+		return
+	}
+
+	//单个请求
+	if body[0] != '[' {
+		reply := h.serveOne(body, peer)
+		if isNotification(body) {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.Header().Set("Content-Type", contentType)
+		w.WriteHeader(http.StatusOK)
+		w.Write(reply)
+		return
+	}
+
+	//批量请求
+	var batch []json.RawMessage
+	if err := json.Unmarshal(body, &batch); err != nil || len(batch) == 0 {
+		writeError(w, http.StatusBadRequest, "jsonrpc: invalid batch request")
+		return
+	}
+
+	replies := make([]json.RawMessage, len(batch))
+	wg := new(sync.WaitGroup)
+	for i := range batch {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			replies[i] = h.serveOne(batch[i], peer)
+		}(i)
+	}
+	wg.Wait()
+
+	//通知不出现在响应中
+	out := replies[:0]
+	for i, reply := range replies {
+		if !isNotification(batch[i]) {
+			out = append(out, reply)
+		}
+	}
+	if len(out) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	b, _ := json.Marshal(out)
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(http.StatusOK)
+	w.Write(b)
+}
+
+func (h *httpHandler) maxBytes() int64 {
+	maxHeader, maxBody := h.server.SizeLimits()
+	if maxBody == 0 {
+		return MaxHTTPBodySize
+	}
+	if maxHeader == 0 {
+		maxHeader = rpc.DefaultHeaderAllowance
+	}
+	return int64(maxHeader + maxBody)
+}
+
+//没有 id 或 id 为 null 的请求是通知
+func isNotification(msg []byte) bool {
+	var req struct {
+		Id *json.RawMessage `json:"id"`
+	}
+	if err := json.Unmarshal(msg, &req); err != nil {
+		//无法解析的请求需要回复错误
+		return false
+	}
+	return req.Id == nil
+}
+
+//同步处理一个请求，返回编码好的响应
+func (h *httpHandler) serveOne(msg []byte, peer *rpc.Peer) json.RawMessage {
+	conn := &bufConn{Reader: bytes.NewReader(msg)}
+	h.server.ServeRequestPeer(NewServerCodec(conn), peer)
+
+	//请求头都无法解码时，server 不会写响应
+	if conn.Len() == 0 {
+		b, _ := json.Marshal(serverResponse{Id: &null, Error: "jsonrpc: invalid request"})
+		return b
+	}
+	return json.RawMessage(bytes.TrimSpace(conn.Bytes()))
+}
+
+func writeError(w http.ResponseWriter, code int, msg string) {
+	b, _ := json.Marshal(serverResponse{Id: &null, Error: msg})
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(code)
+	w.Write(b)
+}
@@ -0,0 +1,156 @@
+package jsonrpc
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/shengzhch/learn/rpc"
+)
+
+type Args struct {
+	A, B int
+}
+
+type Arith struct {
+	calls int32
+}
+
+func (t *Arith) Add(args *Args, reply *int) error {
+	atomic.AddInt32(&t.calls, 1)
+	*reply = args.A + args.B
+	return nil
+}
+
+func newHTTPServer(t *testing.T) (*rpc.Server, *Arith, *httptest.Server) {
+	server := rpc.NewServer()
+	arith := new(Arith)
+	if err := server.Register(arith); err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(HTTPHandler(server))
+	t.Cleanup(ts.Close)
+	return server, arith, ts
+}
+
+func post(t *testing.T, url, body string) (int, string) {
+	resp, err := http.Post(url, "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, string(b)
+}
+
+func TestHTTPSingle(t *testing.T) {
+	_, _, ts := newHTTPServer(t)
+	code, body := post(t, ts.URL, `{"method":"Arith.Add","params":[{"A":1,"B":2}],"id":7}`)
+	if code != http.StatusOK {
+		t.Fatalf("status %d: %s", code, body)
+	}
+	var resp struct {
+		Id     int
+		Result int
+		Error  interface{}
+	}
+	if err := json.Unmarshal([]byte(body), &resp); err != nil || resp.Id != 7 || resp.Result != 3 || resp.Error != nil {
+		t.Fatalf("response %s, %v", body, err)
+	}
+}
+
+func TestHTTPBatch(t *testing.T) {
+	_, arith, ts := newHTTPServer(t)
+	code, body := post(t, ts.URL, `[
+		{"method":"Arith.Add","params":[{"A":1,"B":2}],"id":1},
+		{"method":"Arith.Add","params":[{"A":5,"B":5}]},
+		{"method":"Arith.Nope","params":[{}],"id":2}
+	]`)
+	if code != http.StatusOK {
+		t.Fatalf("status %d: %s", code, body)
+	}
+	var resp []struct {
+		Id     int
+		Result int
+		Error  interface{}
+	}
+	if err := json.Unmarshal([]byte(body), &resp); err != nil {
+		t.Fatalf("response %s: %v", body, err)
+	}
+	//通知不出现在响应中
+	if len(resp) != 2 || resp[0].Id != 1 || resp[0].Result != 3 || resp[1].Id != 2 || resp[1].Error == nil {
+		t.Fatalf("response %s", body)
+	}
+	if n := atomic.LoadInt32(&arith.calls); n != 2 {
+		t.Errorf("Add called %d times, want 2", n)
+	}
+}
+
+func TestHTTPNotification(t *testing.T) {
+	_, arith, ts := newHTTPServer(t)
+	for _, req := range []string{
+		`{"method":"Arith.Add","params":[{"A":1,"B":2}]}`,
+		`{"method":"Arith.Add","params":[{"A":1,"B":2}],"id":null}`,
+		`[{"method":"Arith.Add","params":[{"A":1,"B":2}]}]`,
+	} {
+		if code, body := post(t, ts.URL, req); code != http.StatusNoContent || body != "" {
+			t.Errorf("%s: status %d, body %q", req, code, body)
+		}
+	}
+	if n := atomic.LoadInt32(&arith.calls); n != 3 {
+		t.Errorf("Add called %d times, want 3", n)
+	}
+}
+
+func TestHTTPErrors(t *testing.T) {
+	server, _, ts := newHTTPServer(t)
+	for _, tt := range []struct {
+		body string
+		code int
+	}{
+		{`{"method":`, http.StatusBadRequest},
+		{`[]`, http.StatusBadRequest},
+		{`   `, http.StatusBadRequest},
+	} {
+		code, body := post(t, ts.URL, tt.body)
+		if code != tt.code || !strings.Contains(body, `"error":"jsonrpc:`) {
+			t.Errorf("%q: status %d, body %s", tt.body, code, body)
+		}
+	}
+
+	resp, err := http.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("GET status %d", resp.StatusCode)
+	}
+
+	server.SetMaxBodySize(100)
+	server.SetMaxHeaderSize(100)
+	big := `{"method":"Arith.Add","params":[{"A":1,"B":2,"C":"` + strings.Repeat("x", 300) + `"}],"id":1}`
+	if code, body := post(t, ts.URL, big); code != http.StatusRequestEntityTooLarge {
+		t.Errorf("oversized body: status %d, body %s", code, body)
+	}
+}
+
+//处理函数看到 HTTP 客户端的地址
+func TestHTTPPeer(t *testing.T) {
+	server, _, ts := newHTTPServer(t)
+	server.Register(new(Who))
+	code, body := post(t, ts.URL, `{"method":"Who.Addr","params":[0],"id":1}`)
+	var resp struct {
+		Result string
+	}
+	if err := json.Unmarshal([]byte(body), &resp); err != nil || code != http.StatusOK || !strings.HasPrefix(resp.Result, "127.0.0.1:") {
+		t.Errorf("status %d: %s, %v", code, body, err)
+	}
+}
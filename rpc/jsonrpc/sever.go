@@ -6,6 +6,7 @@ import (
 	"github.com/shengzhch/learn/rpc"
 	"io"
 	"sync"
+	"time"
 )
 
 //(rpc.Requese -- serverRequst -- rpc.Response -- serverResponse)
@@ -13,6 +14,7 @@ import (
 var errMissingParams = errors.New("jsonrpc: request body miss params")
 
 type serverCodec struct {
+	lim     *limitReader
 	dec     *json.Decoder
 	enc     *json.Encoder
 	c       io.Closer
@@ -20,11 +22,16 @@ type serverCodec struct {
 	mux     sync.Mutex
 	seq     uint64
 	pending map[uint64]*json.RawMessage
+
+	maxHeader int
+	maxBody   int
 }
 
 func NewServerCodec(conn io.ReadWriteCloser) rpc.ServerCodec {
+	lim := &limitReader{r: conn}
 	return &serverCodec{
-		dec:     json.NewDecoder(conn),
+		lim:     lim,
+		dec:     json.NewDecoder(lim),
 		enc:     json.NewEncoder(conn),
 		c:       conn,
 		pending: make(map[uint64]*json.RawMessage),
@@ -35,10 +42,12 @@ type serverRequest struct {
 	Method string           `json:"method"`
 	Params *json.RawMessage `json:"params"`
 	Id     *json.RawMessage `json:"id"`
+	Meta   rpc.Metadata     `json:"meta,omitempty"`
 }
 
 func (r *serverRequest) reset() {
 	r.Method = ""
+	r.Meta = nil
 	r.Params = nil
 	r.Id = nil
 }
@@ -47,15 +56,37 @@ type serverResponse struct {
 	Id     *json.RawMessage `json:"id"`
 	Result interface{}      `json:"result"`
 	Error  interface{}      `json:"error"`
+	Meta   rpc.Metadata     `json:"meta,omitempty"`
+}
+
+//服务端主动推送，id 为 null
+type serverNotification struct {
+	Method string           `json:"method"`
+	Params [1]interface{}   `json:"params"`
+	Id     *json.RawMessage `json:"id"`
 }
 
 //ReadRequseHeader
 func (c *serverCodec) ReadRequestHeader(r *rpc.Request) error {
 	c.req.reset()
+	//请求头和参数在同一个 JSON 对象里，整体不能超过两者之和
+	if c.maxHeader > 0 || c.maxBody > 0 {
+		c.lim.limit = c.maxHeader + c.maxBody
+		c.lim.max = c.dec.InputOffset() + int64(c.lim.limit)
+	}
 	if err := c.dec.Decode(&c.req); err != nil {
+		if _, ok := err.(*rpc.FrameTooLargeError); ok {
+			//id 还没读到，用 null 回复错误
+			c.mux.Lock()
+			c.seq++
+			c.pending[c.seq] = nil
+			r.Seq = c.seq
+			c.mux.Unlock()
+		}
 		return err
 	}
 	r.ServiceMethod = c.req.Method
+	r.Meta = c.req.Meta
 
 	c.mux.Lock()
 	c.seq++
@@ -76,6 +107,9 @@ func (c *serverCodec) ReadRequestBody(x interface{}) error {
 	if c.req.Params == nil {
 		return errMissingParams
 	}
+	if c.maxBody > 0 && len(*c.req.Params) > c.maxBody {
+		return &rpc.FrameTooLargeError{Part: "body", Limit: c.maxBody}
+	}
 	var params [1]interface{}
 	params[0] = x
 	return json.Unmarshal(*c.req.Params, &params)
@@ -86,6 +120,11 @@ var null = json.RawMessage([]byte("null"))
 
 //WriteResponse
 func (c *serverCodec) WriteResponse(r *rpc.Response, x interface{}) error {
+	//服务端推送写成 JSON-RPC 通知
+	if rpc.IsNotifySeq(r.Seq) {
+		return c.enc.Encode(serverNotification{Method: r.ServiceMethod, Params: [1]interface{}{x}, Id: &null})
+	}
+
 	c.mux.Lock()
 	b, ok := c.pending[r.Seq]
 	if !ok {
@@ -99,7 +138,7 @@ func (c *serverCodec) WriteResponse(r *rpc.Response, x interface{}) error {
 		b = &null
 	}
 
-	resp := serverResponse{Id: b}
+	resp := serverResponse{Id: b, Meta: r.Meta}
 
 	if r.Error == "" {
 		resp.Result = x
@@ -110,10 +149,45 @@ func (c *serverCodec) WriteResponse(r *rpc.Response, x interface{}) error {
 	return c.enc.Encode(resp)
 }
 
+func (c *serverCodec) SetSizeLimits(maxHeader, maxBody int) {
+	c.maxHeader = maxHeader
+	c.maxBody = maxBody
+}
+
+func (c *serverCodec) SetReadDeadline(t time.Time) error {
+	return rpc.SetReadDeadline(c.c, t)
+}
+
+func (c *serverCodec) SetWriteDeadline(t time.Time) error {
+	return rpc.SetWriteDeadline(c.c, t)
+}
+
 func (c *serverCodec) Close() error {
 	return c.c.Close()
 }
 
+//读到 max 字节后返回 FrameTooLargeError，max 为 0 时不限制
+type limitReader struct {
+	r     io.Reader
+	n     int64 //已读字节数
+	max   int64
+	limit int //当前请求的限制，用于错误信息
+}
+
+func (l *limitReader) Read(p []byte) (int, error) {
+	if l.max > 0 {
+		if l.n >= l.max {
+			return 0, &rpc.FrameTooLargeError{Part: "header", Limit: l.limit}
+		}
+		if int64(len(p)) > l.max-l.n {
+			p = p[:l.max-l.n]
+		}
+	}
+	n, err := l.r.Read(p)
+	l.n += int64(n)
+	return n, err
+}
+
 func ServerConn(conn io.ReadWriteCloser) {
-	rpc.ServeCodec(NewServerCodec(conn))
+	rpc.DefaultServer.ServeCodecPeer(NewServerCodec(conn), rpc.PeerOf(conn))
 }
@@ -0,0 +1,189 @@
+package msgpackrpc
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"reflect"
+	"strconv"
+	"time"
+
+	"github.com/shengzhch/learn/rpc"
+)
+
+/*
+MessagePack 编解码器。
+每个消息是一个帧：4 字节大端长度 + 负载，负载是头（Request/Response）和消息体两个 msgpack 值。
+*/
+
+var errBody = errors.New("msgpackrpc: body must be a non-nil pointer")
+
+//单个帧的最大长度，长度前缀超过它时不分配缓冲，直接返回错误
+var MaxFrameSize = 64 << 20
+
+//读一个帧，返回负载
+func readFrame(r io.Reader, buf []byte) ([]byte, error) {
+	var hdr [4]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return buf, err
+	}
+	n := int(binary.BigEndian.Uint32(hdr[:]))
+	if n > MaxFrameSize {
+		return buf, errors.New("msgpackrpc: frame of " + strconv.Itoa(n) + " bytes exceeds " + strconv.Itoa(MaxFrameSize))
+	}
+	if cap(buf) < n {
+		buf = make([]byte, n)
+	}
+	buf = buf[:n]
+	if _, err := io.ReadFull(r, buf); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return buf, err
+	}
+	return buf, nil
+}
+
+//编码头和消息体写成一个帧
+func writeFrame(w *bufio.Writer, buf []byte, header, body interface{}) ([]byte, error) {
+	buf = append(buf[:0], 0, 0, 0, 0)
+	var err error
+	if buf, err = appendValue(buf, reflect.ValueOf(header)); err != nil {
+		return buf, err
+	}
+	if buf, err = appendValue(buf, reflect.ValueOf(body)); err != nil {
+		return buf, err
+	}
+	binary.BigEndian.PutUint32(buf, uint32(len(buf)-4))
+	if _, err = w.Write(buf); err != nil {
+		return buf, err
+	}
+	return buf, w.Flush()
+}
+
+//消息体解码到 body，body 为 nil 时丢弃
+func decodeBody(d *decoder, body interface{}) error {
+	if body == nil {
+		return nil
+	}
+	v := reflect.ValueOf(body)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return errBody
+	}
+	return d.decode(v.Elem())
+}
+
+type serverCodec struct {
+	rwc    io.ReadWriteCloser
+	br     *bufio.Reader
+	bw     *bufio.Writer
+	rbuf   []byte
+	wbuf   []byte
+	dec    decoder
+	closed bool
+}
+
+func NewServerCodec(conn io.ReadWriteCloser) rpc.ServerCodec {
+	return &serverCodec{
+		rwc: conn,
+		br:  bufio.NewReader(conn),
+		bw:  bufio.NewWriter(conn),
+	}
+}
+
+func (c *serverCodec) ReadRequestHeader(r *rpc.Request) error {
+	var err error
+	if c.rbuf, err = readFrame(c.br, c.rbuf); err != nil {
+		return err
+	}
+	c.dec = decoder{buf: c.rbuf}
+	*r = rpc.Request{}
+	return c.dec.decode(reflect.ValueOf(r).Elem())
+}
+
+func (c *serverCodec) ReadRequestBody(body interface{}) error {
+	return decodeBody(&c.dec, body)
+}
+
+func (c *serverCodec) WriteResponse(r *rpc.Response, body interface{}) (err error) {
+	if r.Error != "" {
+		body = nil
+	}
+	c.wbuf, err = writeFrame(c.bw, c.wbuf, r, body)
+	return
+}
+
+func (c *serverCodec) SetReadDeadline(t time.Time) error {
+	return rpc.SetReadDeadline(c.rwc, t)
+}
+
+func (c *serverCodec) SetWriteDeadline(t time.Time) error {
+	return rpc.SetWriteDeadline(c.rwc, t)
+}
+
+func (c *serverCodec) Close() error {
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	return c.rwc.Close()
+}
+
+type clientCodec struct {
+	rwc  io.ReadWriteCloser
+	br   *bufio.Reader
+	bw   *bufio.Writer
+	rbuf []byte
+	wbuf []byte
+	dec  decoder
+}
+
+func NewClientCodec(conn io.ReadWriteCloser) rpc.ClientCodec {
+	return &clientCodec{
+		rwc: conn,
+		br:  bufio.NewReader(conn),
+		bw:  bufio.NewWriter(conn),
+	}
+}
+
+func (c *clientCodec) WriteRequest(r *rpc.Request, body interface{}) (err error) {
+	c.wbuf, err = writeFrame(c.bw, c.wbuf, r, body)
+	return
+}
+
+func (c *clientCodec) ReadResponseHeader(r *rpc.Response) error {
+	var err error
+	if c.rbuf, err = readFrame(c.br, c.rbuf); err != nil {
+		return err
+	}
+	c.dec = decoder{buf: c.rbuf}
+	*r = rpc.Response{}
+	return c.dec.decode(reflect.ValueOf(r).Elem())
+}
+
+func (c *clientCodec) ReadResponseBody(body interface{}) error {
+	return decodeBody(&c.dec, body)
+}
+
+func (c *clientCodec) Close() error {
+	return c.rwc.Close()
+}
+
+//使用 MessagePack 处理连接
+func ServeConn(conn io.ReadWriteCloser) {
+	rpc.DefaultServer.ServeCodecPeer(NewServerCodec(conn), rpc.PeerOf(conn))
+}
+
+func NewClient(conn io.ReadWriteCloser) *rpc.Client {
+	return rpc.NewClientWithCodec(NewClientCodec(conn))
+}
+
+func Dial(network, address string) (*rpc.Client, error) {
+	conn, err := net.Dial(network, address)
+	if err != nil {
+		return nil, err
+	}
+	return NewClient(conn), nil
+}
@@ -0,0 +1,519 @@
+package msgpackrpc
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+/*
+MessagePack 的最小实现，基于 reflect：
+支持 nil/bool/整数/浮点/string/[]byte/slice/array/map/struct/指针/interface{}。
+struct 编码为以字段名为键的 map，可以用 `msgpack:"name"` 改名，"-" 忽略字段。
+不支持 ext 类型，解码时直接跳过。
+*/
+
+var (
+	errShortBuffer = errors.New("msgpack: unexpected end of data")
+	errTooDeep     = errors.New("msgpack: exceeded max nesting depth")
+)
+
+//数组和 map 的最大嵌套层数，和 encoding/json 相同；递归没有上限时一帧数据就能让栈溢出
+const maxDepth = 10000
+
+//字段信息按类型缓存
+type field struct {
+	name  string
+	index int
+}
+
+var fieldCache sync.Map // map[reflect.Type][]field
+
+func structFields(t reflect.Type) []field {
+	if f, ok := fieldCache.Load(t); ok {
+		return f.([]field)
+	}
+	var fields []field
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if sf.PkgPath != "" {
+			continue
+		}
+		name := sf.Name
+		if tag := sf.Tag.Get("msgpack"); tag != "" {
+			if tag == "-" {
+				continue
+			}
+			name = strings.Split(tag, ",")[0]
+		}
+		fields = append(fields, field{name: name, index: i})
+	}
+	fieldCache.Store(t, fields)
+	return fields
+}
+
+//编码，追加到 b 后面
+func appendValue(b []byte, v reflect.Value) ([]byte, error) {
+	if !v.IsValid() {
+		return append(b, 0xc0), nil
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return append(b, 0xc0), nil
+		}
+		return appendValue(b, v.Elem())
+	case reflect.Bool:
+		if v.Bool() {
+			return append(b, 0xc3), nil
+		}
+		return append(b, 0xc2), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return appendInt(b, v.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return appendUint(b, v.Uint()), nil
+	case reflect.Float32:
+		b = append(b, 0xca)
+		return binary.BigEndian.AppendUint32(b, math.Float32bits(float32(v.Float()))), nil
+	case reflect.Float64:
+		b = append(b, 0xcb)
+		return binary.BigEndian.AppendUint64(b, math.Float64bits(v.Float())), nil
+	case reflect.String:
+		return appendString(b, v.String()), nil
+	case reflect.Slice:
+		if v.IsNil() {
+			return append(b, 0xc0), nil
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return appendBytes(b, v.Bytes()), nil
+		}
+		fallthrough
+	case reflect.Array:
+		n := v.Len()
+		b = appendLen(b, n, 0x90, 0xdc, 0xdd)
+		var err error
+		for i := 0; i < n; i++ {
+			if b, err = appendValue(b, v.Index(i)); err != nil {
+				return b, err
+			}
+		}
+		return b, nil
+	case reflect.Map:
+		if v.IsNil() {
+			return append(b, 0xc0), nil
+		}
+		b = appendLen(b, v.Len(), 0x80, 0xde, 0xdf)
+		var err error
+		iter := v.MapRange()
+		for iter.Next() {
+			if b, err = appendValue(b, iter.Key()); err != nil {
+				return b, err
+			}
+			if b, err = appendValue(b, iter.Value()); err != nil {
+				return b, err
+			}
+		}
+		return b, nil
+	case reflect.Struct:
+		fields := structFields(v.Type())
+		b = appendLen(b, len(fields), 0x80, 0xde, 0xdf)
+		var err error
+		for _, f := range fields {
+			b = appendString(b, f.name)
+			if b, err = appendValue(b, v.Field(f.index)); err != nil {
+				return b, err
+			}
+		}
+		return b, nil
+	}
+	return b, fmt.Errorf("msgpack: unsupported type %s", v.Type())
+}
+
+func appendInt(b []byte, n int64) []byte {
+	switch {
+	case n >= 0:
+		return appendUint(b, uint64(n))
+	case n >= -32:
+		return append(b, byte(n))
+	case n >= math.MinInt8:
+		return append(b, 0xd0, byte(n))
+	case n >= math.MinInt16:
+		return binary.BigEndian.AppendUint16(append(b, 0xd1), uint16(n))
+	case n >= math.MinInt32:
+		return binary.BigEndian.AppendUint32(append(b, 0xd2), uint32(n))
+	}
+	return binary.BigEndian.AppendUint64(append(b, 0xd3), uint64(n))
+}
+
+func appendUint(b []byte, n uint64) []byte {
+	switch {
+	case n <= 0x7f:
+		return append(b, byte(n))
+	case n <= math.MaxUint8:
+		return append(b, 0xcc, byte(n))
+	case n <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(b, 0xcd), uint16(n))
+	case n <= math.MaxUint32:
+		return binary.BigEndian.AppendUint32(append(b, 0xce), uint32(n))
+	}
+	return binary.BigEndian.AppendUint64(append(b, 0xcf), n)
+}
+
+func appendString(b []byte, s string) []byte {
+	n := len(s)
+	switch {
+	case n < 32:
+		b = append(b, 0xa0|byte(n))
+	case n <= math.MaxUint8:
+		b = append(b, 0xd9, byte(n))
+	case n <= math.MaxUint16:
+		b = binary.BigEndian.AppendUint16(append(b, 0xda), uint16(n))
+	default:
+		b = binary.BigEndian.AppendUint32(append(b, 0xdb), uint32(n))
+	}
+	return append(b, s...)
+}
+
+func appendBytes(b []byte, p []byte) []byte {
+	n := len(p)
+	switch {
+	case n <= math.MaxUint8:
+		b = append(b, 0xc4, byte(n))
+	case n <= math.MaxUint16:
+		b = binary.BigEndian.AppendUint16(append(b, 0xc5), uint16(n))
+	default:
+		b = binary.BigEndian.AppendUint32(append(b, 0xc6), uint32(n))
+	}
+	return append(b, p...)
+}
+
+//数组和 map 的长度前缀
+func appendLen(b []byte, n int, fix, c16, c32 byte) []byte {
+	switch {
+	case n < 16:
+		return append(b, fix|byte(n))
+	case n <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(b, c16), uint16(n))
+	}
+	return binary.BigEndian.AppendUint32(append(b, c32), uint32(n))
+}
+
+//解码器，数据已经完整的在内存中
+type decoder struct {
+	buf   []byte
+	off   int
+	depth int //当前的嵌套层数
+}
+
+func (d *decoder) readByte() (byte, error) {
+	if d.off >= len(d.buf) {
+		return 0, errShortBuffer
+	}
+	c := d.buf[d.off]
+	d.off++
+	return c, nil
+}
+
+func (d *decoder) next(n int) ([]byte, error) {
+	if n < 0 || len(d.buf)-d.off < n {
+		return nil, errShortBuffer
+	}
+	p := d.buf[d.off : d.off+n]
+	d.off += n
+	return p, nil
+}
+
+func (d *decoder) uintN(n int) (uint64, error) {
+	p, err := d.next(n)
+	if err != nil {
+		return 0, err
+	}
+	switch n {
+	case 1:
+		return uint64(p[0]), nil
+	case 2:
+		return uint64(binary.BigEndian.Uint16(p)), nil
+	case 4:
+		return uint64(binary.BigEndian.Uint32(p)), nil
+	}
+	return binary.BigEndian.Uint64(p), nil
+}
+
+//读出下一个值的通用表示：nil/bool/int64/uint64/float64/string/[]byte/[]interface{}/map[string]interface{}
+func (d *decoder) value() (interface{}, error) {
+	c, err := d.readByte()
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case c <= 0x7f:
+		return int64(c), nil
+	case c >= 0xe0:
+		return int64(int8(c)), nil
+	case c&0xe0 == 0xa0:
+		p, err := d.next(int(c & 0x1f))
+		return string(p), err
+	case c&0xf0 == 0x90:
+		return d.array(int(c & 0x0f))
+	case c&0xf0 == 0x80:
+		return d.mapping(int(c & 0x0f))
+	}
+
+	switch c {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		return d.uintN(1 << (c - 0xcc))
+	case 0xd0, 0xd1, 0xd2, 0xd3:
+		n := 1 << (c - 0xd0)
+		u, err := d.uintN(n)
+		if err != nil {
+			return nil, err
+		}
+		shift := uint(64 - 8*n)
+		return int64(u<<shift) >> shift, nil
+	case 0xca:
+		u, err := d.uintN(4)
+		return float64(math.Float32frombits(uint32(u))), err
+	case 0xcb:
+		u, err := d.uintN(8)
+		return math.Float64frombits(u), err
+	case 0xd9, 0xda, 0xdb:
+		n, err := d.uintN(1 << (c - 0xd9))
+		if err != nil {
+			return nil, err
+		}
+		p, err := d.next(int(n))
+		return string(p), err
+	case 0xc4, 0xc5, 0xc6:
+		n, err := d.uintN(1 << (c - 0xc4))
+		if err != nil {
+			return nil, err
+		}
+		p, err := d.next(int(n))
+		return append([]byte(nil), p...), err
+	case 0xdc, 0xdd:
+		n, err := d.uintN(2 << (c - 0xdc))
+		if err != nil {
+			return nil, err
+		}
+		return d.array(int(n))
+	case 0xde, 0xdf:
+		n, err := d.uintN(2 << (c - 0xde))
+		if err != nil {
+			return nil, err
+		}
+		return d.mapping(int(n))
+	case 0xd4, 0xd5, 0xd6, 0xd7, 0xd8:
+		//fixext：1 字节类型 + 定长数据
+		_, err := d.next(1 + 1<<(c-0xd4))
+		return nil, err
+	case 0xc7, 0xc8, 0xc9:
+		n, err := d.uintN(1 << (c - 0xc7))
+		if err != nil {
+			return nil, err
+		}
+		_, err = d.next(int(n) + 1)
+		return nil, err
+	}
+	return nil, fmt.Errorf("msgpack: invalid code %#x", c)
+}
+
+//进入一层数组或 map，返回的函数用于退出
+func (d *decoder) enter() (func(), error) {
+	if d.depth >= maxDepth {
+		return nil, errTooDeep
+	}
+	d.depth++
+	return func() { d.depth-- }, nil
+}
+
+func (d *decoder) array(n int) (interface{}, error) {
+	if n > len(d.buf)-d.off {
+		return nil, errShortBuffer
+	}
+	leave, err := d.enter()
+	if err != nil {
+		return nil, err
+	}
+	defer leave()
+	a := make([]interface{}, n)
+	for i := range a {
+		var err error
+		if a[i], err = d.value(); err != nil {
+			return nil, err
+		}
+	}
+	return a, nil
+}
+
+func (d *decoder) mapping(n int) (interface{}, error) {
+	if n > len(d.buf)-d.off {
+		return nil, errShortBuffer
+	}
+	leave, err := d.enter()
+	if err != nil {
+		return nil, err
+	}
+	defer leave()
+	m := make(map[string]interface{}, n)
+	for i := 0; i < n; i++ {
+		k, err := d.value()
+		if err != nil {
+			return nil, err
+		}
+		v, err := d.value()
+		if err != nil {
+			return nil, err
+		}
+		m[fmt.Sprint(k)] = v
+	}
+	return m, nil
+}
+
+//解码到 v，v 必须可设置
+func (d *decoder) decode(v reflect.Value) error {
+	x, err := d.value()
+	if err != nil {
+		return err
+	}
+	return assign(v, x)
+}
+
+//把通用表示赋值给具体类型
+func assign(v reflect.Value, x interface{}) error {
+	if x == nil {
+		v.Set(reflect.Zero(v.Type()))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return assign(v.Elem(), x)
+	case reflect.Interface:
+		if v.NumMethod() == 0 {
+			v.Set(reflect.ValueOf(x))
+			return nil
+		}
+	case reflect.Bool:
+		if b, ok := x.(bool); ok {
+			v.SetBool(b)
+			return nil
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		switch n := x.(type) {
+		case int64:
+			v.SetInt(n)
+			return nil
+		case uint64:
+			v.SetInt(int64(n))
+			return nil
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		switch n := x.(type) {
+		case int64:
+			v.SetUint(uint64(n))
+			return nil
+		case uint64:
+			v.SetUint(n)
+			return nil
+		}
+	case reflect.Float32, reflect.Float64:
+		switch n := x.(type) {
+		case float64:
+			v.SetFloat(n)
+			return nil
+		case int64:
+			v.SetFloat(float64(n))
+			return nil
+		case uint64:
+			v.SetFloat(float64(n))
+			return nil
+		}
+	case reflect.String:
+		if s, ok := x.(string); ok {
+			v.SetString(s)
+			return nil
+		}
+	case reflect.Slice:
+		if p, ok := x.([]byte); ok && v.Type().Elem().Kind() == reflect.Uint8 {
+			v.SetBytes(p)
+			return nil
+		}
+		if a, ok := x.([]interface{}); ok {
+			s := reflect.MakeSlice(v.Type(), len(a), len(a))
+			for i := range a {
+				if err := assign(s.Index(i), a[i]); err != nil {
+					return err
+				}
+			}
+			v.Set(s)
+			return nil
+		}
+	case reflect.Array:
+		if a, ok := x.([]interface{}); ok {
+			for i := 0; i < v.Len() && i < len(a); i++ {
+				if err := assign(v.Index(i), a[i]); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	case reflect.Map:
+		if m, ok := x.(map[string]interface{}); ok {
+			if v.IsNil() {
+				v.Set(reflect.MakeMapWithSize(v.Type(), len(m)))
+			}
+			kt, et := v.Type().Key(), v.Type().Elem()
+			for k, e := range m {
+				kv := reflect.New(kt).Elem()
+				if kt.Kind() == reflect.String {
+					kv.SetString(k)
+				} else if _, err := fmt.Sscan(k, kv.Addr().Interface()); err != nil {
+					return fmt.Errorf("msgpack: cannot decode map key %q into %s", k, kt)
+				}
+				ev := reflect.New(et).Elem()
+				if err := assign(ev, e); err != nil {
+					return err
+				}
+				v.SetMapIndex(kv, ev)
+			}
+			return nil
+		}
+	case reflect.Struct:
+		if m, ok := x.(map[string]interface{}); ok {
+			for _, f := range structFields(v.Type()) {
+				e, ok := m[f.name]
+				if !ok {
+					for k := range m {
+						if strings.EqualFold(k, f.name) {
+							e, ok = m[k], true
+							break
+						}
+					}
+				}
+				if !ok {
+					continue
+				}
+				if err := assign(v.Field(f.index), e); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("msgpack: cannot decode %T into %s", x, v.Type())
+}
@@ -0,0 +1,86 @@
+package msgpackrpc
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type inner struct {
+	Name string `msgpack:"n"`
+	Skip int    `msgpack:"-"`
+}
+
+type outer struct {
+	I   int
+	U   uint16
+	F   float64
+	B   bool
+	S   []string
+	Raw []byte
+	M   map[string]int
+	P   *inner
+	Arr [2]int8
+	Any interface{}
+}
+
+func TestRoundTrip(t *testing.T) {
+	values := []interface{}{
+		0, -1, -33, -200, -40000, -3000000000, 127, 255, 65535, 1 << 40,
+		"", "short", string(make([]byte, 300)),
+		outer{
+			I: -129, U: 300, F: 1.5, B: true,
+			S:   []string{"a", "b"},
+			Raw: []byte{1, 2, 3},
+			M:   map[string]int{"x": 1},
+			P:   &inner{Name: "p"},
+			Arr: [2]int8{-1, 1},
+			Any: "any",
+		},
+	}
+
+	for _, want := range values {
+		b, err := appendValue(nil, reflect.ValueOf(want))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := reflect.New(reflect.TypeOf(want))
+		d := decoder{buf: b}
+		if err := d.decode(got.Elem()); err != nil {
+			t.Fatalf("%v: %v", want, err)
+		}
+		if d.off != len(b) {
+			t.Fatalf("%v: %d trailing bytes", want, len(b)-d.off)
+		}
+		if !reflect.DeepEqual(got.Elem().Interface(), want) {
+			t.Fatalf("got %#v, want %#v", got.Elem().Interface(), want)
+		}
+	}
+}
+
+func TestFrameTooLarge(t *testing.T) {
+	_, err := readFrame(bytes.NewReader([]byte{0xff, 0xff, 0xff, 0xff}), nil)
+	if err == nil || !strings.Contains(err.Error(), "exceeds") {
+		t.Fatalf("4 GiB length prefix: %v", err)
+	}
+}
+
+//深层嵌套的数据返回错误而不是栈溢出
+func TestMaxDepth(t *testing.T) {
+	d := decoder{buf: bytes.Repeat([]byte{0x91}, 20<<20)}
+	if _, err := d.value(); err != errTooDeep {
+		t.Fatalf("nested arrays: %v", err)
+	}
+	d = decoder{buf: bytes.Repeat([]byte{0x81, 0xa0}, 1<<20)}
+	if _, err := d.value(); err != errTooDeep {
+		t.Fatalf("nested maps: %v", err)
+	}
+
+	//不超过限制的嵌套正常解码
+	b := append(bytes.Repeat([]byte{0x91}, maxDepth), 0xc0)
+	d = decoder{buf: b}
+	if _, err := d.value(); err != nil {
+		t.Fatalf("depth %d: %v", maxDepth, err)
+	}
+}
@@ -0,0 +1,80 @@
+package openrpc
+
+import "reflect"
+
+/*
+OpenRPC 文档（https://spec.open-rpc.org）的结构定义，只包含这里用到的部分。
+jsonrpc 的参数按位置传递，只有一个参数，所以每个方法只有一个 param。
+*/
+
+const Version = "1.2.6"
+
+type Document struct {
+	OpenRPC    string      `json:"openrpc"`
+	Info       Info        `json:"info"`
+	Methods    []*Method   `json:"methods"`
+	Components *Components `json:"components,omitempty"`
+
+	names map[reflect.Type]string //已登记的组件名
+	taken map[string]bool
+}
+
+type Info struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+type Method struct {
+	Name           string               `json:"name"`
+	Summary        string               `json:"summary,omitempty"`
+	ParamStructure string               `json:"paramStructure,omitempty"`
+	Params         []*ContentDescriptor `json:"params"`
+	Result         *ContentDescriptor   `json:"result,omitempty"`
+	Deprecated     bool                 `json:"deprecated,omitempty"`
+}
+
+type ContentDescriptor struct {
+	Name     string  `json:"name"`
+	Required bool    `json:"required,omitempty"`
+	Schema   *Schema `json:"schema"`
+}
+
+type Components struct {
+	Schemas map[string]*Schema `json:"schemas,omitempty"`
+}
+
+//JSON Schema 的子集
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	ContentEncoding      string             `json:"contentEncoding,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+}
+
+//引用 components 中的 schema
+func Ref(name string) *Schema {
+	return &Schema{Ref: "#/components/schemas/" + name}
+}
+
+func NewDocument(title, version string) *Document {
+	return &Document{
+		OpenRPC:    Version,
+		Info:       Info{Title: title, Version: version},
+		Methods:    []*Method{},
+		Components: &Components{Schemas: map[string]*Schema{}},
+	}
+}
+
+//按位置传参的方法描述
+func NewMethod(name string, params, result *Schema) *Method {
+	return &Method{
+		Name:           name,
+		ParamStructure: "by-position",
+		Params:         []*ContentDescriptor{{Name: "args", Required: true, Schema: params}},
+		Result:         &ContentDescriptor{Name: "reply", Schema: result},
+	}
+}
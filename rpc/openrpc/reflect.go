@@ -0,0 +1,129 @@
+package openrpc
+
+import (
+	"encoding"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"time"
+)
+
+/*
+通过反射为 Go 类型生成 schema，字段名按 encoding/json 的规则取：
+json 标签优先，"-" 忽略，未加标签的嵌入结构体展开到外层。
+命名的结构体放进 components，用 $ref 引用，自引用的类型也能处理。
+*/
+
+var (
+	typeOfTime          = reflect.TypeOf(time.Time{})
+	typeOfJSONMarshaler = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	typeOfTextMarshaler = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+//t 的 schema，命名结构体登记到 d.Components
+func (d *Document) Schema(t reflect.Type) *Schema {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch {
+	case t == typeOfTime:
+		return &Schema{Type: "string", Format: "date-time"}
+	case t.Implements(typeOfJSONMarshaler) || reflect.PtrTo(t).Implements(typeOfJSONMarshaler):
+		//自定义编码，无法推断
+		return &Schema{}
+	case t.Implements(typeOfTextMarshaler) || reflect.PtrTo(t).Implements(typeOfTextMarshaler):
+		return &Schema{Type: "string"}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return &Schema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Slice:
+		//[]byte 编码为 base64 字符串
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: "string", ContentEncoding: "base64"}
+		}
+		return &Schema{Type: "array", Items: d.Schema(t.Elem())}
+	case reflect.Array:
+		return &Schema{Type: "array", Items: d.Schema(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: d.Schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return d.structSchema(t)
+		}
+		name := d.componentName(t)
+		if _, ok := d.Components.Schemas[name]; !ok {
+			//先占位，处理自引用的结构体
+			d.Components.Schemas[name] = nil
+			d.Components.Schemas[name] = d.structSchema(t)
+		}
+		return Ref(name)
+	}
+	return &Schema{}
+}
+
+//组件名用类型名，不同包的同名类型加上包名区分
+func (d *Document) componentName(t reflect.Type) string {
+	if d.names == nil {
+		d.names = make(map[reflect.Type]string)
+		d.taken = make(map[string]bool)
+	}
+	if name, ok := d.names[t]; ok {
+		return name
+	}
+	name := t.Name()
+	if d.taken[name] {
+		pkg := t.PkgPath()
+		name = strings.Replace(pkg[strings.LastIndex(pkg, "/")+1:]+"."+name, "/", ".", -1)
+	}
+	d.names[t] = name
+	d.taken[name] = true
+	return name
+}
+
+func (d *Document) structSchema(t reflect.Type) *Schema {
+	s := &Schema{Type: "object", Properties: make(map[string]*Schema)}
+	d.fields(s, t)
+	return s
+}
+
+func (d *Document) fields(s *Schema, t reflect.Type) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name := tag
+		if i := strings.Index(tag, ","); i >= 0 {
+			name = tag[:i]
+		}
+
+		if f.Anonymous {
+			ft := f.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if name == "" && ft.Kind() == reflect.Struct {
+				d.fields(s, ft)
+				continue
+			}
+		}
+		if f.PkgPath != "" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		s.Properties[name] = d.Schema(f.Type)
+	}
+}
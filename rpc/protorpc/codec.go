@@ -0,0 +1,277 @@
+package protorpc
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/shengzhch/learn/rpc"
+)
+
+/*
+protobuf 编解码器。
+消息体需要实现 Message（protoc-gen-gogo 等生成的代码都带有这两个方法），
+请求头和响应头按下面的 proto 定义手工编码：
+
+	message Header {
+		string service_method = 1;
+		uint64 seq = 2;
+		string error = 3;
+	}
+
+每个消息是一个帧：4 字节大端长度 + 负载，负载为 varint 头长度 + 头 + 消息体。
+*/
+
+type Message interface {
+	Marshal() ([]byte, error)
+	Unmarshal([]byte) error
+}
+
+var (
+	errNotMessage = errors.New("protorpc: body does not implement protorpc.Message")
+	errHeader     = errors.New("protorpc: invalid header")
+)
+
+//单个帧的最大长度，长度前缀超过它时不分配缓冲，直接返回错误
+var MaxFrameSize = 64 << 20
+
+type header struct {
+	ServiceMethod string
+	Seq           uint64
+	Error         string
+}
+
+func (h *header) marshal(b []byte) []byte {
+	if h.ServiceMethod != "" {
+		b = AppendBytesField(b, 1, []byte(h.ServiceMethod))
+	}
+	if h.Seq != 0 {
+		b = AppendVarintField(b, 2, h.Seq)
+	}
+	if h.Error != "" {
+		b = AppendBytesField(b, 3, []byte(h.Error))
+	}
+	return b
+}
+
+func (h *header) unmarshal(b []byte) error {
+	*h = header{}
+	for len(b) > 0 {
+		num, typ, n := ConsumeTag(b)
+		if n <= 0 {
+			return errHeader
+		}
+		b = b[n:]
+		switch {
+		case num == 1 && typ == WireBytes:
+			v, n := ConsumeBytes(b)
+			if n <= 0 {
+				return errHeader
+			}
+			h.ServiceMethod = string(v)
+			b = b[n:]
+		case num == 2 && typ == WireVarint:
+			v, n := binary.Uvarint(b)
+			if n <= 0 {
+				return errHeader
+			}
+			h.Seq = v
+			b = b[n:]
+		case num == 3 && typ == WireBytes:
+			v, n := ConsumeBytes(b)
+			if n <= 0 {
+				return errHeader
+			}
+			h.Error = string(v)
+			b = b[n:]
+		default:
+			//未知字段跳过
+			n := ConsumeField(typ, b)
+			if n < 0 {
+				return errHeader
+			}
+			b = b[n:]
+		}
+	}
+	return nil
+}
+
+//帧的读写，两端共用
+type conn struct {
+	rwc  io.ReadWriteCloser
+	br   *bufio.Reader
+	bw   *bufio.Writer
+	rbuf []byte
+	wbuf []byte
+	body []byte //当前消息的消息体
+}
+
+func newConn(rwc io.ReadWriteCloser) conn {
+	return conn{rwc: rwc, br: bufio.NewReader(rwc), bw: bufio.NewWriter(rwc)}
+}
+
+func (c *conn) readHeader(h *header) error {
+	var hdr [4]byte
+	if _, err := io.ReadFull(c.br, hdr[:]); err != nil {
+		return err
+	}
+	n := int(binary.BigEndian.Uint32(hdr[:]))
+	if n > MaxFrameSize {
+		return errors.New("protorpc: frame of " + strconv.Itoa(n) + " bytes exceeds " + strconv.Itoa(MaxFrameSize))
+	}
+	if cap(c.rbuf) < n {
+		c.rbuf = make([]byte, n)
+	}
+	c.rbuf = c.rbuf[:n]
+	if _, err := io.ReadFull(c.br, c.rbuf); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+
+	hlen, k := binary.Uvarint(c.rbuf)
+	if k <= 0 || hlen > uint64(n-k) {
+		return errHeader
+	}
+	c.body = c.rbuf[k+int(hlen):]
+	return h.unmarshal(c.rbuf[k : k+int(hlen)])
+}
+
+func (c *conn) readBody(body interface{}) error {
+	if body == nil {
+		return nil
+	}
+	m, ok := body.(Message)
+	if !ok {
+		return errNotMessage
+	}
+	return m.Unmarshal(c.body)
+}
+
+func (c *conn) write(h *header, body interface{}) error {
+	var p []byte
+	if body != nil {
+		m, ok := body.(Message)
+		if !ok {
+			return errNotMessage
+		}
+		var err error
+		if p, err = m.Marshal(); err != nil {
+			return err
+		}
+	}
+
+	//先留出长度位置
+	b := append(c.wbuf[:0], 0, 0, 0, 0)
+	hb := h.marshal(nil)
+	b = binary.AppendUvarint(b, uint64(len(hb)))
+	b = append(b, hb...)
+	b = append(b, p...)
+	binary.BigEndian.PutUint32(b, uint32(len(b)-4))
+	c.wbuf = b
+
+	if _, err := c.bw.Write(b); err != nil {
+		return err
+	}
+	return c.bw.Flush()
+}
+
+type serverCodec struct {
+	conn
+	closed bool
+}
+
+func NewServerCodec(rwc io.ReadWriteCloser) rpc.ServerCodec {
+	return &serverCodec{conn: newConn(rwc)}
+}
+
+func (c *serverCodec) ReadRequestHeader(r *rpc.Request) error {
+	var h header
+	if err := c.readHeader(&h); err != nil {
+		return err
+	}
+	r.ServiceMethod = h.ServiceMethod
+	r.Seq = h.Seq
+	return nil
+}
+
+func (c *serverCodec) ReadRequestBody(body interface{}) error {
+	return c.readBody(body)
+}
+
+func (c *serverCodec) WriteResponse(r *rpc.Response, body interface{}) error {
+	h := header{ServiceMethod: r.ServiceMethod, Seq: r.Seq, Error: r.Error}
+	if r.Error != "" {
+		body = nil
+	}
+	return c.write(&h, body)
+}
+
+func (c *serverCodec) SetReadDeadline(t time.Time) error {
+	return rpc.SetReadDeadline(c.rwc, t)
+}
+
+func (c *serverCodec) SetWriteDeadline(t time.Time) error {
+	return rpc.SetWriteDeadline(c.rwc, t)
+}
+
+func (c *serverCodec) Close() error {
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	return c.rwc.Close()
+}
+
+type clientCodec struct {
+	conn
+}
+
+func NewClientCodec(rwc io.ReadWriteCloser) rpc.ClientCodec {
+	return &clientCodec{conn: newConn(rwc)}
+}
+
+func (c *clientCodec) WriteRequest(r *rpc.Request, body interface{}) error {
+	return c.write(&header{ServiceMethod: r.ServiceMethod, Seq: r.Seq}, body)
+}
+
+func (c *clientCodec) ReadResponseHeader(r *rpc.Response) error {
+	var h header
+	if err := c.readHeader(&h); err != nil {
+		return err
+	}
+	r.ServiceMethod = h.ServiceMethod
+	r.Seq = h.Seq
+	r.Error = h.Error
+	return nil
+}
+
+func (c *clientCodec) ReadResponseBody(body interface{}) error {
+	return c.readBody(body)
+}
+
+func (c *clientCodec) Close() error {
+	return c.rwc.Close()
+}
+
+//使用 protobuf 处理连接
+func ServeConn(rwc io.ReadWriteCloser) {
+	rpc.DefaultServer.ServeCodecPeer(NewServerCodec(rwc), rpc.PeerOf(rwc))
+}
+
+func NewClient(rwc io.ReadWriteCloser) *rpc.Client {
+	return rpc.NewClientWithCodec(NewClientCodec(rwc))
+}
+
+func Dial(network, address string) (*rpc.Client, error) {
+	c, err := net.Dial(network, address)
+	if err != nil {
+		return nil, err
+	}
+	return NewClient(c), nil
+}
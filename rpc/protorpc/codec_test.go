@@ -0,0 +1,99 @@
+package protorpc
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/shengzhch/learn/rpc"
+)
+
+//message Text { string s = 1; }
+type Text struct {
+	S string
+}
+
+func (m *Text) Marshal() ([]byte, error) {
+	return AppendBytesField(nil, 1, []byte(m.S)), nil
+}
+
+func (m *Text) Unmarshal(b []byte) error {
+	*m = Text{}
+	for len(b) > 0 {
+		num, typ, n := ConsumeTag(b)
+		if n <= 0 {
+			return io.ErrUnexpectedEOF
+		}
+		b = b[n:]
+		if num == 1 && typ == WireBytes {
+			p, n := ConsumeBytes(b)
+			if n <= 0 {
+				return io.ErrUnexpectedEOF
+			}
+			m.S = string(p)
+			b = b[n:]
+			continue
+		}
+		if n = ConsumeField(typ, b); n < 0 {
+			return io.ErrUnexpectedEOF
+		}
+		b = b[n:]
+	}
+	return nil
+}
+
+type Echo int
+
+func (e *Echo) Say(args *Text, reply *Text) error {
+	reply.S = args.S
+	return nil
+}
+
+func TestRoundTrip(t *testing.T) {
+	server := rpc.NewServer()
+	server.Register(new(Echo))
+	cli, srv := net.Pipe()
+	go server.ServeCodec(NewServerCodec(srv))
+	client := NewClient(cli)
+	defer client.Close()
+
+	for _, s := range []string{"", "hi", strings.Repeat("x", 100000)} {
+		var reply Text
+		if err := client.Call("Echo.Say", &Text{s}, &reply); err != nil || reply.S != s {
+			t.Errorf("Echo.Say(%d bytes) = %d bytes, %v", len(s), len(reply.S), err)
+		}
+	}
+	if _, ok := client.Call("Echo.Nope", &Text{}, new(Text)).(rpc.ServerError); !ok {
+		t.Error("unknown method should return a ServerError")
+	}
+	if err := client.Call("Echo.Say", "not a message", new(Text)); err == nil {
+		t.Error("non-Message args accepted")
+	}
+}
+
+func TestHeader(t *testing.T) {
+	for _, h := range []header{{}, {"Echo.Say", 1 << 40, ""}, {"A.B", 7, "boom"}} {
+		var got header
+		if err := got.unmarshal(h.marshal(nil)); err != nil || got != h {
+			t.Errorf("header %+v round trip = %+v, %v", h, got, err)
+		}
+	}
+	if err := new(header).unmarshal([]byte{0x0a, 0x05, 'a'}); err == nil {
+		t.Error("truncated header accepted")
+	}
+}
+
+func TestFrameTooLarge(t *testing.T) {
+	codec := NewServerCodec(nopCloser{bytes.NewReader([]byte{0xff, 0xff, 0xff, 0xff})})
+	var req rpc.Request
+	if err := codec.ReadRequestHeader(&req); err == nil || !strings.Contains(err.Error(), "exceeds") {
+		t.Fatalf("4 GiB length prefix: %v", err)
+	}
+}
+
+type nopCloser struct{ io.Reader }
+
+func (nopCloser) Write(p []byte) (int, error) { return len(p), nil }
+func (nopCloser) Close() error                { return nil }
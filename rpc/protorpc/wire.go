@@ -0,0 +1,69 @@
+package protorpc
+
+import "encoding/binary"
+
+//protobuf 线格式的辅助函数，手写 Message 实现时可以直接使用
+
+const (
+	WireVarint  = 0
+	WireFixed64 = 1
+	WireBytes   = 2
+	WireFixed32 = 5
+)
+
+func AppendTag(b []byte, num int, typ int) []byte {
+	return binary.AppendUvarint(b, uint64(num)<<3|uint64(typ))
+}
+
+func AppendVarintField(b []byte, num int, v uint64) []byte {
+	return binary.AppendUvarint(AppendTag(b, num, WireVarint), v)
+}
+
+func AppendBytesField(b []byte, num int, v []byte) []byte {
+	b = binary.AppendUvarint(AppendTag(b, num, WireBytes), uint64(len(v)))
+	return append(b, v...)
+}
+
+//读出字段号和类型，n <= 0 表示出错
+func ConsumeTag(b []byte) (num int, typ int, n int) {
+	v, n := binary.Uvarint(b)
+	if n <= 0 || v>>3 == 0 {
+		return 0, 0, -1
+	}
+	return int(v >> 3), int(v & 7), n
+}
+
+//读出长度前缀的字节串
+func ConsumeBytes(b []byte) ([]byte, int) {
+	l, n := binary.Uvarint(b)
+	if n <= 0 || l > uint64(len(b)-n) {
+		return nil, -1
+	}
+	return b[n : n+int(l)], n + int(l)
+}
+
+//跳过一个字段的值，返回消耗的字节数，-1 表示出错
+func ConsumeField(typ int, b []byte) int {
+	switch typ {
+	case WireVarint:
+		_, n := binary.Uvarint(b)
+		if n <= 0 {
+			return -1
+		}
+		return n
+	case WireFixed64:
+		if len(b) < 8 {
+			return -1
+		}
+		return 8
+	case WireBytes:
+		_, n := ConsumeBytes(b)
+		return n
+	case WireFixed32:
+		if len(b) < 4 {
+			return -1
+		}
+		return 4
+	}
+	return -1
+}
@@ -0,0 +1,90 @@
+package registry
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/shengzhch/learn/rpc"
+)
+
+/*
+基于目录的 Registry：每个端点一个 JSON 文件，多个进程共享同一个目录即可互相发现。
+写文件时先写临时文件再重命名，读取方不会看到写了一半的内容。
+进程异常退出时文件会残留，客户端需要靠健康检查剔除。
+*/
+type Dir struct {
+	path string
+}
+
+func NewDir(path string) (*Dir, error) {
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return nil, err
+	}
+	return &Dir{path: path}, nil
+}
+
+func (d *Dir) file(ep rpc.Endpoint) string {
+	return filepath.Join(d.path, url.PathEscape(key(ep))+".json")
+}
+
+func (d *Dir) Register(ep rpc.Endpoint) error {
+	data, err := json.Marshal(ep)
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(d.path, ".tmp-")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), d.file(ep))
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+func (d *Dir) Deregister(ep rpc.Endpoint) error {
+	err := os.Remove(d.file(ep))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func (d *Dir) Lookup(service string) ([]rpc.Endpoint, error) {
+	files, err := ioutil.ReadDir(d.path)
+	if err != nil {
+		return nil, err
+	}
+	var list []rpc.Endpoint
+	for _, fi := range files {
+		name := fi.Name()
+		if fi.IsDir() || strings.HasPrefix(name, ".") || !strings.HasSuffix(name, ".json") {
+			continue
+		}
+		data, err := ioutil.ReadFile(filepath.Join(d.path, name))
+		if err != nil {
+			//读之前被注销
+			continue
+		}
+		var ep rpc.Endpoint
+		if json.Unmarshal(data, &ep) != nil {
+			continue
+		}
+		if provides(ep, service) {
+			list = append(list, ep)
+		}
+	}
+	sortEndpoints(list)
+	return list, nil
+}
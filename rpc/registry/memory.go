@@ -0,0 +1,49 @@
+package registry
+
+import (
+	"sync"
+
+	"github.com/shengzhch/learn/rpc"
+)
+
+//进程内的 Registry，用于测试或同一进程中的服务端和客户端
+type Memory struct {
+	mu        sync.Mutex
+	endpoints map[string]rpc.Endpoint
+}
+
+func NewMemory() *Memory {
+	return &Memory{endpoints: make(map[string]rpc.Endpoint)}
+}
+
+func key(ep rpc.Endpoint) string {
+	return ep.Network + " " + ep.Addr
+}
+
+func (m *Memory) Register(ep rpc.Endpoint) error {
+	ep.Services = append([]string(nil), ep.Services...)
+	m.mu.Lock()
+	m.endpoints[key(ep)] = ep
+	m.mu.Unlock()
+	return nil
+}
+
+func (m *Memory) Deregister(ep rpc.Endpoint) error {
+	m.mu.Lock()
+	delete(m.endpoints, key(ep))
+	m.mu.Unlock()
+	return nil
+}
+
+func (m *Memory) Lookup(service string) ([]rpc.Endpoint, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var list []rpc.Endpoint
+	for _, ep := range m.endpoints {
+		if provides(ep, service) {
+			list = append(list, ep)
+		}
+	}
+	sortEndpoints(list)
+	return list, nil
+}
@@ -0,0 +1,89 @@
+package registry
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/shengzhch/learn/rpc"
+)
+
+type Echo int
+
+func (e *Echo) Say(args *string, reply *string) error {
+	*reply = *args
+	return nil
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func testRegistry(t *testing.T, reg rpc.Registry) {
+	server := rpc.NewServer()
+	server.Register(new(Echo))
+	server.SetRegistry(reg)
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	go func() {
+		server.Accept(lis)
+		close(done)
+	}()
+
+	resolver := NewResolver(reg)
+	waitFor(t, func() bool {
+		_, err := resolver.Resolve("Echo")
+		return err == nil
+	})
+	eps, _ := reg.Lookup("Echo")
+	if len(eps) != 1 || eps[0].Addr != lis.Addr().String() || eps[0].Services[0] != "Echo" {
+		t.Fatalf("unexpected endpoints %+v", eps)
+	}
+	if eps, _ := reg.Lookup("RPC"); len(eps) != 0 {
+		t.Errorf("builtin service announced: %+v", eps)
+	}
+
+	client, err := resolver.Dial("Echo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var reply string
+	if err := client.Call("Echo.Say", "hi", &reply); err != nil || reply != "hi" {
+		t.Fatalf("Echo.Say = %q, %v", reply, err)
+	}
+	client.Close()
+
+	server.SetHealthy(false)
+	if _, err := resolver.Resolve("Echo"); err != ErrNoEndpoint {
+		t.Errorf("unhealthy endpoint resolved: %v", err)
+	}
+	server.SetHealthy(true)
+
+	lis.Close()
+	<-done
+	if eps, _ := reg.Lookup("Echo"); len(eps) != 0 {
+		t.Errorf("endpoint not removed on shutdown: %+v", eps)
+	}
+}
+
+func TestMemory(t *testing.T) {
+	testRegistry(t, NewMemory())
+}
+
+func TestDir(t *testing.T) {
+	reg, err := NewDir(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	testRegistry(t, reg)
+}
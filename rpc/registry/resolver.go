@@ -0,0 +1,70 @@
+package registry
+
+import (
+	"errors"
+	"sort"
+	"sync/atomic"
+
+	"github.com/shengzhch/learn/rpc"
+)
+
+var ErrNoEndpoint = errors.New("registry: no healthy endpoint")
+
+//客户端从 Registry 中选择端点，在健康的端点间轮询
+type Resolver struct {
+	registry rpc.Registry
+	next     uint32
+}
+
+func NewResolver(r rpc.Registry) *Resolver {
+	return &Resolver{registry: r}
+}
+
+//提供 service 的健康端点
+func (r *Resolver) Resolve(service string) ([]rpc.Endpoint, error) {
+	all, err := r.registry.Lookup(service)
+	if err != nil {
+		return nil, err
+	}
+	list := all[:0]
+	for _, ep := range all {
+		if ep.Healthy {
+			list = append(list, ep)
+		}
+	}
+	if len(list) == 0 {
+		return nil, ErrNoEndpoint
+	}
+	return list, nil
+}
+
+func (r *Resolver) Pick(service string) (rpc.Endpoint, error) {
+	list, err := r.Resolve(service)
+	if err != nil {
+		return rpc.Endpoint{}, err
+	}
+	n := atomic.AddUint32(&r.next, 1)
+	return list[int(n-1)%len(list)], nil
+}
+
+//连接到一个提供 service 的端点
+func (r *Resolver) Dial(service string) (*rpc.Client, error) {
+	ep, err := r.Pick(service)
+	if err != nil {
+		return nil, err
+	}
+	return rpc.Dial(ep.Network, ep.Addr)
+}
+
+func provides(ep rpc.Endpoint, service string) bool {
+	for _, s := range ep.Services {
+		if s == service {
+			return true
+		}
+	}
+	return false
+}
+
+func sortEndpoints(list []rpc.Endpoint) {
+	sort.Slice(list, func(i, j int) bool { return key(list[i]) < key(list[j]) })
+}
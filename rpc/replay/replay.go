@@ -0,0 +1,81 @@
+package replay
+
+import (
+	"bytes"
+	"encoding/json"
+	"net"
+	"reflect"
+
+	"github.com/shengzhch/learn/jsonrpc"
+	"github.com/shengzhch/learn/rpc"
+)
+
+/*
+回放录制的调用（见 rpc.Recorder）并比较结果。
+参数按录制时的 JSON 通过 jsonrpc 发送，服务端需要能处理 jsonrpc 编码；
+结果按 JSON 的值比较，字段顺序和空白不影响。
+*/
+
+//回放结果与录制不一致的调用
+type Diff struct {
+	Record *rpc.Record
+	Reply  json.RawMessage //回放得到的结果
+	Error  string          //回放得到的错误
+}
+
+func (d *Diff) String() string {
+	var buf bytes.Buffer
+	buf.WriteString(d.Record.ServiceMethod + " " + string(d.Record.Args) + "\n")
+	buf.WriteString("- " + result(d.Record.Reply, d.Record.Error) + "\n")
+	buf.WriteString("+ " + result(d.Reply, d.Error) + "\n")
+	return buf.String()
+}
+
+func result(reply json.RawMessage, err string) string {
+	if err != "" {
+		return "error: " + err
+	}
+	return string(reply)
+}
+
+//在进程内的 server 上回放
+func Replay(server *rpc.Server, records []*rpc.Record) ([]*Diff, error) {
+	cli, srv := net.Pipe()
+	go server.ServeCodec(jsonrpc.NewServerCodec(srv))
+	client := jsonrpc.NewClient(cli)
+	defer client.Close()
+	return ReplayClient(client, records)
+}
+
+//通过 jsonrpc 客户端回放，按录制的顺序逐个调用
+func ReplayClient(client *rpc.Client, records []*rpc.Record) ([]*Diff, error) {
+	var diffs []*Diff
+	for _, rec := range records {
+		var reply json.RawMessage
+		d := &Diff{Record: rec}
+		err := client.Call(rec.ServiceMethod, rec.Args, &reply)
+		switch err.(type) {
+		case nil:
+			d.Reply = reply
+		case rpc.ServerError:
+			d.Error = err.Error()
+		default:
+			return diffs, err
+		}
+		if !equal(rec, d) {
+			diffs = append(diffs, d)
+		}
+	}
+	return diffs, nil
+}
+
+func equal(rec *rpc.Record, d *Diff) bool {
+	if rec.Error != "" || d.Error != "" {
+		return rec.Error == d.Error
+	}
+	var want, got interface{}
+	if json.Unmarshal(rec.Reply, &want) != nil || json.Unmarshal(d.Reply, &got) != nil {
+		return bytes.Equal(rec.Reply, d.Reply)
+	}
+	return reflect.DeepEqual(want, got)
+}
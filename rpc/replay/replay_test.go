@@ -0,0 +1,86 @@
+package replay
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/shengzhch/learn/rpc"
+)
+
+type Args struct {
+	A int `json:"a"`
+	B int `json:"b"`
+}
+
+type Arith struct {
+	buggy bool
+}
+
+func (t *Arith) Add(args *Args, reply *int) error {
+	*reply = args.A + args.B
+	if t.buggy && args.A < 0 {
+		*reply = 0
+	}
+	return nil
+}
+
+func (t *Arith) Div(args *Args, reply *int) error {
+	if args.B == 0 {
+		return errors.New("divide by zero")
+	}
+	*reply = args.A / args.B
+	return nil
+}
+
+func TestReplay(t *testing.T) {
+	var buf bytes.Buffer
+	server := rpc.NewServer()
+	server.RegisterName(new(Arith), "Arith")
+	server.SetRecorder(rpc.NewRecorder(&buf))
+
+	//用 gob 录制
+	cli, srv := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		server.ServeConn(srv)
+		close(done)
+	}()
+	client := rpc.NewClient(cli)
+	var reply int
+	for _, args := range []Args{{1, 2}, {-1, 5}, {6, 3}, {1, 0}} {
+		client.Call("Arith.Add", &args, &reply)
+		client.Call("Arith.Div", &args, &reply)
+	}
+	client.Close()
+	<-done
+
+	records, err := rpc.ReadRecords(&buf)
+	if err != nil || len(records) != 8 {
+		t.Fatalf("read %d records: %v", len(records), err)
+	}
+
+	same := rpc.NewServer()
+	same.RegisterName(new(Arith), "Arith")
+	diffs, err := Replay(same, records)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diffs) != 0 {
+		t.Errorf("unexpected diffs against same server: %v", diffs)
+	}
+
+	buggy := rpc.NewServer()
+	buggy.RegisterName(&Arith{buggy: true}, "Arith")
+	diffs, err = Replay(buggy, records)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diffs) != 1 || string(diffs[0].Record.Args) != `{"a":-1,"b":5}` || string(diffs[0].Reply) != "0" {
+		t.Fatalf("unexpected diffs %v", diffs)
+	}
+	if want := "Arith.Add {\"a\":-1,\"b\":5}\n- 4\n+ 0\n"; diffs[0].String() != want {
+		t.Errorf("diff = %q, want %q", diffs[0].String(), want)
+	}
+}
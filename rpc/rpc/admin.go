@@ -0,0 +1,135 @@
+package rpc
+
+import (
+	"bytes"
+	"errors"
+	"runtime/pprof"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/*
+内置的 Admin 服务，用于运行时查看和操作服务端：
+列出连接、关闭连接、查看熔断器、开关 debugLog、导出 goroutine。
+Admin 的调用同样经过 SetAuthorizer 设置的检查；没有设置时一律拒绝。
+按解析出的服务判断，别名和忽略大小写的名字同样受检查，Authorizer 收到的总是 "Admin.<方法>"。
+调试页面按调用 Admin.Connections 检查。
+*/
+
+var ErrConnNotFound = errors.New("rpc: connection not found")
+
+//ServeCodec 处理中的连接
+type connTable struct {
+	mu  sync.Mutex
+	seq uint64
+	m   map[uint64]*Conn
+}
+
+func (t *connTable) add(c *Conn) {
+	t.mu.Lock()
+	if t.m == nil {
+		t.m = make(map[uint64]*Conn)
+	}
+	t.seq++
+	c.id = t.seq
+	t.m[c.id] = c
+	t.mu.Unlock()
+}
+
+func (t *connTable) remove(c *Conn) {
+	t.mu.Lock()
+	delete(t.m, c.id)
+	t.mu.Unlock()
+}
+
+func (t *connTable) get(id uint64) *Conn {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.m[id]
+}
+
+type ConnInfo struct {
+	ID         uint64
+	Network    string
+	RemoteAddr string
+	Cred       *Cred `json:",omitempty"`
+	InFlight   int64 //进行中的调用数
+	Created    time.Time
+	Age        time.Duration
+}
+
+//当前的连接，按 ID 排序
+func (server *Server) Connections() []ConnInfo {
+	now := time.Now()
+	server.conns.mu.Lock()
+	infos := make([]ConnInfo, 0, len(server.conns.m))
+	for _, c := range server.conns.m {
+		info := ConnInfo{
+			ID:       c.id,
+			InFlight: atomic.LoadInt64(&c.inFlight),
+			Created:  c.created,
+			Age:      now.Sub(c.created),
+		}
+		if c.peer != nil {
+			info.Network, info.RemoteAddr, info.Cred = c.peer.Network, c.peer.Addr, c.peer.Cred
+		}
+		infos = append(infos, info)
+	}
+	server.conns.mu.Unlock()
+	sort.Slice(infos, func(i, j int) bool { return infos[i].ID < infos[j].ID })
+	return infos
+}
+
+//关闭连接，进行中的调用完成后连接上的处理结束
+func (server *Server) CloseConn(id uint64) error {
+	c := server.conns.get(id)
+	if c == nil {
+		return ErrConnNotFound
+	}
+	return closeCodec(c.sending, c.codec)
+}
+
+type Admin struct {
+	server *Server
+}
+
+func (server *Server) RegisterAdmin() error {
+	return server.RegisterName(&Admin{server}, "Admin")
+}
+
+func (a *Admin) Connections(args int, reply *[]ConnInfo) error {
+	*reply = a.server.Connections()
+	return nil
+}
+
+func (a *Admin) CloseConnection(id uint64, reply *bool) error {
+	if err := a.server.CloseConn(id); err != nil {
+		return err
+	}
+	*reply = true
+	return nil
+}
+
+//RegisterBreaker 登记的熔断器的统计
+func (a *Admin) Breakers(args int, reply *[]BreakerStats) error {
+	*reply = a.server.BreakerStats()
+	return nil
+}
+
+//修改 debugLog，返回之前的值
+func (a *Admin) SetDebugLog(on bool, reply *bool) error {
+	*reply = SetDebugLog(on)
+	return nil
+}
+
+//所有 goroutine 的调用栈
+func (a *Admin) Goroutines(args int, reply *string) error {
+	var buf bytes.Buffer
+	if err := pprof.Lookup("goroutine").WriteTo(&buf, 2); err != nil {
+		return err
+	}
+	*reply = buf.String()
+	return nil
+}
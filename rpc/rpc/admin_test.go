@@ -0,0 +1,171 @@
+package rpc
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type Block chan struct{}
+
+func (b Block) Wait(args int, reply *int) error {
+	<-b
+	return nil
+}
+
+func TestAdmin(t *testing.T) {
+	server := NewServer()
+	server.Register(new(Echo))
+	block := make(Block)
+	server.RegisterName(block, "Block")
+	if err := server.RegisterAdmin(); err != nil {
+		t.Fatal(err)
+	}
+	cb := NewCircuitBreaker()
+	done, _ := cb.Allow("backend:1", "Echo.Say")
+	done(nil)
+	server.RegisterBreaker(cb)
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+	go server.Accept(lis)
+
+	admin, err := Dial("tcp", lis.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer admin.Close()
+	var conns []ConnInfo
+	//没有 Authorizer 时拒绝
+	if err := admin.Call("Admin.Connections", 0, &conns); err == nil || err.Error() != ErrPermissionDenied.Error() {
+		t.Fatalf("Admin without authorizer: %v", err)
+	}
+	server.SetAuthorizer(func(ctx context.Context, serviceMethod string) error {
+		if strings.HasPrefix(serviceMethod, "Admin.") && MetadataFromContext(ctx)["token"] != "admin" {
+			return ErrPermissionDenied
+		}
+		return nil
+	})
+	if err := admin.Call("Admin.Connections", 0, &conns); err == nil {
+		t.Fatal("Admin without token should be denied")
+	}
+	ctx := WithMetadata(context.Background(), Metadata{"token": "admin"})
+
+	client, err := Dial("tcp", lis.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	call := client.Go("Block.Wait", 0, new(int), nil)
+
+	var target ConnInfo
+	for deadline := time.Now().Add(2 * time.Second); target.InFlight == 0; {
+		if time.Now().After(deadline) {
+			t.Fatalf("blocked call not listed: %+v", conns)
+		}
+		if err := admin.CallContext(ctx, "Admin.Connections", 0, &conns); err != nil {
+			t.Fatal(err)
+		}
+		for _, c := range conns {
+			if c.RemoteAddr == client.codec.(*gobClientCodec).rwc.(net.Conn).LocalAddr().String() {
+				target = c
+			}
+		}
+	}
+	if len(conns) != 2 || target.Network != "tcp" || target.InFlight != 1 || target.Age <= 0 {
+		t.Errorf("connections = %+v", conns)
+	}
+
+	w := httptest.NewRecorder()
+	(&debugHTTP{server}).ServeHTTP(w, httptest.NewRequest("GET", DefaultDebugPath, nil))
+	if w.Code != http.StatusForbidden {
+		t.Errorf("debug page without token: status %d", w.Code)
+	}
+	w = httptest.NewRecorder()
+	req := httptest.NewRequest("GET", DefaultDebugPath, nil)
+	req.Header.Set("Rpc-Meta-Token", "admin")
+	(&debugHTTP{server}).ServeHTTP(w, req)
+	if body := w.Body.String(); !strings.Contains(body, "Service Echo") || !strings.Contains(body, target.RemoteAddr) || !strings.Contains(body, "backend:1") {
+		t.Errorf("debug page:\n%s", body)
+	}
+
+	var ok bool
+	if err := admin.CallContext(ctx, "Admin.CloseConnection", target.ID, &ok); err != nil || !ok {
+		t.Fatalf("CloseConnection = %v, %v", ok, err)
+	}
+	close(block)
+	select {
+	case <-call.Done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("call on closed connection did not finish")
+	}
+	if err := client.Call("Echo.Say", "hi", new(string)); err == nil {
+		t.Error("connection should be closed")
+	}
+	if err := admin.CallContext(ctx, "Admin.CloseConnection", target.ID, &ok); err == nil {
+		t.Error("closing an unknown connection should fail")
+	}
+
+	var breakers []BreakerStats
+	if err := admin.CallContext(ctx, "Admin.Breakers", 0, &breakers); err != nil ||
+		len(breakers) != 1 || breakers[0].Endpoint != "backend:1" || breakers[0].Requests != 1 {
+		t.Fatalf("Breakers = %+v, %v", breakers, err)
+	}
+
+	var prev bool
+	if err := admin.CallContext(ctx, "Admin.SetDebugLog", true, &prev); err != nil || prev {
+		t.Fatalf("SetDebugLog = %v, %v", prev, err)
+	}
+	if err := admin.CallContext(ctx, "Admin.SetDebugLog", false, &prev); err != nil || !prev {
+		t.Fatalf("SetDebugLog = %v, %v", prev, err)
+	}
+
+	var stacks string
+	if err := admin.CallContext(ctx, "Admin.Goroutines", 0, &stacks); err != nil || !strings.Contains(stacks, "goroutine ") {
+		t.Fatalf("Goroutines = %d bytes, %v", len(stacks), err)
+	}
+}
+
+//别名和忽略大小写的名字同样按 Admin 检查，Authorizer 收到注册时的名字
+func TestAdminResolvedName(t *testing.T) {
+	server := NewServer()
+	if err := server.RegisterAdmin(); err != nil {
+		t.Fatal(err)
+	}
+	server.SetCaseInsensitive(true)
+	if err := server.RegisterAlias("Ops.Conns", "Admin.Connections"); err != nil {
+		t.Fatal(err)
+	}
+	cli, srv := net.Pipe()
+	go server.ServeConn(srv)
+	client := NewClient(cli)
+	defer client.Close()
+
+	var conns []ConnInfo
+	for _, name := range []string{"admin.connections", "Ops.Conns"} {
+		if err := client.Call(name, 0, &conns); err == nil || err.Error() != ErrPermissionDenied.Error() {
+			t.Errorf("%s without authorizer: %v", name, err)
+		}
+	}
+
+	var got []string
+	server.SetAuthorizer(func(ctx context.Context, serviceMethod string) error {
+		got = append(got, serviceMethod)
+		return nil
+	})
+	for _, name := range []string{"admin.connections", "Ops.Conns"} {
+		if err := client.Call(name, 0, &conns); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+	}
+	if len(got) != 2 || got[0] != "Admin.Connections" || got[1] != "Admin.Connections" {
+		t.Errorf("authorizer saw %q", got)
+	}
+}
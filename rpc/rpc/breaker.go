@@ -0,0 +1,360 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+/*
+客户端熔断：按 (endpoint, 方法) 统计失败率。
+	closed    正常放行，窗口内请求数达到 minRequests 且失败率达到阈值时打开
+	open      直接返回 *CircuitOpenError，cooldown 之后进入半开
+	half-open 只放行少量探测请求，全部成功则关闭，任何一个失败则重新打开
+下游故障时调用方立即失败，不会堆积等待中的 goroutine。
+调用方取消（context.Canceled）的调用既不算成功也不算失败，半开时让出探测名额。
+统计可以通过 Server.RegisterBreaker 在 Admin.Breakers 和调试页面中查看。
+*/
+
+type BreakerState int
+
+const (
+	BreakerClosed BreakerState = iota
+	BreakerOpen
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+	return "BreakerState(" + strconv.Itoa(int(s)) + ")"
+}
+
+//可以用 errors.Is(err, ErrCircuitOpen) 判断调用是否被熔断拒绝
+var ErrCircuitOpen = errors.New("rpc: circuit breaker is open")
+
+type CircuitOpenError struct {
+	Endpoint      string
+	ServiceMethod string
+	RetryAfter    time.Duration //距离进入半开的时间，半开状态下为 0
+}
+
+func (e *CircuitOpenError) Error() string {
+	return "rpc: circuit breaker is open for " + e.ServiceMethod + " at " + e.Endpoint
+}
+
+func (e *CircuitOpenError) Is(target error) bool { return target == ErrCircuitOpen }
+
+type circuitKey struct {
+	endpoint, method string
+}
+
+type circuit struct {
+	state BreakerState
+	gen   uint64 //每次状态变化加一，旧状态下开始的调用不再计入
+
+	windowStart time.Time
+	requests    int
+	failures    int
+
+	openedAt  time.Time
+	probes    int //半开状态下放行的请求数
+	successes int //半开状态下成功的请求数
+
+	//累计值，用于监控
+	total, totalFailures, canceled, rejected, opened uint64
+}
+
+type CircuitBreaker struct {
+	failureRate float64
+	minRequests int
+	window      time.Duration
+	cooldown    time.Duration
+	halfOpenMax int
+	isFailure   func(error) bool
+
+	mu       sync.Mutex
+	circuits map[circuitKey]*circuit
+}
+
+//默认：10s 窗口内至少 20 次请求且失败率达到 50% 时打开，5s 后半开，半开时放行 1 个请求
+func NewCircuitBreaker() *CircuitBreaker {
+	return &CircuitBreaker{
+		failureRate: 0.5,
+		minRequests: 20,
+		window:      10 * time.Second,
+		cooldown:    5 * time.Second,
+		halfOpenMax: 1,
+		isFailure:   defaultIsFailure,
+		circuits:    make(map[circuitKey]*circuit),
+	}
+}
+
+//失败率达到 rate 且窗口内请求数不少于 minRequests 时打开
+func (b *CircuitBreaker) SetThreshold(rate float64, minRequests int) {
+	b.failureRate = rate
+	b.minRequests = minRequests
+}
+
+//统计失败率的时间窗口
+func (b *CircuitBreaker) SetWindow(d time.Duration) {
+	b.window = d
+}
+
+//打开之后多久进入半开
+func (b *CircuitBreaker) SetCooldown(d time.Duration) {
+	b.cooldown = d
+}
+
+//半开状态下放行的请求数，这些请求都成功才关闭
+func (b *CircuitBreaker) SetHalfOpenRequests(n int) {
+	b.halfOpenMax = n
+}
+
+//判断错误是否计为失败，默认服务端返回的错误不计；调用方取消的调用不经过 f
+func (b *CircuitBreaker) SetFailureFunc(f func(error) bool) {
+	b.isFailure = f
+}
+
+func defaultIsFailure(err error) bool {
+	_, ok := err.(ServerError)
+	return !ok
+}
+
+/*
+请求开始前调用。允许时返回 done，调用结束后必须用调用的错误调用一次 done；
+拒绝时返回 *CircuitOpenError。
+*/
+func (b *CircuitBreaker) Allow(endpoint, serviceMethod string) (done func(error), err error) {
+	key := circuitKey{endpoint, serviceMethod}
+	now := time.Now()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	c := b.circuits[key]
+	if c == nil {
+		c = &circuit{windowStart: now}
+		b.circuits[key] = c
+	}
+
+	switch c.state {
+	case BreakerOpen:
+		if elapsed := now.Sub(c.openedAt); elapsed < b.cooldown {
+			c.rejected++
+			return nil, &CircuitOpenError{Endpoint: endpoint, ServiceMethod: serviceMethod, RetryAfter: b.cooldown - elapsed}
+		}
+		c.setState(BreakerHalfOpen, now)
+		fallthrough
+	case BreakerHalfOpen:
+		if c.probes >= b.halfOpenMax {
+			c.rejected++
+			return nil, &CircuitOpenError{Endpoint: endpoint, ServiceMethod: serviceMethod}
+		}
+		c.probes++
+	case BreakerClosed:
+		if now.Sub(c.windowStart) >= b.window {
+			c.windowStart, c.requests, c.failures = now, 0, 0
+		}
+	}
+
+	gen := c.gen
+	var once sync.Once
+	return func(err error) {
+		once.Do(func() { b.done(c, gen, err) })
+	}, nil
+}
+
+func (b *CircuitBreaker) done(c *circuit, gen uint64, err error) {
+	if errors.Is(err, context.Canceled) {
+		b.mu.Lock()
+		c.canceled++
+		if c.gen == gen && c.state == BreakerHalfOpen {
+			c.probes--
+		}
+		b.mu.Unlock()
+		return
+	}
+	failed := err != nil && b.isFailure(err)
+	now := time.Now()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	c.total++
+	if failed {
+		c.totalFailures++
+	}
+	if c.gen != gen {
+		return
+	}
+
+	switch c.state {
+	case BreakerClosed:
+		c.requests++
+		if failed {
+			c.failures++
+		}
+		if c.requests >= b.minRequests && float64(c.failures) >= b.failureRate*float64(c.requests) {
+			c.setState(BreakerOpen, now)
+		}
+	case BreakerHalfOpen:
+		if failed {
+			c.setState(BreakerOpen, now)
+			break
+		}
+		c.successes++
+		if c.successes >= b.halfOpenMax {
+			c.setState(BreakerClosed, now)
+		}
+	}
+}
+
+func (c *circuit) setState(s BreakerState, now time.Time) {
+	c.state = s
+	c.gen++
+	c.probes, c.successes = 0, 0
+	switch s {
+	case BreakerOpen:
+		c.openedAt = now
+		c.opened++
+	case BreakerClosed:
+		c.windowStart, c.requests, c.failures = now, 0, 0
+	}
+}
+
+//Allow 现在是否会拒绝，不改变状态，也不占用半开的探测名额
+func (b *CircuitBreaker) Check(endpoint, serviceMethod string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	c := b.circuits[circuitKey{endpoint, serviceMethod}]
+	if c == nil {
+		return nil
+	}
+	switch c.state {
+	case BreakerOpen:
+		if elapsed := time.Since(c.openedAt); elapsed < b.cooldown {
+			return &CircuitOpenError{Endpoint: endpoint, ServiceMethod: serviceMethod, RetryAfter: b.cooldown - elapsed}
+		}
+	case BreakerHalfOpen:
+		if c.probes >= b.halfOpenMax {
+			return &CircuitOpenError{Endpoint: endpoint, ServiceMethod: serviceMethod}
+		}
+	}
+	return nil
+}
+
+//当前状态，打开且已过 cooldown 时报告为半开
+func (b *CircuitBreaker) State(endpoint, serviceMethod string) BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	c := b.circuits[circuitKey{endpoint, serviceMethod}]
+	if c == nil {
+		return BreakerClosed
+	}
+	if c.state == BreakerOpen && time.Since(c.openedAt) >= b.cooldown {
+		return BreakerHalfOpen
+	}
+	return c.state
+}
+
+type BreakerStats struct {
+	Endpoint      string
+	ServiceMethod string
+	State         BreakerState
+	Requests      uint64 //计入统计的请求数，不含取消的
+	Failures      uint64
+	Canceled      uint64 //调用方取消的请求数
+	Rejected      uint64 //被熔断拒绝的请求数
+	Opened        uint64 //打开的次数
+}
+
+//所有 (endpoint, 方法) 的统计，按 endpoint 和方法排序
+func (b *CircuitBreaker) Stats() []BreakerStats {
+	b.mu.Lock()
+	stats := make([]BreakerStats, 0, len(b.circuits))
+	for key, c := range b.circuits {
+		stats = append(stats, BreakerStats{
+			Endpoint:      key.endpoint,
+			ServiceMethod: key.method,
+			State:         c.state,
+			Requests:      c.total,
+			Failures:      c.totalFailures,
+			Canceled:      c.canceled,
+			Rejected:      c.rejected,
+			Opened:        c.opened,
+		})
+	}
+	b.mu.Unlock()
+	for i := range stats {
+		stats[i].State = b.State(stats[i].Endpoint, stats[i].ServiceMethod)
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Endpoint != stats[j].Endpoint {
+			return stats[i].Endpoint < stats[j].Endpoint
+		}
+		return stats[i].ServiceMethod < stats[j].ServiceMethod
+	})
+	return stats
+}
+
+//在 Admin.Breakers 和调试页面中展示的熔断器
+type breakerList struct {
+	mu   sync.Mutex
+	list []*CircuitBreaker
+}
+
+//登记熔断器，它的统计出现在 Admin.Breakers 和调试页面中
+func (server *Server) RegisterBreaker(b *CircuitBreaker) {
+	server.breakers.mu.Lock()
+	server.breakers.list = append(server.breakers.list, b)
+	server.breakers.mu.Unlock()
+}
+
+//登记的所有熔断器的统计
+func (server *Server) BreakerStats() []BreakerStats {
+	server.breakers.mu.Lock()
+	list := append([]*CircuitBreaker(nil), server.breakers.list...)
+	server.breakers.mu.Unlock()
+	var stats []BreakerStats
+	for _, b := range list {
+		stats = append(stats, b.Stats()...)
+	}
+	return stats
+}
+
+//经过熔断器的客户端，endpoint 是统计用的名字，通常是服务端地址
+type BreakerClient struct {
+	client   *Client
+	endpoint string
+	breaker  *CircuitBreaker
+}
+
+func (b *CircuitBreaker) Client(endpoint string, client *Client) *BreakerClient {
+	return &BreakerClient{client: client, endpoint: endpoint, breaker: b}
+}
+
+func (c *BreakerClient) Call(serviceMethod string, args interface{}, reply interface{}) error {
+	return c.CallContext(context.Background(), serviceMethod, args, reply)
+}
+
+func (c *BreakerClient) CallContext(ctx context.Context, serviceMethod string, args interface{}, reply interface{}) error {
+	done, err := c.breaker.Allow(c.endpoint, serviceMethod)
+	if err != nil {
+		return err
+	}
+	err = c.client.CallContext(ctx, serviceMethod, args, reply)
+	done(err)
+	return err
+}
+
+func (c *BreakerClient) Close() error {
+	return c.client.Close()
+}
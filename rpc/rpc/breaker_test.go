@@ -0,0 +1,168 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	b := NewCircuitBreaker()
+	b.SetThreshold(0.5, 4)
+	b.SetCooldown(50 * time.Millisecond)
+	b.SetHalfOpenRequests(2)
+
+	fail := errors.New("connection refused")
+	callMethod := func(method string, err error) error {
+		done, berr := b.Allow("a", method)
+		if berr != nil {
+			return berr
+		}
+		done(err)
+		return nil
+	}
+	call := func(err error) error {
+		return callMethod("Echo.Say", err)
+	}
+
+	//服务端返回的错误不计为失败
+	for i := 0; i < 4; i++ {
+		callMethod("Echo.Bad", ServerError("bad args"))
+	}
+	if s := b.State("a", "Echo.Bad"); s != BreakerClosed {
+		t.Fatalf("state after server errors = %v", s)
+	}
+
+	call(nil)
+	call(fail)
+	call(fail)
+	call(fail)
+	if s := b.State("a", "Echo.Say"); s != BreakerOpen {
+		t.Fatalf("state = %v, want open", s)
+	}
+	err := call(nil)
+	var open *CircuitOpenError
+	if !errors.Is(err, ErrCircuitOpen) || !errors.As(err, &open) || open.RetryAfter <= 0 {
+		t.Fatalf("call while open = %v", err)
+	}
+	//其他方法和其他 endpoint 不受影响
+	if b.State("a", "Echo.Other") != BreakerClosed || b.State("b", "Echo.Say") != BreakerClosed {
+		t.Error("breaker should be per endpoint and method")
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if s := b.State("a", "Echo.Say"); s != BreakerHalfOpen {
+		t.Fatalf("state after cooldown = %v, want half-open", s)
+	}
+	//半开时只放行两个探测请求
+	done1, err1 := b.Allow("a", "Echo.Say")
+	done2, err2 := b.Allow("a", "Echo.Say")
+	if _, err3 := b.Allow("a", "Echo.Say"); err1 != nil || err2 != nil || !errors.Is(err3, ErrCircuitOpen) {
+		t.Fatalf("half-open probes: %v %v %v", err1, err2, err3)
+	}
+	done1(nil)
+	done2(fail)
+	if s := b.State("a", "Echo.Say"); s != BreakerOpen {
+		t.Fatalf("failed probe: state = %v, want open", s)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	call(nil)
+	call(nil)
+	if s := b.State("a", "Echo.Say"); s != BreakerClosed {
+		t.Fatalf("successful probes: state = %v, want closed", s)
+	}
+
+	stats := b.Stats()
+	if len(stats) != 2 || stats[0].ServiceMethod != "Echo.Bad" || stats[0].Failures != 0 {
+		t.Fatalf("stats = %+v", stats)
+	}
+	st := stats[1]
+	if st.Endpoint != "a" || st.ServiceMethod != "Echo.Say" || st.State != BreakerClosed ||
+		st.Requests != 8 || st.Failures != 4 || st.Rejected != 2 || st.Opened != 2 {
+		t.Errorf("stats = %+v", st)
+	}
+}
+
+func TestBreakerClient(t *testing.T) {
+	server := NewServer()
+	server.Register(new(Echo))
+	cli, srv := net.Pipe()
+	go server.ServeConn(srv)
+	client := NewClient(cli)
+
+	b := NewCircuitBreaker()
+	b.SetThreshold(0.5, 3)
+	bc := b.Client("pipe", client)
+
+	var reply string
+	if err := bc.Call("Echo.Say", "hi", &reply); err != nil || reply != "hi" {
+		t.Fatalf("Call = %q, %v", reply, err)
+	}
+	client.Close()
+	for i := 0; i < 2; i++ {
+		if err := bc.Call("Echo.Say", "hi", &reply); err != ErrShutdown {
+			t.Fatalf("call %d after Close = %v", i, err)
+		}
+	}
+	if err := bc.Call("Echo.Say", "hi", &reply); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("call with open breaker = %v", err)
+	}
+}
+
+//取消的调用不计入统计，半开时让出探测名额
+func TestBreakerCanceled(t *testing.T) {
+	b := NewCircuitBreaker()
+	b.SetThreshold(0.5, 2)
+	b.SetCooldown(20 * time.Millisecond)
+
+	for i := 0; i < 4; i++ {
+		done, err := b.Allow("a", "Echo.Say")
+		if err != nil {
+			t.Fatal(err)
+		}
+		done(fmt.Errorf("call: %w", context.Canceled))
+	}
+	if s := b.State("a", "Echo.Say"); s != BreakerClosed {
+		t.Fatalf("state after canceled calls = %v", s)
+	}
+
+	for i := 0; i < 2; i++ {
+		done, _ := b.Allow("a", "Echo.Say")
+		done(ErrShutdown)
+	}
+	if err := b.Check("a", "Echo.Say"); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("Check while open = %v", err)
+	}
+	time.Sleep(30 * time.Millisecond)
+	if err := b.Check("a", "Echo.Say"); err != nil {
+		t.Fatalf("Check after cooldown = %v", err)
+	}
+	done, err := b.Allow("a", "Echo.Say")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Check("a", "Echo.Say"); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("Check with probe in flight = %v", err)
+	}
+	done(context.Canceled)
+	if s := b.State("a", "Echo.Say"); s != BreakerHalfOpen {
+		t.Fatalf("state after canceled probe = %v", s)
+	}
+	done, err = b.Allow("a", "Echo.Say")
+	if err != nil {
+		t.Fatalf("probe slot not released: %v", err)
+	}
+	done(nil)
+	if s := b.State("a", "Echo.Say"); s != BreakerClosed {
+		t.Fatalf("state after probe = %v", s)
+	}
+
+	st := b.Stats()[0]
+	if st.Requests != 3 || st.Failures != 2 || st.Canceled != 5 {
+		t.Errorf("stats = %+v", st)
+	}
+}
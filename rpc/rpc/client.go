@@ -0,0 +1,357 @@
+package rpc
+
+import (
+	"bufio"
+	"context"
+	"encoding/gob"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"reflect"
+	"sync"
+)
+
+//服务端返回的错误
+type ServerError string
+
+func (e ServerError) Error() string {
+	return string(e)
+}
+
+var ErrShutdown = errors.New("connection is shut down")
+
+//请求的 Seq 用完，进入了推送消息的保留空间
+var ErrSeqExhausted = errors.New("rpc: request sequence numbers exhausted")
+
+//一次进行中的调用
+type Call struct {
+	ServiceMethod string
+	Args          interface{}
+	Reply         interface{}
+	Error         error
+	Done          chan *Call //调用完成时把自己发送到 Done
+	Meta          Metadata   //随请求发送的元数据
+	ReplyMeta     Metadata   //响应的元数据
+	seq           uint64
+}
+
+/*
+客户端：一个 Client 可以被多个 goroutine 同时使用，
+同一个连接上可以有多个未完成的调用。
+*/
+type Client struct {
+	codec ClientCodec
+
+	reqMutex sync.Mutex //保护 request，写请求时持有
+	request  Request
+
+	mutex    sync.Mutex
+	seq      uint64
+	pending  map[uint64]*Call
+	notify   map[string]reflect.Value //服务端推送的处理函数
+	closing  bool                     //用户调用了 Close
+	shutdown bool                     //服务端通知停止
+}
+
+/*
+客户端编解码器：
+客户端调用 WriteRequest 写请求，成对调用 ReadResponseHeader 和 ReadResponseBody 读响应。
+ReadResponseBody 可以用 nil 调用来丢弃响应体。
+*/
+type ClientCodec interface {
+	WriteRequest(*Request, interface{}) error
+	ReadResponseHeader(*Response) error
+	ReadResponseBody(interface{}) error
+
+	Close() error
+}
+
+func (client *Client) send(call *Call) {
+	client.reqMutex.Lock()
+	defer client.reqMutex.Unlock()
+
+	client.mutex.Lock()
+	if client.shutdown || client.closing {
+		client.mutex.Unlock()
+		call.Error = ErrShutdown
+		call.done()
+		return
+	}
+	seq := client.seq
+	if IsNotifySeq(seq) {
+		//保留给服务端推送的 Seq 不能用于请求，否则响应会被当作推送
+		client.mutex.Unlock()
+		call.Error = ErrSeqExhausted
+		call.done()
+		return
+	}
+	client.seq++
+	call.seq = seq
+	client.pending[seq] = call
+	client.mutex.Unlock()
+
+	client.request.Seq = seq
+	client.request.ServiceMethod = call.ServiceMethod
+	client.request.Meta = call.Meta
+	err := client.codec.WriteRequest(&client.request, call.Args)
+	if err != nil {
+		client.mutex.Lock()
+		call = client.pending[seq]
+		delete(client.pending, seq)
+		client.mutex.Unlock()
+		if call != nil {
+			call.Error = err
+			call.done()
+		}
+	}
+}
+
+//读响应的循环，每个 Client 一个 goroutine
+func (client *Client) input() {
+	var err error
+	var response Response
+	for err == nil {
+		response = Response{}
+		err = client.codec.ReadResponseHeader(&response)
+		if err != nil {
+			break
+		}
+
+		//服务端推送的消息使用保留的 Seq 空间
+		if IsNotifySeq(response.Seq) {
+			err = client.dispatchNotify(response.ServiceMethod)
+			continue
+		}
+
+		seq := response.Seq
+		client.mutex.Lock()
+		call := client.pending[seq]
+		delete(client.pending, seq)
+		client.mutex.Unlock()
+
+		if call != nil {
+			call.ReplyMeta = response.Meta
+		}
+
+		switch {
+		case call == nil:
+			//请求写失败后已被移除，丢弃响应体
+			err = client.codec.ReadResponseBody(nil)
+			if err != nil {
+				err = errors.New("reading error body: " + err.Error())
+			}
+		case response.callErr != nil:
+			call.Error = response.callErr
+			err = client.codec.ReadResponseBody(nil)
+			call.done()
+		case response.Error != "":
+			call.Error = ServerError(response.Error)
+			err = client.codec.ReadResponseBody(nil)
+			if err != nil {
+				err = errors.New("reading error body: " + err.Error())
+			}
+			call.done()
+		default:
+			err = client.codec.ReadResponseBody(call.Reply)
+			if err != nil {
+				call.Error = errors.New("reading body " + err.Error())
+			}
+			call.done()
+		}
+	}
+
+	//出错后结束所有未完成的调用
+	client.reqMutex.Lock()
+	client.mutex.Lock()
+	client.shutdown = true
+	closing := client.closing
+	if err == io.EOF {
+		if closing {
+			err = ErrShutdown
+		} else {
+			err = io.ErrUnexpectedEOF
+		}
+	}
+	for _, call := range client.pending {
+		call.Error = err
+		call.done()
+	}
+	client.mutex.Unlock()
+	client.reqMutex.Unlock()
+	if debugLog.Load() && err != io.EOF && !closing {
+		log.Println("rpc: client protocol error:", err)
+	}
+}
+
+func (call *Call) done() {
+	select {
+	case call.Done <- call:
+		// ok
+	default:
+		//Done 的容量不足，调用方需要保证缓冲
+		if debugLog.Load() {
+			log.Println("rpc: discarding Call reply due to insufficient Done chan capacity")
+		}
+	}
+}
+
+//在连接上创建客户端，使用 gob 编码
+func NewClient(conn io.ReadWriteCloser) *Client {
+	encBuf := newWriteBuffer(conn)
+	client := &gobClientCodec{conn, gob.NewDecoder(conn), gob.NewEncoder(encBuf), encBuf}
+	return NewClientWithCodec(client)
+}
+
+//使用指定的编解码器创建客户端
+func NewClientWithCodec(codec ClientCodec) *Client {
+	client := &Client{
+		codec:   codec,
+		pending: make(map[uint64]*Call),
+		notify:  make(map[string]reflect.Value),
+	}
+	go client.input()
+	return client
+}
+
+//实现 ClientCodec 接口
+type gobClientCodec struct {
+	rwc    io.ReadWriteCloser
+	dec    *gob.Decoder
+	enc    *gob.Encoder
+	encBuf flushWriter
+}
+
+func (c *gobClientCodec) WriteRequest(r *Request, body interface{}) (err error) {
+	if err = c.enc.Encode(r); err != nil {
+		return
+	}
+	if err = c.enc.Encode(body); err != nil {
+		return
+	}
+	return c.encBuf.Flush()
+}
+
+func (c *gobClientCodec) ReadResponseHeader(r *Response) error {
+	return c.dec.Decode(r)
+}
+
+func (c *gobClientCodec) ReadResponseBody(body interface{}) error {
+	return c.dec.Decode(body)
+}
+
+func (c *gobClientCodec) Close() error {
+	return c.rwc.Close()
+}
+
+//通过 HTTP CONNECT 连接到默认路径上的 rpc 服务
+func DialHTTP(network, address string) (*Client, error) {
+	return DialHTTPPath(network, address, DefaultRPCPath)
+}
+
+func DialHTTPPath(network, address, path string) (*Client, error) {
+	conn, err := net.Dial(network, address)
+	if err != nil {
+		return nil, err
+	}
+	io.WriteString(conn, "CONNECT "+path+" HTTP/1.0\n\n")
+
+	//成功切换到 rpc 协议前需要收到 HTTP 响应
+	resp, err := http.ReadResponse(bufio.NewReader(conn), &http.Request{Method: "CONNECT"})
+	if err == nil && resp.Status == connected {
+		return NewClient(conn), nil
+	}
+	if err == nil {
+		err = errors.New("unexpected HTTP response: " + resp.Status)
+	}
+	conn.Close()
+	return nil, &net.OpError{
+		Op:   "dial-http",
+		Net:  network + " " + address,
+		Addr: nil,
+		Err:  err,
+	}
+}
+
+//连接到指定地址的 rpc 服务
+func Dial(network, address string) (*Client, error) {
+	conn, err := net.Dial(network, address)
+	if err != nil {
+		return nil, err
+	}
+	return NewClient(conn), nil
+}
+
+//关闭客户端，未完成的调用返回 ErrShutdown
+func (client *Client) Close() error {
+	client.mutex.Lock()
+	if client.closing {
+		client.mutex.Unlock()
+		return ErrShutdown
+	}
+	client.closing = true
+	client.mutex.Unlock()
+	return client.codec.Close()
+}
+
+//异步调用，done 为 nil 时自动分配
+func (client *Client) Go(serviceMethod string, args interface{}, reply interface{}, done chan *Call) *Call {
+	call := newCall(serviceMethod, args, reply, done)
+	client.send(call)
+	return call
+}
+
+func newCall(serviceMethod string, args interface{}, reply interface{}, done chan *Call) *Call {
+	call := new(Call)
+	call.ServiceMethod = serviceMethod
+	call.Args = args
+	call.Reply = reply
+	if done == nil {
+		done = make(chan *Call, 10)
+	} else {
+		//必须有缓冲，否则 done() 可能丢弃结果
+		if cap(done) == 0 {
+			log.Panic("rpc: done channel is unbuffered")
+		}
+	}
+	call.Done = done
+	return call
+}
+
+//同步调用
+func (client *Client) Call(serviceMethod string, args interface{}, reply interface{}) error {
+	call := <-client.Go(serviceMethod, args, reply, make(chan *Call, 1)).Done
+	return call.Error
+}
+
+//同步调用，ctx 结束时放弃等待，之后到达的响应被丢弃；ctx 中的元数据随请求发送
+func (client *Client) CallContext(ctx context.Context, serviceMethod string, args interface{}, reply interface{}) error {
+	call := newCall(serviceMethod, args, reply, make(chan *Call, 1))
+	call.Meta = outgoingMetadata(ctx)
+	client.send(call)
+	select {
+	case call = <-call.Done:
+		return call.Error
+	case <-ctx.Done():
+		client.abandon(call)
+		return ctx.Err()
+	}
+}
+
+//能够中止单个请求的编解码器，调用被放弃时通知它
+type requestCanceler interface {
+	cancelRequest(seq uint64)
+}
+
+//放弃等待的调用，之后到达的响应被丢弃
+func (client *Client) abandon(call *Call) {
+	client.mutex.Lock()
+	if client.pending[call.seq] == call {
+		delete(client.pending, call.seq)
+	}
+	client.mutex.Unlock()
+	if c, ok := client.codec.(requestCanceler); ok {
+		c.cancelRequest(call.seq)
+	}
+}
@@ -0,0 +1,154 @@
+package rpc_test
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/shengzhch/learn/jsonrpc"
+	"github.com/shengzhch/learn/msgpackrpc"
+	"github.com/shengzhch/learn/protorpc"
+	"github.com/shengzhch/learn/rpc"
+)
+
+//各编解码器在同一个服务上的对比
+
+type BenchArgs struct {
+	A, B int64
+	Data string
+}
+
+type BenchReply struct {
+	C    int64
+	Data string
+}
+
+//手写的 protobuf 编码，等价于 message BenchArgs { int64 a = 1; int64 b = 2; string data = 3; }
+func (m *BenchArgs) Marshal() ([]byte, error) {
+	b := protorpc.AppendVarintField(nil, 1, uint64(m.A))
+	b = protorpc.AppendVarintField(b, 2, uint64(m.B))
+	return protorpc.AppendBytesField(b, 3, []byte(m.Data)), nil
+}
+
+func (m *BenchArgs) Unmarshal(b []byte) error {
+	*m = BenchArgs{}
+	return unmarshalFields(b, func(num int, v uint64, p []byte) {
+		switch num {
+		case 1:
+			m.A = int64(v)
+		case 2:
+			m.B = int64(v)
+		case 3:
+			m.Data = string(p)
+		}
+	})
+}
+
+func (m *BenchReply) Marshal() ([]byte, error) {
+	b := protorpc.AppendVarintField(nil, 1, uint64(m.C))
+	return protorpc.AppendBytesField(b, 2, []byte(m.Data)), nil
+}
+
+func (m *BenchReply) Unmarshal(b []byte) error {
+	*m = BenchReply{}
+	return unmarshalFields(b, func(num int, v uint64, p []byte) {
+		switch num {
+		case 1:
+			m.C = int64(v)
+		case 2:
+			m.Data = string(p)
+		}
+	})
+}
+
+func unmarshalFields(b []byte, set func(num int, v uint64, p []byte)) error {
+	for len(b) > 0 {
+		num, typ, n := protorpc.ConsumeTag(b)
+		if n <= 0 {
+			return io.ErrUnexpectedEOF
+		}
+		b = b[n:]
+		switch typ {
+		case protorpc.WireVarint:
+			v, n := binary.Uvarint(b)
+			if n <= 0 {
+				return io.ErrUnexpectedEOF
+			}
+			set(num, v, nil)
+			b = b[n:]
+		case protorpc.WireBytes:
+			p, n := protorpc.ConsumeBytes(b)
+			if n <= 0 {
+				return io.ErrUnexpectedEOF
+			}
+			set(num, 0, p)
+			b = b[n:]
+		default:
+			n := protorpc.ConsumeField(typ, b)
+			if n < 0 {
+				return io.ErrUnexpectedEOF
+			}
+			b = b[n:]
+		}
+	}
+	return nil
+}
+
+type Bench int
+
+func (t *Bench) Echo(args *BenchArgs, reply *BenchReply) error {
+	reply.C = args.A * args.B
+	reply.Data = args.Data
+	return nil
+}
+
+type codecPair struct {
+	name   string
+	server func(io.ReadWriteCloser) rpc.ServerCodec
+	client func(io.ReadWriteCloser) *rpc.Client
+}
+
+var codecs = []codecPair{
+	{"gob", nil, rpc.NewClient},
+	{"json", jsonrpc.NewServerCodec, jsonrpc.NewClient},
+	{"msgpack", msgpackrpc.NewServerCodec, msgpackrpc.NewClient},
+	{"protobuf", protorpc.NewServerCodec, protorpc.NewClient},
+}
+
+func benchmarkCodecs(b *testing.B, size int) {
+	server := rpc.NewServer()
+	if err := server.Register(new(Bench)); err != nil {
+		b.Fatal(err)
+	}
+	args := &BenchArgs{A: 7, B: 8, Data: strings.Repeat("x", size)}
+
+	for _, cp := range codecs {
+		b.Run(cp.name, func(b *testing.B) {
+			cli, srv := net.Pipe()
+			if cp.server == nil {
+				go server.ServeConn(srv)
+			} else {
+				go server.ServeCodec(cp.server(srv))
+			}
+			client := cp.client(cli)
+			defer client.Close()
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				var reply BenchReply
+				if err := client.Call("Bench.Echo", args, &reply); err != nil {
+					b.Fatal(err)
+				}
+				if reply.C != 56 || len(reply.Data) != size {
+					b.Fatalf("bad reply %d %d", reply.C, len(reply.Data))
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkCodecSmall(b *testing.B) { benchmarkCodecs(b, 16) }
+func BenchmarkCodecLarge(b *testing.B) { benchmarkCodecs(b, 16<<10) }
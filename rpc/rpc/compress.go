@@ -0,0 +1,388 @@
+package rpc
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+连接级压缩协商：
+客户端在连接建立后先发送握手 "\x00RPCZ" + 1 字节长度 + 逗号分隔的算法列表（按优先级），
+服务端回复同样格式的一个算法名，"identity" 表示不压缩。
+gob 流不会以 0 字节开头（长度为 0 的消息不合法），所以 ServeConn 可以据此区分新旧客户端。
+
+协商之后双方都按帧读写：1 字节标记（0 原样，1 压缩）+ 4 字节大端长度 + 数据。
+每次底层 Write 是一帧，长度小于阈值的帧不压缩。
+gob 编解码器在分帧的连接上把一条消息整体写出，每条消息只压缩一次。
+snappy/zstd 无法在这里引入依赖，可以通过 RegisterCompressor 注册。
+*/
+
+const (
+	CompressIdentity = "identity"
+	CompressGzip     = "gzip"
+	CompressDeflate  = "deflate"
+)
+
+var handshakeMagic = []byte("\x00RPCZ")
+
+//默认阈值，小于该长度的帧不压缩
+const DefaultCompressThreshold = 1024
+
+type compressor struct {
+	compress   func(dst io.Writer, p []byte) error
+	decompress func(src io.Reader) io.Reader
+}
+
+var (
+	compressorsMu sync.RWMutex
+	compressors   = map[string]compressor{
+		CompressGzip: {
+			compress: func(dst io.Writer, p []byte) error {
+				zw := gzipWriters.Get().(*gzip.Writer)
+				defer gzipWriters.Put(zw)
+				zw.Reset(dst)
+				if _, err := zw.Write(p); err != nil {
+					return err
+				}
+				return zw.Close()
+			},
+			decompress: func(src io.Reader) io.Reader {
+				zr, err := gzip.NewReader(src)
+				if err != nil {
+					return errReader{err}
+				}
+				return zr
+			},
+		},
+		CompressDeflate: {
+			compress: func(dst io.Writer, p []byte) error {
+				zw := flateWriters.Get().(*flate.Writer)
+				defer flateWriters.Put(zw)
+				zw.Reset(dst)
+				if _, err := zw.Write(p); err != nil {
+					return err
+				}
+				return zw.Close()
+			},
+			decompress: func(src io.Reader) io.Reader {
+				return flate.NewReader(src)
+			},
+		},
+	}
+)
+
+//压缩器分配的内存较多，每帧复用
+var (
+	gzipWriters  = sync.Pool{New: func() interface{} { return gzip.NewWriter(nil) }}
+	flateWriters = sync.Pool{New: func() interface{} {
+		zw, _ := flate.NewWriter(nil, flate.DefaultCompression)
+		return zw
+	}}
+)
+
+//解压后一帧的默认上限，服务端设置了大小限制时使用请求头和请求体限制之和
+const DefaultMaxDecompressedFrame = 64 << 20
+
+type errReader struct{ err error }
+
+func (r errReader) Read([]byte) (int, error) { return 0, r.err }
+
+//注册压缩算法，已有的同名算法会被替换
+func RegisterCompressor(name string, compress func(dst io.Writer, p []byte) error, decompress func(src io.Reader) io.Reader) {
+	compressorsMu.Lock()
+	compressors[name] = compressor{compress: compress, decompress: decompress}
+	compressorsMu.Unlock()
+}
+
+func getCompressor(name string) (compressor, bool) {
+	compressorsMu.RLock()
+	c, ok := compressors[name]
+	compressorsMu.RUnlock()
+	return c, ok
+}
+
+//压缩选项，Algorithms 按优先级排列
+type CompressOptions struct {
+	Algorithms []string
+	Threshold  int //小于该长度的帧不压缩，0 时使用 DefaultCompressThreshold，CompressAll 表示都压缩
+}
+
+//Threshold 取这个值时所有帧都压缩
+const CompressAll = -1
+
+func (o *CompressOptions) threshold() int {
+	switch {
+	case o.Threshold == 0:
+		return DefaultCompressThreshold
+	case o.Threshold < 0:
+		return 0
+	}
+	return o.Threshold
+}
+
+//开启服务端压缩协商，opts 为 nil 时关闭
+func (server *Server) SetCompression(opts *CompressOptions) {
+	server.compress = opts
+}
+
+func writeHandshake(w io.Writer, s string) error {
+	if len(s) > 255 {
+		return errors.New("rpc: compression handshake too long")
+	}
+	buf := append(append([]byte{}, handshakeMagic...), byte(len(s)))
+	_, err := w.Write(append(buf, s...))
+	return err
+}
+
+func readHandshake(r io.Reader) (string, error) {
+	buf := make([]byte, len(handshakeMagic)+1)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return "", err
+	}
+	if !bytes.Equal(buf[:len(handshakeMagic)], handshakeMagic) {
+		return "", errors.New("rpc: bad compression handshake")
+	}
+	s := make([]byte, buf[len(buf)-1])
+	if _, err := io.ReadFull(r, s); err != nil {
+		return "", err
+	}
+	return string(s), nil
+}
+
+//带缓冲读的连接，用于握手时的预读
+type bufferedConn struct {
+	*bufio.Reader
+	io.WriteCloser
+}
+
+func (c *bufferedConn) SetReadDeadline(t time.Time) error {
+	return SetReadDeadline(c.WriteCloser, t)
+}
+
+func (c *bufferedConn) SetWriteDeadline(t time.Time) error {
+	return SetWriteDeadline(c.WriteCloser, t)
+}
+
+/*
+服务端握手：客户端发送了握手时完成协商并返回分帧的连接，
+没有握手的旧客户端原样返回（已预读的数据不会丢失）
+*/
+func ServerHandshake(conn io.ReadWriteCloser, opts *CompressOptions) (io.ReadWriteCloser, error) {
+	br := bufio.NewReader(conn)
+	rwc := &bufferedConn{br, conn}
+	if p, err := br.Peek(1); err != nil || p[0] != 0 {
+		return rwc, nil
+	}
+
+	offer, err := readHandshake(br)
+	if err != nil {
+		return nil, err
+	}
+
+	chosen := CompressIdentity
+	if opts != nil {
+		offered := strings.Split(offer, ",")
+	search:
+		for _, want := range opts.Algorithms {
+			for _, name := range offered {
+				if _, ok := getCompressor(want); ok && name == want {
+					chosen = want
+					break search
+				}
+			}
+		}
+	}
+	if err := writeHandshake(conn, chosen); err != nil {
+		return nil, err
+	}
+
+	threshold := 0
+	if opts != nil {
+		threshold = opts.threshold()
+	}
+	return newCompressConn(rwc, chosen, threshold), nil
+}
+
+//按服务端的大小限制设置解压上限
+func (server *Server) limitDecompressed(conn io.ReadWriteCloser) {
+	c, ok := conn.(*compressConn)
+	if !ok || server.maxBodySize == 0 {
+		return
+	}
+	c.maxFrame = int64(server.maxHeaderSize + server.maxBodySize)
+	if server.maxHeaderSize == 0 {
+		c.maxFrame += DefaultHeaderAllowance
+	}
+}
+
+//gob 编解码器的写缓冲，Flush 时写出一条完整的消息
+type flushWriter interface {
+	io.Writer
+	Flush() error
+}
+
+//分帧的连接上缓存整条消息，一次 Write 写出；普通连接使用 bufio.Writer
+func newWriteBuffer(conn io.Writer) flushWriter {
+	if _, ok := conn.(*compressConn); ok {
+		return &messageWriter{w: conn}
+	}
+	return bufio.NewWriter(conn)
+}
+
+type messageWriter struct {
+	w   io.Writer
+	buf bytes.Buffer
+}
+
+func (m *messageWriter) Write(p []byte) (int, error) {
+	return m.buf.Write(p)
+}
+
+func (m *messageWriter) Flush() error {
+	if m.buf.Len() == 0 {
+		return nil
+	}
+	_, err := m.w.Write(m.buf.Bytes())
+	m.buf.Reset()
+	//不长期占用大消息的缓冲
+	if m.buf.Cap() > 1<<20 {
+		m.buf = bytes.Buffer{}
+	}
+	return err
+}
+
+//客户端握手，返回分帧的连接
+func ClientHandshake(conn io.ReadWriteCloser, opts *CompressOptions) (io.ReadWriteCloser, error) {
+	if err := writeHandshake(conn, strings.Join(opts.Algorithms, ",")); err != nil {
+		return nil, err
+	}
+	br := bufio.NewReader(conn)
+	chosen, err := readHandshake(br)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := getCompressor(chosen); !ok && chosen != CompressIdentity {
+		return nil, errors.New("rpc: server chose unknown compression " + chosen)
+	}
+	return newCompressConn(&bufferedConn{br, conn}, chosen, opts.threshold()), nil
+}
+
+//连接到 rpc 服务并协商压缩
+func DialCompressed(network, address string, opts *CompressOptions) (*Client, error) {
+	conn, err := net.Dial(network, address)
+	if err != nil {
+		return nil, err
+	}
+	rwc, err := ClientHandshake(conn, opts)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return NewClient(rwc), nil
+}
+
+//按帧读写的连接
+type compressConn struct {
+	rwc       io.ReadWriteCloser
+	comp      compressor
+	enabled   bool
+	threshold int
+
+	rd       io.Reader //当前帧剩余的数据
+	rhead    [5]byte
+	maxFrame int64 //解压后一帧的上限，防止压缩炸弹
+
+	wbuf bytes.Buffer
+}
+
+func newCompressConn(rwc io.ReadWriteCloser, name string, threshold int) *compressConn {
+	c := &compressConn{rwc: rwc, threshold: threshold, rd: bytes.NewReader(nil), maxFrame: DefaultMaxDecompressedFrame}
+	c.comp, c.enabled = getCompressor(name)
+	return c
+}
+
+func (c *compressConn) Read(p []byte) (int, error) {
+	for {
+		n, err := c.rd.Read(p)
+		if n > 0 || (err != nil && err != io.EOF) {
+			return n, err
+		}
+		if err := c.nextFrame(); err != nil {
+			return 0, err
+		}
+	}
+}
+
+func (c *compressConn) nextFrame() error {
+	if _, err := io.ReadFull(c.rwc, c.rhead[:]); err != nil {
+		return err
+	}
+	n := int64(binary.BigEndian.Uint32(c.rhead[1:]))
+	frame := io.LimitReader(c.rwc, n)
+
+	switch c.rhead[0] {
+	case 0:
+		c.rd = frame
+	case 1:
+		if !c.enabled {
+			return errors.New("rpc: compressed frame on uncompressed connection")
+		}
+		//整帧解压，避免帧边界和解压器的预读交错
+		data, err := io.ReadAll(io.LimitReader(c.comp.decompress(frame), c.maxFrame+1))
+		if err != nil {
+			return err
+		}
+		if int64(len(data)) > c.maxFrame {
+			return errors.New("rpc: decompressed frame exceeds " + strconv.FormatInt(c.maxFrame, 10) + " bytes")
+		}
+		io.Copy(io.Discard, frame)
+		c.rd = bytes.NewReader(data)
+	default:
+		return errors.New("rpc: bad frame flag")
+	}
+	return nil
+}
+
+func (c *compressConn) Write(p []byte) (int, error) {
+	c.wbuf.Reset()
+	c.wbuf.Write([]byte{0, 0, 0, 0, 0})
+	if c.enabled && len(p) >= c.threshold {
+		if err := c.comp.compress(&c.wbuf, p); err != nil {
+			return 0, err
+		}
+	}
+	b := c.wbuf.Bytes()
+	//压缩没有变小时原样发送
+	if len(b) == 5 || len(b)-5 >= len(p) {
+		b = append(b[:5], p...)
+	} else {
+		b[0] = 1
+	}
+	binary.BigEndian.PutUint32(b[1:5], uint32(len(b)-5))
+	if _, err := c.rwc.Write(b); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (c *compressConn) SetReadDeadline(t time.Time) error {
+	return SetReadDeadline(c.rwc, t)
+}
+
+func (c *compressConn) SetWriteDeadline(t time.Time) error {
+	return SetWriteDeadline(c.rwc, t)
+}
+
+func (c *compressConn) Close() error {
+	return c.rwc.Close()
+}
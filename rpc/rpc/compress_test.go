@@ -0,0 +1,170 @@
+package rpc
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+//统计服务端写出的字节数
+type countingConn struct {
+	net.Conn
+	n *int
+}
+
+func (c countingConn) Write(p []byte) (int, error) {
+	*c.n += len(p)
+	return c.Conn.Write(p)
+}
+
+func TestCompressionNegotiation(t *testing.T) {
+	opts := &CompressOptions{Algorithms: []string{CompressGzip}}
+	small := "hello"
+	big := strings.Repeat("abc", 10000)
+
+	written := make(map[bool]int)
+	for _, compressed := range []bool{false, true} {
+		cli, srv := net.Pipe()
+		n := 0
+		done := make(chan error, 1)
+		go func() {
+			var w io.WriteCloser = countingConn{srv, &n}
+			if compressed {
+				rwc, err := ServerHandshake(countingConn{srv, &n}, opts)
+				if err != nil {
+					done <- err
+					return
+				}
+				w = rwc
+			}
+			for _, s := range []string{small, big} {
+				if _, err := io.WriteString(w, s); err != nil {
+					done <- err
+					return
+				}
+			}
+			done <- w.Close()
+		}()
+
+		var r io.Reader = cli
+		if compressed {
+			//服务端不支持 zstd，应当选中 gzip
+			rwc, err := ClientHandshake(cli, &CompressOptions{Algorithms: []string{"zstd", CompressGzip}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			r = rwc
+		}
+		got, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := <-done; err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != small+big {
+			t.Fatalf("read %d bytes, want %d", len(got), len(small+big))
+		}
+		cli.Close()
+		written[compressed] = n
+	}
+
+	if written[true]*10 > written[false] {
+		t.Fatalf("compressed connection wrote %d bytes, plain wrote %d", written[true], written[false])
+	}
+}
+
+type Text int
+
+func (t *Text) Repeat(n *int, s *string) error {
+	*s = strings.Repeat("abc", *n)
+	return nil
+}
+
+func TestCompressedCall(t *testing.T) {
+	server := NewServer()
+	server.Register(new(Text))
+	server.SetCompression(&CompressOptions{Algorithms: []string{CompressGzip}})
+
+	cli, srv := net.Pipe()
+	go server.ServeConn(srv)
+	rwc, err := ClientHandshake(cli, &CompressOptions{Algorithms: []string{CompressGzip}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := NewClient(rwc)
+	defer client.Close()
+
+	for _, count := range []int{1, 10000} {
+		var reply string
+		if err := client.Call("Text.Repeat", &count, &reply); err != nil {
+			t.Fatal(err)
+		}
+		if len(reply) != 3*count {
+			t.Fatalf("reply length %d, want %d", len(reply), 3*count)
+		}
+	}
+}
+
+func TestDecompressedFrameLimit(t *testing.T) {
+	server := NewServer()
+	server.SetMaxBodySize(1 << 10)
+
+	var frame bytes.Buffer
+	comp, _ := getCompressor(CompressGzip)
+	if err := comp.compress(&frame, make([]byte, 1<<20)); err != nil {
+		t.Fatal(err)
+	}
+	head := []byte{1, 0, 0, 0, 0}
+	head[4] = byte(frame.Len())
+	head[3] = byte(frame.Len() >> 8)
+	wire := append(head, frame.Bytes()...)
+
+	c := newCompressConn(nopConn{bytes.NewReader(wire)}, CompressGzip, 0)
+	server.limitDecompressed(c)
+	if _, err := io.ReadAll(c); err == nil || !strings.Contains(err.Error(), "decompressed frame exceeds") {
+		t.Fatalf("err = %v, want decompressed frame limit", err)
+	}
+}
+
+//只读的测试连接
+type nopConn struct{ io.Reader }
+
+func (nopConn) Write(p []byte) (int, error) { return len(p), nil }
+func (nopConn) Close() error                { return nil }
+
+//记录每次 Write 的长度
+type writesConn struct {
+	nopConn
+	writes []int
+}
+
+func (c *writesConn) Write(p []byte) (int, error) {
+	c.writes = append(c.writes, len(p))
+	return len(p), nil
+}
+
+//大的响应整体压缩成一帧，CompressAll 时小的帧也压缩
+func TestCompressPerMessage(t *testing.T) {
+	w := &writesConn{nopConn: nopConn{bytes.NewReader(nil)}}
+	codec := NewGobServerCodec(newCompressConn(w, CompressGzip, DefaultCompressThreshold))
+	if err := codec.WriteResponse(&Response{ServiceMethod: "Text.Repeat", Seq: 1}, strings.Repeat("abc", 50000)); err != nil {
+		t.Fatal(err)
+	}
+	if len(w.writes) != 1 || w.writes[0] > 4096 {
+		t.Errorf("frames written: %v, want one compressed frame", w.writes)
+	}
+
+	opts := &CompressOptions{Threshold: CompressAll}
+	c := newCompressConn(w, CompressGzip, opts.threshold())
+	w.writes = nil
+	c.Write(bytes.Repeat([]byte("a"), 100))
+	if len(w.writes) != 1 || w.writes[0] >= 105 {
+		t.Errorf("CompressAll wrote %v", w.writes)
+	}
+	if (&CompressOptions{}).threshold() != DefaultCompressThreshold {
+		t.Error("zero Threshold should use the default")
+	}
+}
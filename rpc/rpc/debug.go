@@ -1,13 +1,137 @@
 package rpc
 
-import "net/http"
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"net/http"
+	"sort"
+	"sync/atomic"
+)
 
-var debugLog = false
+//是否输出调试日志，可以通过 SetDebugLog 或 Admin.SetDebugLog 在运行时修改
+var debugLog atomic.Bool
+
+//修改 debugLog，返回之前的值
+func SetDebugLog(on bool) bool {
+	return debugLog.Swap(on)
+}
+
+const debugText = `<html>
+	<body>
+	<title>Services</title>
+	{{range .Services}}
+	<hr>
+	Service {{.Name}}
+	<hr>
+		<table>
+		<th align=center>Method</th><th align=center>Calls</th>
+		{{range .Method}}
+			<tr>
+			<td align=left font=fixed>{{.Name}}({{.Type.ArgType}}, {{.Type.ReplyType}}) error</td>
+			<td align=center>{{.Type.Numcalls}}</td>
+			</tr>
+		{{end}}
+		</table>
+	{{end}}
+	<hr>
+	Connections
+	<hr>
+		<table>
+		<th align=center>ID</th><th align=center>Remote</th><th align=center>In flight</th><th align=center>Age</th>
+		{{range .Conns}}
+			<tr>
+			<td align=center>{{.ID}}</td>
+			<td align=left font=fixed>{{.Network}} {{.RemoteAddr}}{{with .Cred}} ({{.}}){{end}}</td>
+			<td align=center>{{.InFlight}}</td>
+			<td align=center>{{.Age}}</td>
+			</tr>
+		{{end}}
+		</table>
+	{{with .Breakers}}
+	<hr>
+	Circuit breakers
+	<hr>
+		<table>
+		<th align=center>Endpoint</th><th align=center>Method</th><th align=center>State</th><th align=center>Requests</th><th align=center>Failures</th><th align=center>Canceled</th><th align=center>Rejected</th><th align=center>Opened</th>
+		{{range .}}
+			<tr>
+			<td align=left font=fixed>{{.Endpoint}}</td>
+			<td align=left font=fixed>{{.ServiceMethod}}</td>
+			<td align=center>{{.State}}</td>
+			<td align=center>{{.Requests}}</td>
+			<td align=center>{{.Failures}}</td>
+			<td align=center>{{.Canceled}}</td>
+			<td align=center>{{.Rejected}}</td>
+			<td align=center>{{.Opened}}</td>
+			</tr>
+		{{end}}
+		</table>
+	{{end}}
+	</body>
+	</html>`
+
+var debug = template.Must(template.New("RPC debug").Parse(debugText))
+
+type debugMethod struct {
+	Type *methodType
+	Name string
+}
+
+type methodArray []debugMethod
+
+type debugService struct {
+	Service *service
+	Name    string
+	Method  methodArray
+}
+
+type serviceArray []debugService
+
+func (s serviceArray) Len() int           { return len(s) }
+func (s serviceArray) Less(i, j int) bool { return s[i].Name < s[j].Name }
+func (s serviceArray) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+func (m methodArray) Len() int           { return len(m) }
+func (m methodArray) Less(i, j int) bool { return m[i].Name < m[j].Name }
+func (m methodArray) Swap(i, j int)      { m[i], m[j] = m[j], m[i] }
 
 type debugHTTP struct {
 	*Server
 }
 
-func (Server *debugHTTP) ServeHTTP(w http.ResponseWriter, req *http.Request) {
-	//todo write
+//列出注册的服务、各方法的调用次数、当前连接和熔断器。
+//和 Admin.Connections 经过同样的检查，ctx 中的对端是 HTTP 客户端，元数据来自 Rpc-Meta-<key> 头
+func (server *debugHTTP) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	c := &Conn{peer: &Peer{Network: "tcp", Addr: req.RemoteAddr}}
+	ctx := context.WithValue(req.Context(), connKey{}, c)
+	if md := headerMeta(req.Header); md != nil {
+		ctx = context.WithValue(ctx, incomingMetaKey{}, md)
+	}
+	if err := server.authorizeName(ctx, "Admin.Connections", true); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	var services serviceArray
+	server.serviceMap.Range(func(snamei, svci interface{}) bool {
+		svc := svci.(*service)
+		ds := debugService{svc, snamei.(string), make(methodArray, 0, len(svc.method))}
+		for mname, method := range svc.method {
+			ds.Method = append(ds.Method, debugMethod{method, mname})
+		}
+		sort.Sort(ds.Method)
+		services = append(services, ds)
+		return true
+	})
+	sort.Sort(services)
+
+	data := struct {
+		Services serviceArray
+		Conns    []ConnInfo
+		Breakers []BreakerStats
+	}{services, server.Connections(), server.BreakerStats()}
+	if err := debug.Execute(w, data); err != nil {
+		fmt.Fprintln(w, "rpc: error executing template:", err.Error())
+	}
 }
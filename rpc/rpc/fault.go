@@ -0,0 +1,115 @@
+package rpc
+
+import (
+	"context"
+	"math/rand"
+	"path"
+	"sync"
+	"time"
+)
+
+/*
+故障注入，用于检验客户端的重试和超时逻辑：
+匹配 Pattern 的调用按 Percent 的概率先等待 Delay，然后
+  - Drop 为真时不回复，直接关闭连接
+  - Error 非空时不调用方法，回复这个错误
+  - 都没有时照常调用，只增加延迟
+Pattern 使用 path.Match 的语法，例如 "Arith.*"、"*.Divide"，按顺序第一条匹配的规则生效；
+匹配的是解析后的 "服务名.方法名"，别名和大小写不敏感的写法不会绕过规则。
+异步任务被 Drop 时任务记为失败。
+
+RegisterFaultInjector 注册名为 "Fault" 的服务，可以在运行时通过 RPC 修改规则，这个服务本身不受规则影响。
+和 Admin 一样，没有设置 Authorizer 时 Fault 的调用一律拒绝。
+*/
+
+type Fault struct {
+	Pattern string
+	Percent float64 //0 到 100
+	Delay   time.Duration
+	Error   string
+	Drop    bool
+}
+
+type faultInjector struct {
+	mu     sync.RWMutex
+	faults []Fault
+}
+
+//替换所有规则，nil 表示关闭
+func (server *Server) SetFaults(faults []Fault) error {
+	for _, f := range faults {
+		if _, err := path.Match(f.Pattern, ""); err != nil {
+			return err
+		}
+	}
+	fi := &server.faults
+	fi.mu.Lock()
+	fi.faults = append([]Fault(nil), faults...)
+	fi.mu.Unlock()
+	return nil
+}
+
+func (server *Server) Faults() []Fault {
+	fi := &server.faults
+	fi.mu.RLock()
+	defer fi.mu.RUnlock()
+	return append([]Fault(nil), fi.faults...)
+}
+
+//按规则等待，返回命中的规则
+func (fi *faultInjector) inject(ctx context.Context, serviceMethod string) *Fault {
+	fi.mu.RLock()
+	if len(fi.faults) == 0 {
+		fi.mu.RUnlock()
+		return nil
+	}
+	var hit *Fault
+	for i := range fi.faults {
+		if ok, _ := path.Match(fi.faults[i].Pattern, serviceMethod); ok {
+			if rand.Float64()*100 < fi.faults[i].Percent {
+				f := fi.faults[i]
+				hit = &f
+			}
+			break
+		}
+	}
+	fi.mu.RUnlock()
+
+	if hit != nil && hit.Delay > 0 {
+		t := time.NewTimer(hit.Delay)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+		}
+	}
+	return hit
+}
+
+//运行时修改故障规则的服务
+type FaultService struct {
+	server *Server
+}
+
+func (server *Server) RegisterFaultInjector() error {
+	return server.RegisterName(&FaultService{server}, "Fault")
+}
+
+//替换规则，返回规则数
+func (s *FaultService) Set(args []Fault, reply *int) error {
+	if err := s.server.SetFaults(args); err != nil {
+		return err
+	}
+	*reply = len(args)
+	return nil
+}
+
+func (s *FaultService) List(args int, reply *[]Fault) error {
+	*reply = s.server.Faults()
+	return nil
+}
+
+func (s *FaultService) Clear(args int, reply *int) error {
+	*reply = len(s.server.Faults())
+	return s.server.SetFaults(nil)
+}
@@ -0,0 +1,137 @@
+package rpc
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestFaultInjection(t *testing.T) {
+	server := NewServer()
+	server.Register(new(Echo))
+	if err := server.RegisterFaultInjector(); err != nil {
+		t.Fatal(err)
+	}
+
+	dial := func() *Client {
+		cli, srv := net.Pipe()
+		go server.ServeConn(srv)
+		return NewClient(cli)
+	}
+	client := dial()
+	defer client.Close()
+
+	var n int
+	//没有 Authorizer 时拒绝
+	if err := client.Call("Fault.Set", []Fault{{Pattern: "*", Percent: 100, Drop: true}}, &n); err == nil || err.Error() != ErrPermissionDenied.Error() {
+		t.Fatalf("Fault.Set without authorizer: %v", err)
+	}
+	if len(server.Faults()) != 0 {
+		t.Fatal("unauthorized Fault.Set changed the rules")
+	}
+	server.SetAuthorizer(func(ctx context.Context, serviceMethod string) error { return nil })
+
+	if err := client.Call("Fault.Set", []Fault{{Pattern: "Echo.[", Percent: 100}}, &n); err == nil {
+		t.Error("bad pattern accepted")
+	}
+
+	//规则按顺序匹配，第一条匹配的生效
+	rules := []Fault{
+		{Pattern: "Echo.Say", Percent: 0, Error: "never"},
+		{Pattern: "Echo.*", Percent: 100, Error: "injected"},
+	}
+	if err := client.Call("Fault.Set", rules, &n); err != nil || n != 2 {
+		t.Fatalf("Fault.Set = %d, %v", n, err)
+	}
+	var reply string
+	if err := client.Call("Echo.Say", "hi", &reply); err != nil || reply != "hi" {
+		t.Errorf("0%% rule fired: %q, %v", reply, err)
+	}
+
+	rules[0].Percent = 100
+	rules[0].Delay = 30 * time.Millisecond
+	client.Call("Fault.Set", rules, &n)
+	start := time.Now()
+	if err := client.Call("Echo.Say", "hi", &reply); err == nil || err.Error() != "never" {
+		t.Errorf("expected injected error, got %v", err)
+	}
+	if d := time.Since(start); d < 30*time.Millisecond {
+		t.Errorf("call took %v, want delay", d)
+	}
+
+	var list []Fault
+	if err := client.Call("Fault.List", 0, &list); err != nil || len(list) != 2 || list[0].Delay != 30*time.Millisecond {
+		t.Errorf("Fault.List = %+v, %v", list, err)
+	}
+
+	client.Call("Fault.Set", []Fault{{Pattern: "*", Percent: 100, Drop: true}}, &n)
+	if err := client.Call("Echo.Say", "hi", &reply); err == nil {
+		t.Error("dropped call succeeded")
+	}
+	if err := client.Call("Echo.Say", "hi", &reply); err != ErrShutdown {
+		t.Errorf("connection not closed: %v", err)
+	}
+
+	//管理服务本身不受规则影响
+	client = dial()
+	defer client.Close()
+	if err := client.Call("Fault.Clear", 0, &n); err != nil || n != 1 {
+		t.Fatalf("Fault.Clear = %d, %v", n, err)
+	}
+	if err := client.Call("Echo.Say", "hi", &reply); err != nil {
+		t.Errorf("after clear: %v", err)
+	}
+}
+
+//规则按解析后的名字匹配，别名和大小写不同的写法也会命中
+func TestFaultMatchesResolvedName(t *testing.T) {
+	server := NewServer()
+	server.Register(new(Echo))
+	if err := server.RegisterAlias("Talk.It", "Echo.Say"); err != nil {
+		t.Fatal(err)
+	}
+	server.SetCaseInsensitive(true)
+	server.SetFaults([]Fault{{Pattern: "Echo.Say", Percent: 100, Error: "injected"}})
+
+	cli, srv := net.Pipe()
+	go server.ServeConn(srv)
+	client := NewClient(cli)
+	defer client.Close()
+
+	for _, m := range []string{"Echo.Say", "Talk.It", "echo.say"} {
+		var reply string
+		if err := client.Call(m, "hi", &reply); err == nil || err.Error() != "injected" {
+			t.Errorf("%s: err = %v, want injected", m, err)
+		}
+	}
+}
+
+//异步任务被 Drop 时记为失败，不会一直处于 running
+func TestFaultDropJob(t *testing.T) {
+	server := NewServer()
+	server.Register(new(Echo))
+	server.RegisterJobs(nil)
+	server.SetFaults([]Fault{{Pattern: "Echo.*", Percent: 100, Drop: true}})
+
+	cli, srv := net.Pipe()
+	go server.ServeConn(srv)
+	client := NewClient(cli)
+	defer client.Close()
+
+	id, err := client.StartJob(context.Background(), "Echo.Say", "hi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitJob(t, client, id, JobFailed)
+	var reply string
+	if err := client.JobResult(context.Background(), id, &reply); err == nil || err.Error() != errJobDropped.Error() {
+		t.Errorf("JobResult = %v", err)
+	}
+	server.jobs.mu.Lock()
+	n := len(server.jobs.running)
+	server.jobs.mu.Unlock()
+	if n != 0 {
+		t.Errorf("%d running entries leaked", n)
+	}
+}
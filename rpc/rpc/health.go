@@ -0,0 +1,178 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+)
+
+/*
+健康检查服务：
+Health.Check 返回某个服务的状态，Service 为空表示整个服务端；
+Health.Watch 先返回当前状态，之后状态变化时通过连接推送 "Health.Watch" 消息（见 Conn.Notify），
+客户端用 OnNotify("Health.Watch", func(*HealthCheckResponse)) 接收。
+应用代码通过 SetServingStatus 修改状态，ServeHTTP 以同样的状态响应 HTTP 探针。
+*/
+
+const DefaultHealthPath = "/healthz"
+
+type ServingStatus int
+
+const (
+	StatusUnknown ServingStatus = iota
+	StatusServing
+	StatusNotServing
+)
+
+func (s ServingStatus) String() string {
+	switch s {
+	case StatusServing:
+		return "SERVING"
+	case StatusNotServing:
+		return "NOT_SERVING"
+	}
+	return "UNKNOWN"
+}
+
+var ErrUnknownHealthService = errors.New("rpc: unknown service for health check")
+
+type HealthCheckRequest struct {
+	Service string
+}
+
+type HealthCheckResponse struct {
+	Service string
+	Status  ServingStatus
+}
+
+type Health struct {
+	mu       sync.Mutex
+	status   map[string]ServingStatus
+	watchers map[string]map[*Conn]bool
+	onChange func(service string, status ServingStatus)
+}
+
+//整个服务端的初始状态为 StatusServing
+func NewHealth() *Health {
+	return &Health{
+		status:   map[string]ServingStatus{"": StatusServing},
+		watchers: make(map[string]map[*Conn]bool),
+	}
+}
+
+//注册名为 "Health" 的健康检查服务，整个服务端的状态同时用于服务发现的健康标记
+func (server *Server) RegisterHealth() (*Health, error) {
+	h := NewHealth()
+	h.onChange = func(service string, status ServingStatus) {
+		if service == "" {
+			server.SetHealthy(status == StatusServing)
+		}
+	}
+	if err := server.RegisterName(&healthService{h}, "Health"); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+//注册到 Server 的接收者，只暴露 Check 和 Watch，Health 的其他方法不符合 rpc 要求，注册时会打印警告
+type healthService struct {
+	h *Health
+}
+
+func (s *healthService) Check(args *HealthCheckRequest, reply *HealthCheckResponse) error {
+	return s.h.Check(args, reply)
+}
+
+func (s *healthService) Watch(ctx context.Context, args *HealthCheckRequest, reply *HealthCheckResponse) error {
+	return s.h.Watch(ctx, args, reply)
+}
+
+//设置服务状态并通知 Watch 的连接
+func (h *Health) SetServingStatus(service string, status ServingStatus) {
+	h.mu.Lock()
+	if old, ok := h.status[service]; ok && old == status {
+		h.mu.Unlock()
+		return
+	}
+	h.status[service] = status
+	var conns []*Conn
+	for c := range h.watchers[service] {
+		conns = append(conns, c)
+	}
+	onChange := h.onChange
+	h.mu.Unlock()
+
+	if onChange != nil {
+		onChange(service, status)
+	}
+	for _, c := range conns {
+		if c.Notify("Health.Watch", &HealthCheckResponse{Service: service, Status: status}) == ErrConnClosed {
+			h.unwatch(service, c)
+		}
+	}
+}
+
+func (h *Health) get(service string) (ServingStatus, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	status, ok := h.status[service]
+	return status, ok
+}
+
+func (h *Health) Check(args *HealthCheckRequest, reply *HealthCheckResponse) error {
+	status, ok := h.get(args.Service)
+	if !ok {
+		return ErrUnknownHealthService
+	}
+	reply.Service = args.Service
+	reply.Status = status
+	return nil
+}
+
+//返回当前状态，并在连接关闭前推送之后的变化，未知的服务返回 StatusUnknown
+func (h *Health) Watch(ctx context.Context, args *HealthCheckRequest, reply *HealthCheckResponse) error {
+	reply.Service = args.Service
+	reply.Status, _ = h.get(args.Service)
+
+	conn, ok := ConnFromContext(ctx)
+	if !ok {
+		return nil
+	}
+	h.mu.Lock()
+	if h.watchers[args.Service] == nil {
+		h.watchers[args.Service] = make(map[*Conn]bool)
+	}
+	h.watchers[args.Service][conn] = true
+	h.mu.Unlock()
+
+	go func() {
+		<-conn.Context().Done()
+		h.unwatch(args.Service, conn)
+	}()
+	return nil
+}
+
+func (h *Health) unwatch(service string, c *Conn) {
+	h.mu.Lock()
+	delete(h.watchers[service], c)
+	if len(h.watchers[service]) == 0 {
+		delete(h.watchers, service)
+	}
+	h.mu.Unlock()
+}
+
+//HTTP 探针：?service= 指定服务，SERVING 返回 200，其他状态返回 503，未知服务返回 404
+func (h *Health) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	status, ok := h.get(req.URL.Query().Get("service"))
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	switch {
+	case !ok:
+		w.WriteHeader(http.StatusNotFound)
+	case status != StatusServing:
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	io.WriteString(w, status.String()+"\n")
+}
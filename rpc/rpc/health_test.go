@@ -0,0 +1,89 @@
+package rpc
+
+import (
+	"bytes"
+	"log"
+	"net"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestHealth(t *testing.T) {
+	server := NewServer()
+	server.Register(new(Echo))
+	health, err := server.RegisterHealth()
+	if err != nil {
+		t.Fatal(err)
+	}
+	health.SetServingStatus("Echo", StatusServing)
+
+	cli, srv := net.Pipe()
+	go server.ServeConn(srv)
+	client := NewClient(cli)
+	defer client.Close()
+
+	updates := make(chan *HealthCheckResponse, 4)
+	client.OnNotify("Health.Watch", func(r *HealthCheckResponse) { updates <- r })
+
+	var reply HealthCheckResponse
+	if err := client.Call("Health.Check", &HealthCheckRequest{}, &reply); err != nil || reply.Status != StatusServing {
+		t.Fatalf("Check(\"\") = %v, %v", reply.Status, err)
+	}
+	if err := client.Call("Health.Check", &HealthCheckRequest{Service: "Nope"}, &reply); err == nil {
+		t.Error("Check of unknown service should fail")
+	}
+	if err := client.Call("Health.Watch", &HealthCheckRequest{Service: "Echo"}, &reply); err != nil || reply.Status != StatusServing {
+		t.Fatalf("Watch = %v, %v", reply.Status, err)
+	}
+
+	health.SetServingStatus("Echo", StatusNotServing)
+	select {
+	case r := <-updates:
+		if r.Service != "Echo" || r.Status != StatusNotServing {
+			t.Errorf("update = %+v", r)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("no update pushed")
+	}
+
+	for _, tt := range []struct {
+		query  string
+		code   int
+		status string
+	}{
+		{"", 200, "SERVING\n"},
+		{"?service=Echo", 503, "NOT_SERVING\n"},
+		{"?service=Nope", 404, "UNKNOWN\n"},
+	} {
+		w := httptest.NewRecorder()
+		health.ServeHTTP(w, httptest.NewRequest("GET", DefaultHealthPath+tt.query, nil))
+		if w.Code != tt.code || w.Body.String() != tt.status {
+			t.Errorf("GET %q = %d %q, want %d %q", tt.query, w.Code, w.Body, tt.code, tt.status)
+		}
+	}
+}
+
+//只注册 Check 和 Watch，不会为 Health 的其他方法打印警告
+func TestRegisterHealthQuiet(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	server := NewServer()
+	if _, err := server.RegisterHealth(); err != nil {
+		t.Fatal(err)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("RegisterHealth logged:\n%s", buf.String())
+	}
+	for _, m := range []string{"Health.Check", "Health.Watch"} {
+		if _, _, err := server.lookup(m); err != nil {
+			t.Errorf("%s: %v", m, err)
+		}
+	}
+	if _, _, err := server.lookup("Health.SetServingStatus"); err == nil {
+		t.Error("Health.SetServingStatus should not be exported")
+	}
+}
@@ -0,0 +1,371 @@
+package rpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+/*
+HTTP/2 传输：每次调用是一个独立的 HTTP 请求，在 HTTP/2 上对应一个 stream。
+	POST <prefix>/Service.Method
+请求体是参数，响应体是结果，编码由 Content-Type 决定（gob 或 JSON）。
+元数据放在 Rpc-Meta-<key> 头中，服务端错误放在 Rpc-Error 头中，此时状态码为 500，请求过大时为 413。
+不需要 Hijack，可以经过 HTTP/2 代理；http:// 地址使用 h2c（不加密的 HTTP/2）。
+每个调用只有一个响应，不支持服务端推送（Conn.Notify 返回错误）。
+客户端放弃的调用（CallContext 的 ctx 结束）会取消对应的 HTTP 请求。
+*/
+
+const (
+	DefaultStreamPath = DefaultRPCPath + "/stream"
+
+	ContentTypeGob  = "application/x-gob"
+	ContentTypeJSON = "application/json"
+
+	metaHeaderPrefix = "Rpc-Meta-"
+	errorHeader      = "Rpc-Error"
+)
+
+//客户端读取响应体的最大字节数
+var MaxStreamResponseSize int64 = 64 << 20
+
+type streamHTTP struct {
+	server *Server
+	prefix string
+}
+
+//返回按 stream 处理调用的 http.Handler，prefix 之后的路径是方法名
+func (server *Server) StreamHandler(prefix string) http.Handler {
+	return &streamHTTP{server: server, prefix: strings.TrimSuffix(prefix, "/")}
+}
+
+//在 DefaultServeMux 上注册 stream 传输
+func (server *Server) HandleHTTP2(path string) {
+	http.Handle(strings.TrimSuffix(path, "/")+"/", server.StreamHandler(path))
+}
+
+//监听 addr，同时接受 HTTP/1 和 h2c 连接
+func (server *Server) ListenAndServeH2C(addr string) error {
+	hs := &http.Server{Addr: addr, Handler: server.StreamHandler(DefaultStreamPath), Protocols: h2cProtocols(true)}
+	return hs.ListenAndServe()
+}
+
+func h2cProtocols(http1 bool) *http.Protocols {
+	p := new(http.Protocols)
+	p.SetHTTP1(http1)
+	p.SetHTTP2(true)
+	p.SetUnencryptedHTTP2(true)
+	return p
+}
+
+func mediaType(ct string) string {
+	if ct == "" {
+		return ContentTypeGob
+	}
+	mt, _, err := mime.ParseMediaType(ct)
+	if err != nil {
+		return ""
+	}
+	return mt
+}
+
+func (h *streamHTTP) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "rpc: method must be POST", http.StatusMethodNotAllowed)
+		return
+	}
+	method := strings.TrimPrefix(req.URL.Path, h.prefix+"/")
+	if method == req.URL.Path || method == "" {
+		http.Error(w, "rpc: no service method in path", http.StatusNotFound)
+		return
+	}
+	ct := mediaType(req.Header.Get("Content-Type"))
+	if ct != ContentTypeGob && ct != ContentTypeJSON {
+		http.Error(w, "rpc: content type must be "+ContentTypeGob+" or "+ContentTypeJSON, http.StatusUnsupportedMediaType)
+		return
+	}
+
+	codec := &streamServerCodec{w: w, req: req, method: method, contentType: ct}
+	h.server.serveRequest(codec, HTTPPeer(req))
+	if !codec.wrote {
+		http.Error(w, "rpc: invalid request", http.StatusBadRequest)
+	}
+}
+
+var errStreamNotify = errors.New("rpc: stream transport does not support notifications")
+
+//一个 HTTP 请求对应的 ServerCodec，只处理一次调用
+type streamServerCodec struct {
+	w           http.ResponseWriter
+	req         *http.Request
+	method      string
+	contentType string
+	wrote       bool
+
+	maxHeader int
+	maxBody   int
+	tooLarge  bool //请求超过限制，用 413 回复
+}
+
+func (c *streamServerCodec) SetSizeLimits(maxHeader, maxBody int) {
+	c.maxHeader = maxHeader
+	c.maxBody = maxBody
+}
+
+func (c *streamServerCodec) ReadRequestHeader(r *Request) error {
+	r.ServiceMethod = c.method
+	r.Seq = 0
+	r.Meta = headerMeta(c.req.Header)
+	//请求头按方法名和元数据计算，其他 HTTP 头由 http.Server 的 MaxHeaderBytes 限制
+	if c.maxHeader > 0 {
+		n := len(r.ServiceMethod)
+		for k, v := range r.Meta {
+			n += len(k) + len(v)
+		}
+		if n > c.maxHeader {
+			c.tooLarge = true
+			return &FrameTooLargeError{Part: "header", Limit: c.maxHeader}
+		}
+	}
+	return nil
+}
+
+func (c *streamServerCodec) ReadRequestBody(body interface{}) error {
+	if body == nil {
+		return nil
+	}
+	rd := c.req.Body
+	if c.maxBody > 0 {
+		rd = http.MaxBytesReader(c.w, rd, int64(c.maxBody))
+	}
+	var err error
+	if c.contentType == ContentTypeJSON {
+		err = json.NewDecoder(rd).Decode(body)
+		if err == io.EOF {
+			//没有参数
+			err = nil
+		}
+	} else {
+		err = gob.NewDecoder(rd).Decode(body)
+	}
+	var mbe *http.MaxBytesError
+	if errors.As(err, &mbe) {
+		c.tooLarge = true
+		return &FrameTooLargeError{Part: "body", Limit: c.maxBody}
+	}
+	return err
+}
+
+func (c *streamServerCodec) WriteResponse(r *Response, body interface{}) error {
+	//推送没有对应的 HTTP 请求，不能当作这次调用的响应写出
+	if IsNotifySeq(r.Seq) {
+		return errStreamNotify
+	}
+	c.wrote = true
+	h := c.w.Header()
+	setHeaderMeta(h, r.Meta)
+	if r.Error != "" {
+		h.Set(errorHeader, r.Error)
+		h.Set("Content-Type", "text/plain; charset=utf-8")
+		status := http.StatusInternalServerError
+		if c.tooLarge {
+			status = http.StatusRequestEntityTooLarge
+		}
+		c.w.WriteHeader(status)
+		_, err := io.WriteString(c.w, r.Error+"\n")
+		return err
+	}
+	h.Set("Content-Type", c.contentType)
+	c.w.WriteHeader(http.StatusOK)
+	if c.contentType == ContentTypeJSON {
+		return json.NewEncoder(c.w).Encode(body)
+	}
+	return gob.NewEncoder(c.w).Encode(body)
+}
+
+func (c *streamServerCodec) Close() error { return nil }
+
+func headerMeta(h http.Header) Metadata {
+	var md Metadata
+	for k, v := range h {
+		if len(v) == 0 || !strings.HasPrefix(k, metaHeaderPrefix) {
+			continue
+		}
+		if md == nil {
+			md = make(Metadata)
+		}
+		md[strings.ToLower(k[len(metaHeaderPrefix):])] = v[0]
+	}
+	return md
+}
+
+func setHeaderMeta(h http.Header, md Metadata) {
+	for k, v := range md {
+		h.Set(metaHeaderPrefix+k, v)
+	}
+}
+
+/*
+客户端：每次调用发一个 HTTP 请求，结果按完成顺序交给 Client 的读循环。
+单个调用的传输错误只影响这一次调用，不会关闭 Client。
+*/
+type streamClientCodec struct {
+	url         string
+	hc          *http.Client
+	contentType string
+
+	results chan *streamResult
+	ctx     context.Context
+	cancel  context.CancelFunc
+	cur     *streamResult
+
+	mu       sync.Mutex
+	requests map[uint64]context.CancelFunc //进行中的请求，按 seq
+}
+
+type streamResult struct {
+	seq     uint64
+	method  string
+	meta    Metadata
+	err     string //服务端返回的错误
+	callErr error  //传输错误
+	body    []byte
+}
+
+//baseURL 是服务端的 stream 路径，例如 http://host/_goRPC_/stream；
+//hc 为 nil 时使用 h2c 的客户端，contentType 为空时使用 gob
+func NewStreamClientCodec(baseURL string, hc *http.Client, contentType string) ClientCodec {
+	if hc == nil {
+		hc = &http.Client{Transport: &http.Transport{Protocols: h2cProtocols(false)}}
+	}
+	if contentType == "" {
+		contentType = ContentTypeGob
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &streamClientCodec{
+		url:         strings.TrimSuffix(baseURL, "/"),
+		hc:          hc,
+		contentType: contentType,
+		results:     make(chan *streamResult),
+		ctx:         ctx,
+		cancel:      cancel,
+		requests:    make(map[uint64]context.CancelFunc),
+	}
+}
+
+//通过 h2c 连接 stream 传输，使用 gob 编码
+func DialHTTP2(baseURL string) *Client {
+	return NewClientWithCodec(NewStreamClientCodec(baseURL, nil, ""))
+}
+
+func (c *streamClientCodec) WriteRequest(r *Request, body interface{}) error {
+	var buf bytes.Buffer
+	var err error
+	if c.contentType == ContentTypeJSON {
+		err = json.NewEncoder(&buf).Encode(body)
+	} else {
+		err = gob.NewEncoder(&buf).Encode(body)
+	}
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithCancel(c.ctx)
+	req, err := http.NewRequestWithContext(ctx, "POST", c.url+"/"+r.ServiceMethod, &buf)
+	if err != nil {
+		cancel()
+		return err
+	}
+	c.mu.Lock()
+	c.requests[r.Seq] = cancel
+	c.mu.Unlock()
+	req.Header.Set("Content-Type", c.contentType)
+	setHeaderMeta(req.Header, r.Meta)
+
+	res := &streamResult{seq: r.Seq, method: r.ServiceMethod}
+	go c.do(req, res)
+	return nil
+}
+
+func (c *streamClientCodec) do(req *http.Request, res *streamResult) {
+	resp, err := c.hc.Do(req)
+	if err == nil {
+		res.body, err = io.ReadAll(io.LimitReader(resp.Body, MaxStreamResponseSize+1))
+		if err == nil && int64(len(res.body)) > MaxStreamResponseSize {
+			err = errors.New("rpc: response body exceeds " + strconv.FormatInt(MaxStreamResponseSize, 10) + " bytes")
+		}
+		resp.Body.Close()
+	}
+	c.cancelRequest(res.seq)
+	switch {
+	case err != nil:
+		res.callErr = err
+	case resp.Header.Get(errorHeader) != "":
+		res.err = resp.Header.Get(errorHeader)
+		res.body = nil
+	case resp.StatusCode != http.StatusOK:
+		res.callErr = errors.New("rpc: unexpected HTTP response: " + resp.Status + ": " + strings.TrimSpace(string(res.body)))
+		res.body = nil
+	}
+	if resp != nil {
+		res.meta = headerMeta(resp.Header)
+	}
+	select {
+	case c.results <- res:
+	case <-c.ctx.Done():
+	}
+}
+
+//中止请求，调用被 Client 放弃或已经完成时调用
+func (c *streamClientCodec) cancelRequest(seq uint64) {
+	c.mu.Lock()
+	cancel := c.requests[seq]
+	delete(c.requests, seq)
+	c.mu.Unlock()
+	if cancel != nil {
+		cancel()
+	}
+}
+
+func (c *streamClientCodec) ReadResponseHeader(r *Response) error {
+	select {
+	case res := <-c.results:
+		c.cur = res
+		r.Seq = res.seq
+		r.ServiceMethod = res.method
+		r.Meta = res.meta
+		r.Error = res.err
+		r.callErr = res.callErr
+		return nil
+	case <-c.ctx.Done():
+		return io.EOF
+	}
+}
+
+func (c *streamClientCodec) ReadResponseBody(body interface{}) error {
+	res := c.cur
+	c.cur = nil
+	if body == nil || res == nil {
+		return nil
+	}
+	if res.body == nil {
+		return errors.New("rpc: empty response body")
+	}
+	if c.contentType == ContentTypeJSON {
+		return json.Unmarshal(res.body, body)
+	}
+	return gob.NewDecoder(bytes.NewReader(res.body)).Decode(body)
+}
+
+func (c *streamClientCodec) Close() error {
+	c.cancel()
+	return nil
+}
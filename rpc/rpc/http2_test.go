@@ -0,0 +1,198 @@
+package rpc
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestStreamTransport(t *testing.T) {
+	server := NewServer()
+	server.Register(new(Echo))
+
+	var h2 int32
+	handler := server.StreamHandler(DefaultStreamPath)
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor == 2 {
+			atomic.AddInt32(&h2, 1)
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	ts.Config.Protocols = h2cProtocols(true)
+	ts.Start()
+	defer ts.Close()
+
+	for _, ct := range []string{ContentTypeGob, ContentTypeJSON} {
+		client := NewClientWithCodec(NewStreamClientCodec(ts.URL+DefaultStreamPath, nil, ct))
+
+		var reply string
+		if err := client.Call("Echo.Say", "hi", &reply); err != nil || reply != "hi" {
+			t.Errorf("%s: Echo.Say = %q, %v", ct, reply, err)
+		}
+
+		//并发调用分别走独立的 stream
+		calls := make([]*Call, 10)
+		for i := range calls {
+			calls[i] = client.Go("Echo.Say", "x", new(string), nil)
+		}
+		for _, call := range calls {
+			if <-call.Done; call.Error != nil {
+				t.Errorf("%s: concurrent call: %v", ct, call.Error)
+			}
+		}
+
+		//服务端错误只影响这一次调用
+		if _, ok := client.Call("Echo.Nope", "hi", &reply).(ServerError); !ok {
+			t.Errorf("%s: unknown method should fail with a ServerError", ct)
+		}
+
+		//元数据
+		server.SetAuthorizer(func(ctx context.Context, serviceMethod string) error {
+			if MetadataFromContext(ctx)["token"] != "secret" {
+				return ErrPermissionDenied
+			}
+			return nil
+		})
+		if err := client.Call("Echo.Say", "hi", &reply); err == nil {
+			t.Errorf("%s: call without token should be denied", ct)
+		}
+		ctx := WithMetadata(context.Background(), Metadata{"token": "secret"})
+		if err := client.CallContext(ctx, "Echo.Say", "hi", &reply); err != nil {
+			t.Errorf("%s: call with token: %v", ct, err)
+		}
+		server.SetAuthorizer(nil)
+
+		client.Close()
+		if err := client.Call("Echo.Say", "hi", &reply); err != ErrShutdown {
+			t.Errorf("%s: call after Close = %v, want ErrShutdown", ct, err)
+		}
+	}
+	if atomic.LoadInt32(&h2) == 0 {
+		t.Error("requests were not served over HTTP/2")
+	}
+
+	resp, err := http.Get(ts.URL + DefaultStreamPath + "/Echo.Say")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("GET status = %d", resp.StatusCode)
+	}
+}
+
+//在 stream 传输上推送，返回 Notify 的错误
+type StreamPusher int
+
+func (p *StreamPusher) Push(ctx context.Context, args int, reply *string) error {
+	c, _ := ConnFromContext(ctx)
+	if err := c.Notify("StreamPusher.Event", &args); err != nil {
+		*reply = err.Error()
+	}
+	return nil
+}
+
+func TestStreamLimitsAndErrors(t *testing.T) {
+	server := NewServer()
+	server.Register(new(Echo))
+	server.Register(new(StreamPusher))
+	server.SetMaxHeaderSize(64)
+	server.SetMaxBodySize(64)
+	ts := httptest.NewServer(server.StreamHandler(DefaultStreamPath))
+	defer ts.Close()
+	client := NewClientWithCodec(NewStreamClientCodec(ts.URL+DefaultStreamPath, http.DefaultClient, ContentTypeJSON))
+	defer client.Close()
+
+	var reply string
+	big := strings.Repeat("x", 100)
+	if err := client.Call("Echo.Say", big, &reply); err == nil || !strings.Contains(err.Error(), "body exceeds 64 bytes") {
+		t.Errorf("oversized body: %v", err)
+	}
+	ctx := WithMetadata(context.Background(), Metadata{"token": big})
+	if err := client.CallContext(ctx, "Echo.Say", "hi", &reply); err == nil || !strings.Contains(err.Error(), "header exceeds 64 bytes") {
+		t.Errorf("oversized metadata: %v", err)
+	}
+
+	if err := client.Call("StreamPusher.Push", 1, &reply); err != nil || reply != errStreamNotify.Error() {
+		t.Errorf("Notify on stream = %q, %v", reply, err)
+	}
+
+	//传输错误不是 ServerError，也不影响之后的调用
+	bad := NewClientWithCodec(NewStreamClientCodec(ts.URL+"/nowhere", http.DefaultClient, ContentTypeJSON))
+	defer bad.Close()
+	for i := 0; i < 2; i++ {
+		err := bad.Call("Echo.Say", "hi", &reply)
+		if _, ok := err.(ServerError); ok || err == nil {
+			t.Errorf("transport error = %#v, want a non-ServerError", err)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", DefaultStreamPath+"/Echo.Say", strings.NewReader(`"`+big+`"`))
+	req.Header.Set("Content-Type", ContentTypeJSON)
+	server.StreamHandler(DefaultStreamPath).ServeHTTP(w, req)
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("oversized body status = %d", w.Code)
+	}
+}
+
+type StreamBlock chan struct{}
+
+func (b StreamBlock) Wait(ctx context.Context, args int, reply *string) error {
+	<-b
+	if p, ok := PeerFromContext(ctx); ok {
+		*reply = p.Network
+	}
+	return nil
+}
+
+//放弃的调用取消 HTTP 请求；对端的网络类型来自监听地址；响应体有大小限制
+func TestStreamCancelAndPeer(t *testing.T) {
+	server := NewServer()
+	block := make(StreamBlock)
+	server.RegisterName(block, "Block")
+	server.Register(new(Echo))
+	canceled := make(chan struct{}, 1)
+	handler := server.StreamHandler(DefaultStreamPath)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		go func() {
+			<-r.Context().Done()
+			select {
+			case canceled <- struct{}{}:
+			default:
+			}
+		}()
+		handler.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+	client := NewClientWithCodec(NewStreamClientCodec(ts.URL+DefaultStreamPath, http.DefaultClient, ""))
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := client.CallContext(ctx, "Block.Wait", 0, new(string)); err != context.DeadlineExceeded {
+		t.Fatalf("CallContext = %v", err)
+	}
+	select {
+	case <-canceled:
+	case <-time.After(2 * time.Second):
+		t.Fatal("HTTP request not canceled")
+	}
+	close(block)
+
+	var network string
+	if err := client.Call("Block.Wait", 0, &network); err != nil || network != "tcp" {
+		t.Errorf("peer network = %q, %v", network, err)
+	}
+
+	defer func(n int64) { MaxStreamResponseSize = n }(MaxStreamResponseSize)
+	MaxStreamResponseSize = 10
+	err := client.Call("Echo.Say", strings.Repeat("x", 100), new(string))
+	if _, ok := err.(ServerError); ok || err == nil || !strings.Contains(err.Error(), "exceeds 10 bytes") {
+		t.Errorf("oversized response = %v", err)
+	}
+}
@@ -0,0 +1,201 @@
+package rpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"errors"
+	"log"
+	"net"
+	"reflect"
+	"strconv"
+	"sync"
+	"time"
+)
+
+/*
+幂等键：
+请求元数据中带有 IdempotencyKey 时，同一个方法、同一个键的第一次调用正常执行，
+方法按解析后的名字区分（含版本），别名和大小写不同的写法是同一个方法；
+结果用 gob 编码后保存；之后的重复请求不再执行方法，而是得到保存的结果。
+原调用还在执行时，重复请求等待它完成。
+结果保存在 IdempotencyStore 中，默认使用进程内的 MemoryIdempotencyStore，超过 TTL 后删除。
+键按调用方隔离，不同调用方用同一个键互不影响：默认按对端区分（Unix 套接字按 uid，其他按对端 IP），
+有认证身份时用 SetIdempotencyScope 按身份区分。隔离范围为空（例如没有对端信息）时拒绝带幂等键的请求。
+故障注入的错误不保存，重试时重新执行。
+*/
+
+const IdempotencyKey = "idempotency-key"
+
+var errNoIdempotencyScope = errors.New("rpc: idempotency key needs a caller scope, the request has no peer")
+
+//保存的调用结果
+type IdempotentReply struct {
+	Reply []byte //gob 编码的结果
+	Error string
+}
+
+type IdempotencyStore interface {
+	//key 第一次出现时返回 nil，调用方执行后必须调用 Finish；
+	//否则等待原调用完成并返回它的结果，等待期间 ctx 结束返回 ctx 的错误
+	Begin(ctx context.Context, key string) (*IdempotentReply, error)
+	//保存结果，r 为 nil 表示调用没有完成，之后的请求重新执行
+	Finish(key string, r *IdempotentReply)
+}
+
+//开启幂等键支持，ttl 为结果保存的时间
+func (server *Server) SetIdempotency(ttl time.Duration) {
+	server.SetIdempotencyStore(NewMemoryIdempotencyStore(ttl))
+}
+
+func (server *Server) SetIdempotencyStore(store IdempotencyStore) {
+	server.idempotency = store
+}
+
+//设置幂等键的隔离范围，通常返回认证后的身份；ctx 同处理函数的 ctx，nil 时按对端区分
+func (server *Server) SetIdempotencyScope(scope func(ctx context.Context) string) {
+	server.idempotencyScope = scope
+}
+
+//默认的隔离范围：Unix 套接字的 uid，其他连接的对端 IP（重连后端口会变），没有对端信息时为空
+func peerScope(ctx context.Context) string {
+	p, ok := PeerFromContext(ctx)
+	if !ok {
+		return ""
+	}
+	if p.Cred != nil {
+		return "uid:" + strconv.FormatUint(uint64(p.Cred.UID), 10)
+	}
+	if host, _, err := net.SplitHostPort(p.Addr); err == nil {
+		return p.Network + ":" + host
+	}
+	return p.Network + ":" + p.Addr
+}
+
+/*
+有幂等键时登记调用，serviceMethod 是解析后的名字。
+重复的请求返回保存的结果，结果已解码到 replyv；
+第一次的请求返回 finish，调用完成后传入结果，调用没有执行时传入 ok 为 false。
+*/
+func (server *Server) beginIdempotent(ctx context.Context, req *Request, serviceMethod string, replyv reflect.Value) (dup *IdempotentReply, finish func(errmsg string, ok bool)) {
+	store := server.idempotency
+	if store == nil || req.Meta[IdempotencyKey] == "" {
+		return nil, nil
+	}
+	scope := server.idempotencyScope
+	if scope == nil {
+		scope = peerScope
+	}
+	s := scope(ctx)
+	if s == "" {
+		//所有调用方共用一个空范围时，一个调用方可以拿到另一个调用方保存的结果
+		return &IdempotentReply{Error: errNoIdempotencyScope.Error()}, nil
+	}
+	key := s + "\x00" + serviceMethod + "\x00" + req.Meta[IdempotencyKey]
+
+	dup, err := store.Begin(ctx, key)
+	if err != nil {
+		return &IdempotentReply{Error: err.Error()}, nil
+	}
+	if dup != nil {
+		if dup.Error == "" {
+			if err := gob.NewDecoder(bytes.NewReader(dup.Reply)).DecodeValue(replyv); err != nil {
+				return &IdempotentReply{Error: "rpc: decoding stored reply: " + err.Error()}, nil
+			}
+		}
+		return dup, nil
+	}
+
+	return nil, func(errmsg string, ok bool) {
+		if !ok {
+			store.Finish(key, nil)
+			return
+		}
+		r := &IdempotentReply{Error: errmsg}
+		if errmsg == "" {
+			var buf bytes.Buffer
+			if err := gob.NewEncoder(&buf).EncodeValue(replyv); err != nil {
+				log.Println("rpc: cannot store reply of", serviceMethod, ":", err)
+				store.Finish(key, nil)
+				return
+			}
+			r.Reply = buf.Bytes()
+		}
+		store.Finish(key, r)
+	}
+}
+
+//进程内的 IdempotencyStore
+type MemoryIdempotencyStore struct {
+	ttl time.Duration
+
+	mu        sync.Mutex
+	entries   map[string]*idempotentEntry
+	lastSweep time.Time
+}
+
+type idempotentEntry struct {
+	done    chan struct{} //原调用完成时关闭
+	reply   *IdempotentReply
+	expires time.Time
+}
+
+func NewMemoryIdempotencyStore(ttl time.Duration) *MemoryIdempotencyStore {
+	return &MemoryIdempotencyStore{ttl: ttl, entries: make(map[string]*idempotentEntry), lastSweep: time.Now()}
+}
+
+func (s *MemoryIdempotencyStore) Begin(ctx context.Context, key string) (*IdempotentReply, error) {
+	for {
+		s.mu.Lock()
+		now := time.Now()
+		if now.Sub(s.lastSweep) > s.ttl {
+			s.sweep(now)
+		}
+		e, ok := s.entries[key]
+		if ok && e.reply != nil && now.After(e.expires) {
+			ok = false
+		}
+		if !ok {
+			s.entries[key] = &idempotentEntry{done: make(chan struct{})}
+			s.mu.Unlock()
+			return nil, nil
+		}
+		s.mu.Unlock()
+
+		select {
+		case <-e.done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		if e.reply != nil {
+			return e.reply, nil
+		}
+		//原调用被放弃，重新竞争执行
+	}
+}
+
+func (s *MemoryIdempotencyStore) Finish(key string, r *IdempotentReply) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.entries[key]
+	if !ok || e.reply != nil {
+		return
+	}
+	if r == nil {
+		delete(s.entries, key)
+	} else {
+		e.reply = r
+		e.expires = time.Now().Add(s.ttl)
+	}
+	close(e.done)
+}
+
+//删除过期的结果，持有 s.mu
+func (s *MemoryIdempotencyStore) sweep(now time.Time) {
+	for key, e := range s.entries {
+		if e.reply != nil && now.After(e.expires) {
+			delete(s.entries, key)
+		}
+	}
+	s.lastSweep = now
+}
@@ -0,0 +1,249 @@
+package rpc
+
+import (
+	"context"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type Payments struct {
+	charges int32
+	release chan struct{}
+}
+
+type Charge struct {
+	Amount int
+}
+
+type Receipt struct {
+	ID     int32
+	Amount int
+}
+
+func (p *Payments) Charge(ctx context.Context, args *Charge, reply *Receipt) error {
+	id := atomic.AddInt32(&p.charges, 1)
+	if MetadataFromContext(ctx)["slow"] != "" {
+		<-p.release
+	}
+	reply.ID = id
+	reply.Amount = args.Amount
+	return nil
+}
+
+func TestIdempotencyKey(t *testing.T) {
+	server := NewServer()
+	payments := &Payments{release: make(chan struct{})}
+	server.Register(payments)
+	server.SetIdempotency(time.Minute)
+
+	cli, srv := net.Pipe()
+	go server.ServeConn(srv)
+	client := NewClient(cli)
+	defer client.Close()
+
+	charge := func(key string, md Metadata) *Receipt {
+		if md == nil {
+			md = Metadata{}
+		}
+		if key != "" {
+			md[IdempotencyKey] = key
+		}
+		var r Receipt
+		if err := client.CallContext(WithMetadata(context.Background(), md), "Payments.Charge", &Charge{Amount: 10}, &r); err != nil {
+			t.Fatal(err)
+		}
+		return &r
+	}
+
+	first := charge("k1", nil)
+	if again := charge("k1", nil); *again != *first {
+		t.Errorf("duplicate got %+v, want stored %+v", again, first)
+	}
+	if other := charge("k2", nil); other.ID == first.ID {
+		t.Error("different key reused reply")
+	}
+	if none := charge("", nil); none.ID == first.ID {
+		t.Error("call without key reused reply")
+	}
+	if n := atomic.LoadInt32(&payments.charges); n != 3 {
+		t.Errorf("Charge ran %d times, want 3", n)
+	}
+
+	//原调用还在执行时，重复请求等待同一个结果
+	var wg sync.WaitGroup
+	receipts := make([]*Receipt, 3)
+	for i := range receipts {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			receipts[i] = charge("k3", Metadata{"slow": "1"})
+		}(i)
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(payments.release)
+	wg.Wait()
+	for _, r := range receipts[1:] {
+		if *r != *receipts[0] {
+			t.Errorf("in-flight duplicates got %+v and %+v", receipts[0], r)
+		}
+	}
+	if n := atomic.LoadInt32(&payments.charges); n != 4 {
+		t.Errorf("Charge ran %d times, want 4", n)
+	}
+}
+
+func TestMemoryIdempotencyStoreTTL(t *testing.T) {
+	store := NewMemoryIdempotencyStore(10 * time.Millisecond)
+	ctx := context.Background()
+	if r, _ := store.Begin(ctx, "k"); r != nil {
+		t.Fatal("first Begin returned a reply")
+	}
+	store.Finish("k", &IdempotentReply{Error: "boom"})
+	if r, _ := store.Begin(ctx, "k"); r == nil || r.Error != "boom" {
+		t.Fatalf("Begin = %+v, want stored reply", r)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	if r, _ := store.Begin(ctx, "k"); r != nil {
+		t.Fatal("expired reply returned")
+	}
+
+	//放弃后下一个请求重新执行
+	store.Finish("k", nil)
+	if r, _ := store.Begin(ctx, "k"); r != nil {
+		t.Fatal("abandoned key returned a reply")
+	}
+}
+
+//不同调用方用同一个键互不影响
+func TestIdempotencyScope(t *testing.T) {
+	server := NewServer()
+	payments := &Payments{}
+	server.Register(payments)
+	server.SetIdempotency(time.Minute)
+
+	dial := func(addr string) *Client {
+		cli, srv := net.Pipe()
+		go server.ServeCodecPeer(NewGobServerCodec(srv), &Peer{Network: "tcp", Addr: addr})
+		return NewClient(cli)
+	}
+	charge := func(client *Client, md Metadata) int32 {
+		md[IdempotencyKey] = "k1"
+		var r Receipt
+		if err := client.CallContext(WithMetadata(context.Background(), md), "Payments.Charge", &Charge{Amount: 10}, &r); err != nil {
+			t.Fatal(err)
+		}
+		return r.ID
+	}
+
+	a1, a2, b := dial("10.0.0.1:1000"), dial("10.0.0.1:2000"), dial("10.0.0.2:1000")
+	defer a1.Close()
+	defer a2.Close()
+	defer b.Close()
+	first := charge(a1, Metadata{})
+	//同一台机器重连后端口不同，仍然是同一个调用方
+	if id := charge(a2, Metadata{}); id != first {
+		t.Errorf("reconnected caller got %d, want stored %d", id, first)
+	}
+	if id := charge(b, Metadata{}); id == first {
+		t.Error("another peer got the stored reply")
+	}
+
+	//按认证身份区分
+	server.SetIdempotencyScope(func(ctx context.Context) string { return MetadataFromContext(ctx)["user"] })
+	alice := charge(a1, Metadata{"user": "alice"})
+	if id := charge(b, Metadata{"user": "alice"}); id != alice {
+		t.Errorf("same identity got %d, want stored %d", id, alice)
+	}
+	if id := charge(a1, Metadata{"user": "bob"}); id == alice {
+		t.Error("another identity got the stored reply")
+	}
+}
+
+//故障注入的错误不作为结果保存
+func TestIdempotencyIgnoresFaults(t *testing.T) {
+	server := NewServer()
+	payments := &Payments{}
+	server.Register(payments)
+	server.SetIdempotency(time.Minute)
+	server.SetFaults([]Fault{{Pattern: "Payments.*", Percent: 100, Error: "injected"}})
+
+	cli, srv := net.Pipe()
+	go server.ServeConn(srv)
+	client := NewClient(cli)
+	defer client.Close()
+
+	ctx := WithMetadata(context.Background(), Metadata{IdempotencyKey: "k1"})
+	var r Receipt
+	if err := client.CallContext(ctx, "Payments.Charge", &Charge{Amount: 10}, &r); err == nil || err.Error() != "injected" {
+		t.Fatalf("err = %v, want injected", err)
+	}
+	server.SetFaults(nil)
+	if err := client.CallContext(ctx, "Payments.Charge", &Charge{Amount: 10}, &r); err != nil || r.ID != 1 {
+		t.Errorf("retry = %+v, %v, want executed", r, err)
+	}
+}
+
+//别名、大小写和版本按解析后的方法区分
+func TestIdempotencyResolvedName(t *testing.T) {
+	server := NewServer()
+	v1, v2 := &Payments{}, &Payments{}
+	server.RegisterName(v1, "Pay@v1")
+	server.RegisterName(v2, "Pay@v2")
+	server.SetCaseInsensitive(true)
+	if err := server.RegisterAlias("Bill.Charge", "Pay@v1.Charge"); err != nil {
+		t.Fatal(err)
+	}
+	server.SetIdempotency(time.Minute)
+
+	cli, srv := net.Pipe()
+	go server.ServeConn(srv)
+	client := NewClient(cli)
+	defer client.Close()
+
+	charge := func(serviceMethod, version string) int32 {
+		md := Metadata{IdempotencyKey: "k1"}
+		if version != "" {
+			md[VersionKey] = version
+		}
+		var r Receipt
+		if err := client.CallContext(WithMetadata(context.Background(), md), serviceMethod, &Charge{Amount: 10}, &r); err != nil {
+			t.Fatalf("%s: %v", serviceMethod, err)
+		}
+		return r.ID
+	}
+
+	charge("Pay.Charge", "v1")
+	charge("Pay.Charge", "v2")
+	for _, name := range []string{"Pay@v1.Charge", "pay@v1.charge", "Bill.Charge"} {
+		charge(name, "")
+	}
+	if n1, n2 := atomic.LoadInt32(&v1.charges), atomic.LoadInt32(&v2.charges); n1 != 1 || n2 != 1 {
+		t.Errorf("v1 ran %d times, v2 ran %d times, want 1 and 1", n1, n2)
+	}
+}
+
+//没有对端信息时不能区分调用方，拒绝带幂等键的请求
+func TestIdempotencyNoScope(t *testing.T) {
+	server := NewServer()
+	payments := &Payments{}
+	server.Register(payments)
+	server.SetIdempotency(time.Minute)
+
+	cli, srv := net.Pipe()
+	go server.ServeCodec(NewGobServerCodec(srv))
+	client := NewClient(cli)
+	defer client.Close()
+
+	ctx := WithMetadata(context.Background(), Metadata{IdempotencyKey: "k1"})
+	var r Receipt
+	if err := client.CallContext(ctx, "Payments.Charge", &Charge{Amount: 10}, &r); err == nil || err.Error() != errNoIdempotencyScope.Error() {
+		t.Fatalf("err = %v, want %v", err, errNoIdempotencyScope)
+	}
+	if n := atomic.LoadInt32(&payments.charges); n != 0 {
+		t.Errorf("Charge ran %d times", n)
+	}
+}
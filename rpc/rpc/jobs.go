@@ -0,0 +1,325 @@
+package rpc
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"sync"
+	"time"
+)
+
+/*
+异步调用：
+请求元数据中带有 AsyncKey 时，服务端不等方法执行完，立即回复零值结果，
+任务 ID 放在响应元数据的 JobIDKey 中；方法在后台通过 service.call 执行，结果写入 JobStore。
+之后通过 "Jobs" 服务查询：
+  - Jobs.Status  任务状态，不含结果
+  - Jobs.Result  任务及结果，结果按 JSON 编码；还在执行时 State 为 JobRunning，没有结果
+  - Jobs.Cancel  取消任务，方法的 ctx 被取消（方法需要带 context.Context 参数才能感知）
+任务的 ctx 带有发起调用的连接的值（PeerFromContext 等）和请求元数据，但不随连接关闭而取消。
+客户端用 StartJob 和 JobResult。
+*/
+
+const (
+	AsyncKey = "async"
+	JobIDKey = "job-id"
+)
+
+type JobState int
+
+const (
+	JobRunning JobState = iota
+	JobDone
+	JobFailed
+	JobCanceled
+)
+
+func (s JobState) String() string {
+	switch s {
+	case JobRunning:
+		return "running"
+	case JobDone:
+		return "done"
+	case JobFailed:
+		return "failed"
+	case JobCanceled:
+		return "canceled"
+	}
+	return "unknown"
+}
+
+type Job struct {
+	ID            string
+	ServiceMethod string
+	State         JobState
+	Created       time.Time
+	Finished      time.Time
+	Error         string
+	Reply         json.RawMessage //JSON 编码的结果
+}
+
+var (
+	ErrJobNotFound   = errors.New("rpc: job not found")
+	ErrJobRunning    = errors.New("rpc: job is still running")
+	errAsyncDisabled = errors.New("rpc: async calls are not enabled")
+	errJobDropped    = errors.New("rpc: job dropped by fault injection")
+)
+
+//任务状态的存储，Put 用于创建和更新
+type JobStore interface {
+	Put(job *Job) error
+	Get(id string) (*Job, error)
+}
+
+//开启异步调用并注册 "Jobs" 服务，store 为 nil 时使用 NewMemoryJobStore(time.Hour)
+func (server *Server) RegisterJobs(store JobStore) error {
+	if store == nil {
+		store = NewMemoryJobStore(time.Hour)
+	}
+	jobs := &Jobs{store: store, running: make(map[string]*runningJob)}
+	if err := server.RegisterName(jobs, "Jobs"); err != nil {
+		return err
+	}
+	server.jobs = jobs
+	return nil
+}
+
+type Jobs struct {
+	store JobStore
+
+	mu      sync.Mutex
+	running map[string]*runningJob
+}
+
+type runningJob struct {
+	cancel   context.CancelFunc
+	canceled bool //由 Jobs.mu 保护
+}
+
+func (j *Jobs) Status(id string, reply *Job) error {
+	job, err := j.store.Get(id)
+	if err != nil {
+		return err
+	}
+	*reply = *job
+	reply.Reply = nil
+	return nil
+}
+
+func (j *Jobs) Result(id string, reply *Job) error {
+	job, err := j.store.Get(id)
+	if err != nil {
+		return err
+	}
+	*reply = *job
+	return nil
+}
+
+//取消还在执行的任务，任务已结束时 reply 为 false
+func (j *Jobs) Cancel(id string, reply *bool) error {
+	j.mu.Lock()
+	r, ok := j.running[id]
+	if ok {
+		r.canceled = true
+	}
+	j.mu.Unlock()
+	if !ok {
+		if _, err := j.store.Get(id); err != nil {
+			return err
+		}
+		*reply = false
+		return nil
+	}
+	r.cancel()
+	*reply = true
+	return nil
+}
+
+func newJobID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+//回复任务 ID，然后在后台执行调用；connCtx 是连接的 ctx，任务沿用其中的值（对端等），但不随连接关闭而取消
+func (server *Server) startJob(connCtx context.Context, svc *service, mtype *methodType, req *Request, argv, replyv reflect.Value, codec ServerCodec, sending *sync.Mutex) {
+	j := server.jobs
+	if j == nil {
+		server.sendResponse(sending, req, invalidRequest, codec, errAsyncDisabled.Error())
+		server.freeRequest(req)
+		return
+	}
+
+	job := &Job{ID: newJobID(), ServiceMethod: req.ServiceMethod, State: JobRunning, Created: time.Now()}
+	if err := j.store.Put(job); err != nil {
+		server.sendResponse(sending, req, invalidRequest, codec, err.Error())
+		server.freeRequest(req)
+		return
+	}
+	ctx, cancel := context.WithCancel(context.WithoutCancel(connCtx))
+	j.mu.Lock()
+	j.running[job.ID] = &runningJob{cancel: cancel}
+	j.mu.Unlock()
+
+	//后台调用使用自己的 Request，原请求回复后释放
+	meta := make(Metadata, len(req.Meta))
+	for k, v := range req.Meta {
+		if k != AsyncKey {
+			meta[k] = v
+		}
+	}
+	jobReq := &Request{ServiceMethod: req.ServiceMethod, Meta: meta}
+
+	req.replyMeta = Metadata{JobIDKey: job.ID}
+	server.sendResponse(sending, req, reflect.New(mtype.ReplyType.Elem()).Interface(), codec, "")
+	server.freeRequest(req)
+
+	go svc.call(server, new(sync.Mutex), nil, mtype, jobReq, argv, replyv, &jobCodec{jobs: j, job: job, cancel: cancel}, ctx)
+}
+
+//接收后台调用结果的编解码器
+type jobCodec struct {
+	jobs   *Jobs
+	job    *Job
+	cancel context.CancelFunc
+}
+
+func (c *jobCodec) ReadRequestHeader(*Request) error { return errors.New("rpc: job codec cannot read") }
+func (c *jobCodec) ReadRequestBody(interface{}) error {
+	return errors.New("rpc: job codec cannot read")
+}
+
+//只在故障注入 Drop 时调用，没有结果可写，任务记为失败
+func (c *jobCodec) Close() error {
+	return c.WriteResponse(&Response{Error: errJobDropped.Error()}, nil)
+}
+
+func (c *jobCodec) WriteResponse(r *Response, body interface{}) error {
+	j := c.jobs
+	j.mu.Lock()
+	running, ok := j.running[c.job.ID]
+	delete(j.running, c.job.ID)
+	j.mu.Unlock()
+	if !ok {
+		//已经结束
+		return nil
+	}
+	canceled := running.canceled
+	c.cancel()
+
+	job := *c.job
+	job.Finished = time.Now()
+	switch {
+	case canceled:
+		job.State = JobCanceled
+		job.Error = context.Canceled.Error()
+	case r.Error != "":
+		job.State = JobFailed
+		job.Error = r.Error
+	default:
+		reply, err := json.Marshal(body)
+		if err != nil {
+			job.State = JobFailed
+			job.Error = "rpc: encoding job result: " + err.Error()
+			break
+		}
+		job.State = JobDone
+		job.Reply = reply
+	}
+	return j.store.Put(&job)
+}
+
+//开始异步调用，返回任务 ID
+func (client *Client) StartJob(ctx context.Context, serviceMethod string, args interface{}) (string, error) {
+	md := Metadata{AsyncKey: "1"}
+	for k, v := range outgoingMetadata(ctx) {
+		md[k] = v
+	}
+	call := newCall(serviceMethod, args, nil, make(chan *Call, 1))
+	call.Meta = md
+	client.send(call)
+	select {
+	case call = <-call.Done:
+	case <-ctx.Done():
+		client.abandon(call)
+		return "", ctx.Err()
+	}
+	if call.Error != nil {
+		return "", call.Error
+	}
+	id := call.ReplyMeta[JobIDKey]
+	if id == "" {
+		return "", errors.New("rpc: server did not return a job id")
+	}
+	return id, nil
+}
+
+//任务完成时把结果解码到 reply；任务还在执行时返回 ErrJobRunning，失败或取消时返回任务的错误
+func (client *Client) JobResult(ctx context.Context, id string, reply interface{}) error {
+	var job Job
+	if err := client.CallContext(ctx, "Jobs.Result", id, &job); err != nil {
+		return err
+	}
+	switch job.State {
+	case JobDone:
+		return json.Unmarshal(job.Reply, reply)
+	case JobRunning:
+		return ErrJobRunning
+	}
+	return ServerError(job.Error)
+}
+
+//进程内的 JobStore，结束的任务保留 ttl，过期的任务在 Put 和 Get 时清理
+type MemoryJobStore struct {
+	ttl time.Duration
+
+	mu        sync.Mutex
+	jobs      map[string]*Job
+	lastSweep time.Time
+}
+
+func NewMemoryJobStore(ttl time.Duration) *MemoryJobStore {
+	return &MemoryJobStore{ttl: ttl, jobs: make(map[string]*Job), lastSweep: time.Now()}
+}
+
+func (s *MemoryJobStore) Put(job *Job) error {
+	j := *job
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sweep(time.Now())
+	s.jobs[j.ID] = &j
+	return nil
+}
+
+func (s *MemoryJobStore) Get(id string) (*Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	s.sweep(now)
+	job, ok := s.jobs[id]
+	if !ok || s.expired(job, now) {
+		return nil, ErrJobNotFound
+	}
+	j := *job
+	return &j, nil
+}
+
+func (s *MemoryJobStore) expired(job *Job, now time.Time) bool {
+	return job.State != JobRunning && now.Sub(job.Finished) > s.ttl
+}
+
+//距上次清理超过 ttl 时删除过期的任务，持有 s.mu
+func (s *MemoryJobStore) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) <= s.ttl {
+		return
+	}
+	for id, job := range s.jobs {
+		if s.expired(job, now) {
+			delete(s.jobs, id)
+		}
+	}
+	s.lastSweep = now
+}
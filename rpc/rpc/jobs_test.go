@@ -0,0 +1,206 @@
+package rpc
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+type Report struct {
+	release chan struct{}
+}
+
+type ReportArgs struct {
+	Rows int
+}
+
+type ReportResult struct {
+	Rows  int
+	Title string
+}
+
+func (r *Report) Build(ctx context.Context, args *ReportArgs, reply *ReportResult) error {
+	select {
+	case <-r.release:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	reply.Rows = args.Rows
+	reply.Title = MetadataFromContext(ctx)["title"]
+	return nil
+}
+
+func waitJob(t *testing.T, client *Client, id string, want JobState) {
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		var job Job
+		if err := client.Call("Jobs.Status", id, &job); err != nil {
+			t.Fatal(err)
+		}
+		if job.State == want {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("job %s is %v, want %v", id, job.State, want)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestJobs(t *testing.T) {
+	server := NewServer()
+	report := &Report{release: make(chan struct{})}
+	server.Register(report)
+	if err := server.RegisterJobs(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	cli, srv := net.Pipe()
+	go server.ServeConn(srv)
+	client := NewClient(cli)
+	defer client.Close()
+
+	ctx := WithMetadata(context.Background(), Metadata{"title": "weekly"})
+	id, err := client.StartJob(ctx, "Report.Build", &ReportArgs{Rows: 42})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var result ReportResult
+	if err := client.JobResult(context.Background(), id, &result); err != ErrJobRunning {
+		t.Fatalf("JobResult while running = %v, want ErrJobRunning", err)
+	}
+
+	close(report.release)
+	waitJob(t, client, id, JobDone)
+	if err := client.JobResult(context.Background(), id, &result); err != nil {
+		t.Fatal(err)
+	}
+	if result.Rows != 42 || result.Title != "weekly" {
+		t.Errorf("result = %+v", result)
+	}
+
+	var job Job
+	if err := client.Call("Jobs.Status", "missing", &job); err == nil {
+		t.Error("status of unknown job succeeded")
+	}
+}
+
+func TestJobCancel(t *testing.T) {
+	server := NewServer()
+	server.Register(&Report{release: make(chan struct{})})
+	server.RegisterJobs(NewMemoryJobStore(time.Minute))
+
+	cli, srv := net.Pipe()
+	go server.ServeConn(srv)
+	client := NewClient(cli)
+	defer client.Close()
+
+	id, err := client.StartJob(context.Background(), "Report.Build", &ReportArgs{Rows: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var canceled bool
+	if err := client.Call("Jobs.Cancel", id, &canceled); err != nil || !canceled {
+		t.Fatalf("Jobs.Cancel = %v, %v", canceled, err)
+	}
+	waitJob(t, client, id, JobCanceled)
+
+	var result ReportResult
+	if err := client.JobResult(context.Background(), id, &result); err == nil {
+		t.Error("result of canceled job succeeded")
+	}
+	if err := client.Call("Jobs.Cancel", id, &canceled); err != nil || canceled {
+		t.Errorf("second Jobs.Cancel = %v, %v", canceled, err)
+	}
+}
+
+func TestAsyncDisabled(t *testing.T) {
+	server := NewServer()
+	server.Register(new(Echo))
+	cli, srv := net.Pipe()
+	go server.ServeConn(srv)
+	client := NewClient(cli)
+	defer client.Close()
+
+	if _, err := client.StartJob(context.Background(), "Echo.Say", "hi"); err == nil || err.Error() != errAsyncDisabled.Error() {
+		t.Errorf("StartJob without jobs = %v", err)
+	}
+}
+
+//ctx 结束时 StartJob 不再留着等待中的调用
+func TestStartJobContextDone(t *testing.T) {
+	cli, srv := net.Pipe()
+	go io.Copy(io.Discard, srv)
+	client := NewClient(cli)
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := client.StartJob(ctx, "Report.Build", &ReportArgs{}); err != context.DeadlineExceeded {
+		t.Fatalf("StartJob = %v, want deadline exceeded", err)
+	}
+	client.mutex.Lock()
+	n := len(client.pending)
+	client.mutex.Unlock()
+	if n != 0 {
+		t.Errorf("%d pending calls left", n)
+	}
+}
+
+//过期的任务在 Get 时也会清理
+func TestMemoryJobStoreTTL(t *testing.T) {
+	store := NewMemoryJobStore(10 * time.Millisecond)
+	store.Put(&Job{ID: "done", State: JobDone, Finished: time.Now()})
+	store.Put(&Job{ID: "running", State: JobRunning})
+	if _, err := store.Get("done"); err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	if _, err := store.Get("done"); err != ErrJobNotFound {
+		t.Errorf("expired job: %v", err)
+	}
+	if _, err := store.Get("running"); err != nil {
+		t.Errorf("running job removed: %v", err)
+	}
+	store.mu.Lock()
+	n := len(store.jobs)
+	store.mu.Unlock()
+	if n != 1 {
+		t.Errorf("%d jobs kept, want 1", n)
+	}
+}
+
+type PeerAddr int
+
+func (p *PeerAddr) Get(ctx context.Context, args int, reply *string) error {
+	if peer, ok := PeerFromContext(ctx); ok {
+		*reply = peer.Addr
+	}
+	return nil
+}
+
+//任务的处理函数看到发起调用的对端
+func TestJobPeer(t *testing.T) {
+	server := NewServer()
+	server.Register(new(PeerAddr))
+	if err := server.RegisterJobs(nil); err != nil {
+		t.Fatal(err)
+	}
+	cli, srv := net.Pipe()
+	go server.ServeCodecPeer(NewGobServerCodec(srv), &Peer{Network: "tcp", Addr: "10.0.0.1:1000"})
+	client := NewClient(cli)
+	defer client.Close()
+
+	id, err := client.StartJob(context.Background(), "PeerAddr.Get", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitJob(t, client, id, JobDone)
+	var addr string
+	if err := client.JobResult(context.Background(), id, &addr); err != nil || addr != "10.0.0.1:1000" {
+		t.Errorf("job peer = %q, %v", addr, err)
+	}
+}
@@ -0,0 +1,138 @@
+package rpc
+
+import (
+	"bufio"
+	"io"
+	"strconv"
+)
+
+/*
+请求大小限制：
+超过限制时服务端返回一个错误 Response，然后关闭连接（流已经无法继续解析）。
+限制由编解码器在读取时执行，0 表示不限制。
+请求头过大时编解码器只有能给出可信的 Seq 才返回 *FrameTooLargeError（例如 JSON-RPC 用 null id 回复），
+否则返回其他错误，服务端不回复直接关闭连接。
+*/
+
+//请求头或请求体超过限制
+type FrameTooLargeError struct {
+	Part  string //"header" 或 "body"
+	Limit int
+}
+
+func (e *FrameTooLargeError) Error() string {
+	return "rpc: request " + e.Part + " exceeds " + strconv.Itoa(e.Limit) + " bytes"
+}
+
+//没有设置请求头限制时，按整个请求限制大小的地方（HTTP 请求体、解压后的帧）为请求头预留的字节数
+const DefaultHeaderAllowance = 64 << 10
+
+//支持大小限制的编解码器，ServeCodec 开始时设置
+type sizeLimiter interface {
+	SetSizeLimits(maxHeader, maxBody int)
+}
+
+func (server *Server) SetMaxHeaderSize(n int) {
+	server.maxHeaderSize = n
+}
+
+func (server *Server) SetMaxBodySize(n int) {
+	server.maxBodySize = n
+}
+
+//SetMaxHeaderSize 和 SetMaxBodySize 设置的值，0 表示不限制
+func (server *Server) SizeLimits() (maxHeader, maxBody int) {
+	return server.maxHeaderSize, server.maxBodySize
+}
+
+func (server *Server) applyLimits(codec ServerCodec) {
+	if server.maxHeaderSize == 0 && server.maxBodySize == 0 {
+		return
+	}
+	if l, ok := codec.(sizeLimiter); ok {
+		l.SetSizeLimits(server.maxHeaderSize, server.maxBodySize)
+	}
+}
+
+const maxGobMessage = 1 << 30
+
+/*
+按 gob 消息计数的读取器。
+gob 流由 “长度 + 消息” 组成，gob 解码器会按声明的长度一次分配缓冲，
+所以要在把长度交给解码器之前检查，而不是读到一半再报错。
+实现了 io.ByteReader，gob 不会再包一层 bufio 预读。
+*/
+type gobLimitReader struct {
+	r      *bufio.Reader
+	left   int //当前消息（含长度前缀）还没读的字节
+	budget int //当前阶段剩余的字节数
+	part   string
+	limit  int
+}
+
+func newGobLimitReader(r io.Reader) *gobLimitReader {
+	return &gobLimitReader{r: bufio.NewReader(r)}
+}
+
+//开始读请求头或请求体，limit 为 0 时不限制
+func (l *gobLimitReader) reset(part string, limit int) {
+	l.part = part
+	l.limit = limit
+	l.budget = limit
+}
+
+//在消息边界上预读长度前缀并检查
+func (l *gobLimitReader) checkNext() error {
+	p, err := l.r.Peek(1)
+	if err != nil {
+		return err
+	}
+	n, width := uint64(p[0]), 1
+	if n > 0x7f {
+		width += int(-int8(p[0]))
+		if width > 9 {
+			return io.ErrUnexpectedEOF
+		}
+		if p, err = l.r.Peek(width); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return err
+		}
+		n = 0
+		for _, b := range p[1:] {
+			n = n<<8 | uint64(b)
+		}
+	}
+
+	//gob 自身也拒绝超过 1GB 的消息
+	if n > maxGobMessage {
+		return &FrameTooLargeError{Part: l.part, Limit: maxGobMessage}
+	}
+	if l.limit > 0 && n+uint64(width) > uint64(l.budget) {
+		return &FrameTooLargeError{Part: l.part, Limit: l.limit}
+	}
+	l.left = width + int(n)
+	return nil
+}
+
+func (l *gobLimitReader) Read(p []byte) (int, error) {
+	if l.left == 0 {
+		if err := l.checkNext(); err != nil {
+			return 0, err
+		}
+	}
+	if len(p) > l.left {
+		p = p[:l.left]
+	}
+	n, err := l.r.Read(p)
+	l.left -= n
+	l.budget -= n
+	return n, err
+}
+
+func (l *gobLimitReader) ReadByte() (byte, error) {
+	var b [1]byte
+	_, err := io.ReadFull(l, b[:])
+	return b[0], err
+}
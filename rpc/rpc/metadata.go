@@ -0,0 +1,41 @@
+package rpc
+
+import "context"
+
+/*
+请求和响应的元数据：
+客户端用 WithMetadata 把元数据放进 ctx，CallContext 随请求发送；
+服务端处理函数（第一个参数为 context.Context 时）用 MetadataFromContext 读取。
+响应的元数据放在 Call.ReplyMeta 中。
+gob、jsonrpc（"meta" 字段）和 msgpackrpc 编码支持元数据，protorpc 不支持。
+*/
+
+type Metadata map[string]string
+
+type outgoingMetaKey struct{}
+
+type incomingMetaKey struct{}
+
+//为 CallContext 发出的请求附加元数据
+func WithMetadata(ctx context.Context, md Metadata) context.Context {
+	return context.WithValue(ctx, outgoingMetaKey{}, md)
+}
+
+func outgoingMetadata(ctx context.Context) Metadata {
+	md, _ := ctx.Value(outgoingMetaKey{}).(Metadata)
+	return md
+}
+
+//附加请求元数据的 ctx
+func requestContext(ctx context.Context, req *Request) context.Context {
+	if req.Meta == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, incomingMetaKey{}, req.Meta)
+}
+
+//处理函数收到的请求元数据
+func MetadataFromContext(ctx context.Context) Metadata {
+	md, _ := ctx.Value(incomingMetaKey{}).(Metadata)
+	return md
+}
@@ -0,0 +1,122 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"log"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/*
+服务端推送：
+处理函数通过 ConnFromContext 拿到连接句柄，之后可随时调用 Notify 向客户端发送消息。
+gob 编码下推送消息以 Response 的形式写出，Seq 最高位置 1（保留空间，请求不能使用）；
+jsonrpc 编码下写成 id 为 null 的 JSON-RPC 通知。
+*/
+
+//Seq 最高位作为推送消息的保留空间
+const NotifySeqBit uint64 = 1 << 63
+
+func IsNotifySeq(seq uint64) bool {
+	return seq&NotifySeqBit != 0
+}
+
+var ErrConnClosed = errors.New("rpc: connection is closed")
+
+type connKey struct{}
+
+//服务端的一个连接
+type Conn struct {
+	codec   ServerCodec
+	sending *sync.Mutex
+	seq     uint64
+	closed  bool //由 sending 保护
+	peer    *Peer
+
+	id       uint64
+	created  time.Time
+	inFlight int64
+
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func newConn(codec ServerCodec, sending *sync.Mutex) *Conn {
+	c := &Conn{codec: codec, sending: sending, created: time.Now()}
+	c.ctx, c.cancel = context.WithCancel(context.WithValue(context.Background(), connKey{}, c))
+	return c
+}
+
+//取出处理函数所在的连接
+func ConnFromContext(ctx context.Context) (*Conn, bool) {
+	c, ok := ctx.Value(connKey{}).(*Conn)
+	return c, ok
+}
+
+//连接关闭时 Done
+func (c *Conn) Context() context.Context {
+	return c.ctx
+}
+
+//向客户端推送一条消息，连接关闭后返回 ErrConnClosed
+func (c *Conn) Notify(serviceMethod string, body interface{}) error {
+	resp := &Response{
+		ServiceMethod: serviceMethod,
+		Seq:           NotifySeqBit | atomic.AddUint64(&c.seq, 1),
+	}
+
+	c.sending.Lock()
+	defer c.sending.Unlock()
+	if c.closed {
+		return ErrConnClosed
+	}
+	return c.codec.WriteResponse(resp, body)
+}
+
+func (c *Conn) close() {
+	c.sending.Lock()
+	c.closed = true
+	c.sending.Unlock()
+	c.cancel()
+}
+
+/*
+注册推送消息的处理函数，handler 的形式为 func(*T)，
+收到 serviceMethod 的推送时把消息体解码到新的 *T 再调用 handler。
+handler 在读响应的 goroutine 中执行，不应长时间阻塞。
+*/
+func (client *Client) OnNotify(serviceMethod string, handler interface{}) error {
+	fn := reflect.ValueOf(handler)
+	ft := fn.Type()
+	if ft.Kind() != reflect.Func || ft.NumIn() != 1 || ft.In(0).Kind() != reflect.Ptr || ft.NumOut() != 0 {
+		return errors.New("rpc: notify handler for " + serviceMethod + " must be func(*T)")
+	}
+
+	client.mutex.Lock()
+	client.notify[serviceMethod] = fn
+	client.mutex.Unlock()
+	return nil
+}
+
+func (client *Client) dispatchNotify(serviceMethod string) error {
+	client.mutex.Lock()
+	fn, ok := client.notify[serviceMethod]
+	client.mutex.Unlock()
+
+	if !ok {
+		if debugLog.Load() {
+			log.Println("rpc: no handler for notification", serviceMethod)
+		}
+		return client.codec.ReadResponseBody(nil)
+	}
+
+	body := reflect.New(fn.Type().In(0).Elem())
+	if err := client.codec.ReadResponseBody(body.Interface()); err != nil {
+		return err
+	}
+	fn.Call([]reflect.Value{body})
+	return nil
+}
@@ -0,0 +1,80 @@
+package rpc
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+type Event struct {
+	N    int
+	Text string
+}
+
+type Pusher int
+
+//回复之前先推送 n 条消息
+func (p *Pusher) Push(ctx context.Context, n int, reply *int) error {
+	c, ok := ConnFromContext(ctx)
+	if !ok {
+		return ErrConnClosed
+	}
+	for i := 0; i < n; i++ {
+		if err := c.Notify("Pusher.Event", &Event{N: i, Text: "hello"}); err != nil {
+			return err
+		}
+	}
+	*reply = n
+	return nil
+}
+
+func TestNotifyRoundTrip(t *testing.T) {
+	server := NewServer()
+	server.Register(new(Pusher))
+	cli, srv := net.Pipe()
+	go server.ServeConn(srv)
+	client := NewClient(cli)
+	defer client.Close()
+
+	events := make(chan *Event, 10)
+	if err := client.OnNotify("Pusher.Event", func(e *Event) { events <- e }); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.OnNotify("Pusher.Event", func(e Event) {}); err == nil {
+		t.Error("handler with non-pointer argument accepted")
+	}
+
+	var n int
+	if err := client.Call("Pusher.Push", 3, &n); err != nil || n != 3 {
+		t.Fatalf("Push = %d, %v", n, err)
+	}
+	for i := 0; i < 3; i++ {
+		select {
+		case e := <-events:
+			if e.N != i || e.Text != "hello" {
+				t.Errorf("event %d = %+v", i, e)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("event %d not received", i)
+		}
+	}
+}
+
+func TestReservedSeqRejected(t *testing.T) {
+	server := NewServer()
+	server.Register(new(Pusher))
+	cli, srv := net.Pipe()
+	go server.ServeConn(srv)
+	client := NewClient(cli)
+	defer client.Close()
+
+	client.mutex.Lock()
+	client.seq = NotifySeqBit
+	client.mutex.Unlock()
+
+	var reply int
+	if err := client.Call("Pusher.Push", 1, &reply); err != ErrSeqExhausted {
+		t.Fatalf("call with reserved seq = %v, want ErrSeqExhausted", err)
+	}
+}
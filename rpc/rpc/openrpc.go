@@ -0,0 +1,67 @@
+package rpc
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+
+	"github.com/shengzhch/learn/openrpc"
+)
+
+/*
+根据已注册的服务生成 OpenRPC 文档，参数和返回值的 schema 由 ArgType、ReplyType 反射得到，
+字段名按 json 标签取，与 jsonrpc 传输上的编码一致。
+HandleHTTP 把文档挂在 debugPath + "/openrpc" 上。
+*/
+
+const DefaultOpenRPCPath = DefaultDebugPath + "/openrpc"
+
+//生成当前已注册服务的文档，方法按名字排序。
+//schema 按排好序的方法依次生成，同名类型哪个加包名只取决于注册的内容，同样的注册总是得到同样的文档
+func (server *Server) OpenRPC() *openrpc.Document {
+	type namedMethod struct {
+		name  string
+		mtype *methodType
+	}
+	var methods []namedMethod
+	server.serviceMap.Range(func(_, svci interface{}) bool {
+		svc := svci.(*service)
+		if isRPCService(svc) {
+			return true
+		}
+		for name, mtype := range svc.method {
+			methods = append(methods, namedMethod{svc.name + "." + name, mtype})
+		}
+		return true
+	})
+	sort.Slice(methods, func(i, j int) bool { return methods[i].name < methods[j].name })
+
+	doc := openrpc.NewDocument("rpc", "1.0.0")
+	for _, nm := range methods {
+		m := openrpc.NewMethod(nm.name, doc.Schema(nm.mtype.ArgType), doc.Schema(nm.mtype.ReplyType))
+		nm.mtype.Lock()
+		m.Deprecated = nm.mtype.deprecated != ""
+		nm.mtype.Unlock()
+		doc.Methods = append(doc.Methods, m)
+	}
+	return doc
+}
+
+type openRPCHTTP struct {
+	*Server
+}
+
+func (server openRPCHTTP) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "GET" && req.Method != "HEAD" {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "405 must GET", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := json.MarshalIndent(server.OpenRPC(), "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Write(body)
+}
@@ -0,0 +1,113 @@
+package rpc
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/shengzhch/learn/openrpc"
+)
+
+type Audit struct {
+	Created time.Time `json:"created"`
+}
+
+type Node struct {
+	Audit
+	Name     string  `json:"name"`
+	Children []*Node `json:"children,omitempty"`
+	Data     []byte
+	Secret   string `json:"-"`
+	private  int
+}
+
+type Tree int
+
+func (t *Tree) Walk(args *Node, reply *map[string]int) error { return nil }
+
+func TestOpenRPC(t *testing.T) {
+	server := NewServer()
+	server.Register(new(Tree))
+	server.Register(new(Echo))
+	server.SetKeepAlive(true)
+
+	w := httptest.NewRecorder()
+	openRPCHTTP{server}.ServeHTTP(w, httptest.NewRequest("GET", DefaultOpenRPCPath, nil))
+	if ct := w.Header().Get("Content-Type"); ct != "application/json; charset=utf-8" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	var doc openrpc.Document
+	if err := json.Unmarshal(w.Body.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	for _, m := range doc.Methods {
+		names = append(names, m.Name)
+	}
+	//内置的 RPC 服务不出现在文档中
+	if want := []string{"Echo.Say", "Tree.Walk"}; len(names) != 2 || names[0] != want[0] || names[1] != want[1] {
+		t.Fatalf("methods = %v, want %v", names, want)
+	}
+
+	walk := doc.Methods[1]
+	if walk.Params[0].Schema.Ref != "#/components/schemas/Node" {
+		t.Errorf("param ref = %q", walk.Params[0].Schema.Ref)
+	}
+	if r := walk.Result.Schema; r.Type != "object" || r.AdditionalProperties.Type != "integer" {
+		t.Errorf("unexpected result schema %+v", r)
+	}
+
+	node := doc.Components.Schemas["Node"]
+	if node == nil {
+		t.Fatalf("Node schema missing: %s", w.Body)
+	}
+	for name, want := range map[string]string{"created": "string", "name": "string", "children": "array", "Data": "string"} {
+		if p := node.Properties[name]; p == nil || p.Type != want {
+			t.Errorf("property %q = %+v, want type %q", name, p, want)
+		}
+	}
+	if len(node.Properties) != 4 {
+		t.Errorf("unexpected properties %v", node.Properties)
+	}
+	if items := node.Properties["children"].Items; items == nil || items.Ref != "#/components/schemas/Node" {
+		t.Errorf("children items = %+v", items)
+	}
+
+	w = httptest.NewRecorder()
+	openRPCHTTP{server}.ServeHTTP(w, httptest.NewRequest("POST", DefaultOpenRPCPath, nil))
+	if w.Code != 405 {
+		t.Errorf("POST status = %d, want 405", w.Code)
+	}
+}
+
+type Info struct {
+	Owner string
+}
+
+type InfoA int
+
+func (InfoA) Get(args *openrpc.Info, reply *int) error { return nil }
+
+type InfoB int
+
+func (InfoB) Get(args *Info, reply *int) error { return nil }
+
+//同名类型的组件名不随遍历顺序变化
+func TestOpenRPCStable(t *testing.T) {
+	server := NewServer()
+	server.Register(new(InfoB))
+	server.Register(new(InfoA))
+
+	first, _ := json.Marshal(server.OpenRPC())
+	for i := 0; i < 20; i++ {
+		if doc, _ := json.Marshal(server.OpenRPC()); string(doc) != string(first) {
+			t.Fatalf("document changed between runs:\n%s\n%s", first, doc)
+		}
+	}
+	doc := server.OpenRPC()
+	if a, b := doc.Methods[0].Params[0].Schema.Ref, doc.Methods[1].Params[0].Schema.Ref; a != "#/components/schemas/Info" || b != "#/components/schemas/rpc.Info" {
+		t.Errorf("refs = %q, %q", a, b)
+	}
+}
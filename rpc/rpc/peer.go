@@ -0,0 +1,154 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+)
+
+/*
+连接对端的信息和权限检查：
+ServeConn 从 net.Conn 取得对端地址，Unix 套接字在 Linux 上还会通过 SO_PEERCRED 取得对端进程的 uid/gid/pid。
+处理函数用 PeerFromContext 读取；SetAuthorizer 设置的检查在每个请求执行前调用，返回错误时请求被拒绝。
+没有设置 Authorizer 时，内置的管理服务（Admin、Fault）一律拒绝。
+其他编解码器可以用 ServeCodecPeer(codec, PeerOf(conn)) 提供同样的信息。
+*/
+
+//对端进程的凭据
+type Cred struct {
+	PID int32
+	UID uint32
+	GID uint32
+}
+
+type Peer struct {
+	Network string
+	Addr    string
+	Cred    *Cred //只有 Linux 上的 Unix 套接字才有
+}
+
+//取得连接的对端信息，conn 不是 net.Conn 也没有 NetConn 方法（例如 websocket 连接）时返回 nil
+func PeerOf(conn io.ReadWriteCloser) *Peer {
+	if w, ok := conn.(interface{ NetConn() net.Conn }); ok {
+		conn = w.NetConn()
+	}
+	c, ok := conn.(net.Conn)
+	if !ok || c == nil {
+		return nil
+	}
+	p := &Peer{}
+	if addr := c.RemoteAddr(); addr != nil {
+		p.Network = addr.Network()
+		p.Addr = addr.String()
+	}
+	if uc, ok := c.(*net.UnixConn); ok {
+		p.Network = "unix"
+		if cred, err := peerCred(uc); err == nil {
+			p.Cred = cred
+		}
+	}
+	return p
+}
+
+//HTTP 请求的对端，网络类型取自 http.Server 的监听地址，不知道时为空
+func HTTPPeer(req *http.Request) *Peer {
+	p := &Peer{Addr: req.RemoteAddr}
+	if addr, ok := req.Context().Value(http.LocalAddrContextKey).(net.Addr); ok {
+		p.Network = addr.Network()
+	}
+	return p
+}
+
+//处理函数所在连接的对端
+func PeerFromContext(ctx context.Context) (*Peer, bool) {
+	c, ok := ConnFromContext(ctx)
+	if !ok || c.peer == nil {
+		return nil, false
+	}
+	return c.peer, true
+}
+
+//请求执行前的权限检查，ctx 中有对端信息和请求元数据
+type Authorizer func(ctx context.Context, serviceMethod string) error
+
+func (server *Server) SetAuthorizer(a Authorizer) {
+	server.authorizer = a
+}
+
+var ErrPermissionDenied = errors.New("rpc: permission denied")
+
+//只允许给定 uid 的本地进程调用
+func AllowUIDs(uids ...uint32) Authorizer {
+	return func(ctx context.Context, serviceMethod string) error {
+		if p, ok := PeerFromContext(ctx); ok && p.Cred != nil {
+			for _, uid := range uids {
+				if p.Cred.UID == uid {
+					return nil
+				}
+			}
+		}
+		return ErrPermissionDenied
+	}
+}
+
+//按解析出的服务和方法检查，Authorizer 收到注册时的名字而不是请求中的别名
+func (server *Server) authorize(ctx context.Context, svc *service, mtype *methodType, req *Request) error {
+	return server.authorizeName(requestContext(ctx, req), svc.name+"."+mtype.method.Name, privileged(svc))
+}
+
+//没有设置 Authorizer 时必须拒绝的内置服务
+func privileged(svc *service) bool {
+	switch svc.rcvri.(type) {
+	case *Admin, *FaultService:
+		return true
+	}
+	return false
+}
+
+//没有设置 Authorizer 时拒绝管理服务的调用
+func (server *Server) authorizeName(ctx context.Context, serviceMethod string, privileged bool) error {
+	if server.authorizer == nil {
+		if privileged {
+			return ErrPermissionDenied
+		}
+		return nil
+	}
+	return server.authorizer(ctx, serviceMethod)
+}
+
+//在 Unix 套接字上监听；path 以 "@" 开头时在 Linux 上使用抽象套接字，不创建文件。
+//已有的套接字文件会被删除，perm 非 0 时修改文件权限
+func ListenUnix(path string, perm os.FileMode) (*net.UnixListener, error) {
+	abstract := len(path) > 0 && path[0] == '@'
+	if !abstract {
+		if fi, err := os.Lstat(path); err == nil {
+			if fi.Mode()&os.ModeSocket == 0 {
+				return nil, errors.New("rpc: " + path + " exists and is not a socket")
+			}
+			os.Remove(path)
+		}
+	}
+	lis, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		return nil, err
+	}
+	if !abstract && perm != 0 {
+		if err := os.Chmod(path, perm); err != nil {
+			lis.Close()
+			return nil, err
+		}
+	}
+	return lis, nil
+}
+
+func DialUnix(path string) (*Client, error) {
+	return Dial("unix", path)
+}
+
+func (c *Cred) String() string {
+	return "pid=" + strconv.Itoa(int(c.PID)) + " uid=" + strconv.Itoa(int(c.UID)) + " gid=" + strconv.Itoa(int(c.GID))
+}
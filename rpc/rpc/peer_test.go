@@ -0,0 +1,81 @@
+//go:build linux
+
+package rpc
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type Whoami int
+
+func (w *Whoami) Get(ctx context.Context, args *string, reply *Cred) error {
+	p, ok := PeerFromContext(ctx)
+	if !ok || p.Cred == nil {
+		return ErrPermissionDenied
+	}
+	*reply = *p.Cred
+	return nil
+}
+
+func TestUnixPeerCred(t *testing.T) {
+	server := NewServer()
+	server.Register(new(Whoami))
+	server.Register(new(Echo))
+	server.SetAuthorizer(func(ctx context.Context, serviceMethod string) error {
+		if serviceMethod == "Echo.Say" {
+			return ErrPermissionDenied
+		}
+		return AllowUIDs(uint32(os.Getuid()))(ctx, serviceMethod)
+	})
+
+	path := filepath.Join(t.TempDir(), "rpc.sock")
+	lis, err := ListenUnix(path, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+	go server.Accept(lis)
+
+	fi, err := os.Stat(path)
+	if err != nil || fi.Mode().Perm() != 0600 {
+		t.Fatalf("socket mode = %v, %v", fi, err)
+	}
+
+	client, err := DialUnix(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	var cred Cred
+	if err := client.Call("Whoami.Get", "", &cred); err != nil {
+		t.Fatal(err)
+	}
+	if cred.UID != uint32(os.Getuid()) || cred.GID != uint32(os.Getgid()) || cred.PID != int32(os.Getpid()) {
+		t.Errorf("cred = %s, want pid=%d uid=%d gid=%d", &cred, os.Getpid(), os.Getuid(), os.Getgid())
+	}
+
+	var reply string
+	if err := client.Call("Echo.Say", "hi", &reply); err == nil || err.Error() != ErrPermissionDenied.Error() {
+		t.Errorf("Echo.Say err = %v, want permission denied", err)
+	}
+
+	//抽象套接字
+	lis2, err := ListenUnix("@rpc-test-"+filepath.Base(t.TempDir()), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis2.Close()
+	go server.Accept(lis2)
+	client2, err := DialUnix(lis2.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client2.Close()
+	if err := client2.Call("Whoami.Get", "", &cred); err != nil {
+		t.Fatal(err)
+	}
+}
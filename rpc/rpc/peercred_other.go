@@ -0,0 +1,12 @@
+//go:build !linux
+
+package rpc
+
+import (
+	"errors"
+	"net"
+)
+
+func peerCred(c *net.UnixConn) (*Cred, error) {
+	return nil, errors.New("rpc: peer credentials are only supported on linux")
+}
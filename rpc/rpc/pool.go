@@ -0,0 +1,79 @@
+package rpc
+
+import "reflect"
+
+/*
+参数和结果值的复用：
+默认每次调用都通过 reflect.New 分配新的参数和结果，
+SetPooling(true) 后按 methodType 复用，响应写完后清零放回池中。
+开启后处理函数返回后不能再持有参数或结果（包括其中的指针、切片、map）。
+*/
+
+//参数或结果类型实现 Resetter 时，放回池前调用 Reset 代替清零，
+//可以用来保留切片容量等
+type Resetter interface {
+	Reset()
+}
+
+//开启或关闭复用，必须在开始服务之前调用：取出和放回的值要按同一个设置处理
+func (server *Server) SetPooling(on bool) {
+	server.pooling = on
+}
+
+//清零 p 指向的值
+func resetValue(p reflect.Value) {
+	if r, ok := p.Interface().(Resetter); ok {
+		r.Reset()
+		return
+	}
+	p.Elem().Set(reflect.Zero(p.Elem().Type()))
+}
+
+//返回用于解码参数的指针
+func (m *methodType) newArg(pooling bool) reflect.Value {
+	if pooling {
+		if p := m.argPool.Get(); p != nil {
+			return reflect.ValueOf(p)
+		}
+	}
+	if m.ArgType.Kind() == reflect.Ptr {
+		return reflect.New(m.ArgType.Elem())
+	}
+	return reflect.New(m.ArgType)
+}
+
+func (m *methodType) freeArg(argp reflect.Value) {
+	resetValue(argp)
+	m.argPool.Put(argp.Interface())
+}
+
+//返回结果指针，map 和 slice 预先初始化
+func (m *methodType) newReply(pooling bool) reflect.Value {
+	var replyv reflect.Value
+	if pooling {
+		if p := m.replyPool.Get(); p != nil {
+			replyv = reflect.ValueOf(p)
+		}
+	}
+	if !replyv.IsValid() {
+		replyv = reflect.New(m.ReplyType.Elem())
+	}
+
+	elem := replyv.Elem()
+	switch elem.Kind() {
+	case reflect.Map:
+		if elem.IsNil() {
+			elem.Set(reflect.MakeMap(elem.Type()))
+		}
+	case reflect.Slice:
+		if elem.IsNil() {
+			elem.Set(reflect.MakeSlice(elem.Type(), 0, 0))
+		}
+	}
+	return replyv
+}
+
+func (m *methodType) freeReply(replyv reflect.Value) {
+	resetValue(replyv)
+	m.replyPool.Put(replyv.Interface())
+}
@@ -0,0 +1,100 @@
+package rpc
+
+import (
+	"testing"
+)
+
+type PoolArgs struct {
+	A, B  int
+	Items []int
+}
+
+type PoolReply struct {
+	Sum   int
+	Items []int
+}
+
+type Adder int
+
+func (a *Adder) Add(args *PoolArgs, reply *PoolReply) error {
+	reply.Sum = args.A + args.B
+	reply.Items = append(reply.Items, args.Items...)
+	return nil
+}
+
+//保留切片容量的 Reset
+func (r *PoolReply) Reset() {
+	r.Sum = 0
+	r.Items = r.Items[:0]
+}
+
+//内存中的编解码器，每次返回同一个请求，不做序列化
+type memCodec struct {
+	args PoolArgs
+	sum  int
+}
+
+func (c *memCodec) ReadRequestHeader(r *Request) error {
+	r.ServiceMethod = "Adder.Add"
+	r.Seq = 1
+	return nil
+}
+
+func (c *memCodec) ReadRequestBody(body interface{}) error {
+	args := body.(*PoolArgs)
+	args.A, args.B = c.args.A, c.args.B
+	args.Items = append(args.Items, c.args.Items...)
+	return nil
+}
+
+func (c *memCodec) WriteResponse(r *Response, body interface{}) error {
+	c.sum = body.(*PoolReply).Sum
+	return nil
+}
+
+func (c *memCodec) Close() error { return nil }
+
+func TestPoolingResetsValues(t *testing.T) {
+	server := NewServer()
+	server.Register(new(Adder))
+	server.SetPooling(true)
+
+	codec := &memCodec{args: PoolArgs{A: 1, B: 2, Items: []int{1, 2, 3}}}
+	for i := 0; i < 100; i++ {
+		if err := server.ServerRequest(codec); err != nil {
+			t.Fatal(err)
+		}
+		if codec.sum != 3 {
+			t.Fatalf("sum = %d", codec.sum)
+		}
+	}
+
+	_, mtype, err := server.lookup("Adder.Add")
+	if err != nil {
+		t.Fatal(err)
+	}
+	reply := mtype.newReply(true).Interface().(*PoolReply)
+	args := mtype.newArg(true).Interface().(*PoolArgs)
+	if reply.Sum != 0 || len(reply.Items) != 0 || args.A != 0 || len(args.Items) != 0 {
+		t.Fatalf("pooled values not reset: %+v %+v", args, reply)
+	}
+}
+
+func benchmarkServeRequest(b *testing.B, pooling bool) {
+	server := NewServer()
+	server.Register(new(Adder))
+	server.SetPooling(pooling)
+
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		codec := &memCodec{args: PoolArgs{A: 1, B: 2, Items: []int{1, 2, 3, 4, 5, 6, 7, 8}}}
+		for pb.Next() {
+			server.ServerRequest(codec)
+		}
+	})
+}
+
+func BenchmarkServeRequestParallel(b *testing.B) {
+	b.Run("alloc", func(b *testing.B) { benchmarkServeRequest(b, false) })
+	b.Run("pool", func(b *testing.B) { benchmarkServeRequest(b, true) })
+}
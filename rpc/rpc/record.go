@@ -0,0 +1,145 @@
+package rpc
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+/*
+流量录制：
+RecordingCodec 包装一个 ServerCodec，把每一对请求和响应写成一行 JSON（见 Record），
+参数和结果按 encoding/json 编码，与编解码器无关，回放时通过 jsonrpc 发送（见 replay 包）。
+Server.SetRecorder 让 ServeConn/ServeCodec 的所有连接都被录制。
+*/
+
+//一次调用的记录
+type Record struct {
+	Seq           uint64          `json:"seq"`
+	ServiceMethod string          `json:"method"`
+	Start         time.Time       `json:"start"` //读到请求头的时间
+	End           time.Time       `json:"end"`   //写出响应的时间
+	Args          json.RawMessage `json:"args"`
+	Reply         json.RawMessage `json:"reply,omitempty"`
+	Error         string          `json:"error,omitempty"`
+}
+
+//写录制文件，可以被多个连接共用
+type Recorder struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+	err error
+}
+
+func NewRecorder(w io.Writer) *Recorder {
+	return &Recorder{enc: json.NewEncoder(w)}
+}
+
+//第一次写入失败的错误，之后的记录被丢弃
+func (r *Recorder) Err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.err
+}
+
+func (r *Recorder) write(rec *Record) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err == nil {
+		r.err = r.enc.Encode(rec)
+	}
+}
+
+//读取录制文件
+func ReadRecords(r io.Reader) ([]*Record, error) {
+	var records []*Record
+	dec := json.NewDecoder(bufio.NewReader(r))
+	for {
+		rec := new(Record)
+		if err := dec.Decode(rec); err != nil {
+			if err == io.EOF {
+				return records, nil
+			}
+			return records, err
+		}
+		records = append(records, rec)
+	}
+}
+
+func (server *Server) SetRecorder(r *Recorder) {
+	server.recorder = r
+}
+
+type recordingCodec struct {
+	ServerCodec
+	rec *Recorder
+
+	mu      sync.Mutex
+	pending map[uint64]*Record
+	cur     *Record //读完请求头、还没读请求体的记录
+}
+
+func RecordingCodec(codec ServerCodec, r *Recorder) ServerCodec {
+	return &recordingCodec{ServerCodec: codec, rec: r, pending: make(map[uint64]*Record)}
+}
+
+func (c *recordingCodec) ReadRequestHeader(r *Request) error {
+	c.cur = nil
+	err := c.ServerCodec.ReadRequestHeader(r)
+	if err != nil {
+		return err
+	}
+	c.cur = &Record{Seq: r.Seq, ServiceMethod: r.ServiceMethod, Start: time.Now()}
+	c.mu.Lock()
+	c.pending[r.Seq] = c.cur
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *recordingCodec) ReadRequestBody(body interface{}) error {
+	err := c.ServerCodec.ReadRequestBody(body)
+	if err == nil && body != nil && c.cur != nil {
+		c.cur.Args, _ = json.Marshal(body)
+	}
+	return err
+}
+
+func (c *recordingCodec) WriteResponse(r *Response, body interface{}) error {
+	err := c.ServerCodec.WriteResponse(r, body)
+
+	c.mu.Lock()
+	rec := c.pending[r.Seq]
+	delete(c.pending, r.Seq)
+	c.mu.Unlock()
+	//推送消息没有对应的请求
+	if rec == nil {
+		return err
+	}
+	rec.End = time.Now()
+	rec.Error = r.Error
+	if r.Error == "" {
+		rec.Reply, _ = json.Marshal(body)
+	}
+	if rec.Args == nil {
+		rec.Args = json.RawMessage("null")
+	}
+	c.rec.write(rec)
+	return err
+}
+
+//转发可选接口
+func (c *recordingCodec) SetSizeLimits(maxHeader, maxBody int) {
+	if l, ok := c.ServerCodec.(sizeLimiter); ok {
+		l.SetSizeLimits(maxHeader, maxBody)
+	}
+}
+
+func (c *recordingCodec) SetReadDeadline(t time.Time) error {
+	return SetReadDeadline(c.ServerCodec, t)
+}
+
+func (c *recordingCodec) SetWriteDeadline(t time.Time) error {
+	return SetWriteDeadline(c.ServerCodec, t)
+}
@@ -0,0 +1,51 @@
+package rpc
+
+import (
+	"bytes"
+	"net"
+	"testing"
+)
+
+func TestRecorder(t *testing.T) {
+	var buf bytes.Buffer
+	rec := NewRecorder(&buf)
+	server := NewServer()
+	server.Register(new(Echo))
+	server.SetRecorder(rec)
+
+	cli, srv := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		server.ServeConn(srv)
+		close(done)
+	}()
+	client := NewClient(cli)
+
+	var reply string
+	if err := client.Call("Echo.Say", "hello", &reply); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Call("Echo.Shout", "hello", &reply); err == nil {
+		t.Fatal("expected error for unknown method")
+	}
+	client.Close()
+	<-done
+
+	records, err := ReadRecords(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("got %d records:\n%s", len(records), buf.String())
+	}
+	r := records[0]
+	if r.ServiceMethod != "Echo.Say" || string(r.Args) != `"hello"` || string(r.Reply) != `"hello"` || r.Error != "" {
+		t.Errorf("unexpected record %+v", r)
+	}
+	if r.Start.IsZero() || r.End.Before(r.Start) {
+		t.Errorf("bad timestamps %v %v", r.Start, r.End)
+	}
+	if r := records[1]; r.ServiceMethod != "Echo.Shout" || r.Error == "" || r.Reply != nil {
+		t.Errorf("unexpected record %+v", r)
+	}
+}
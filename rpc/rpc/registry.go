@@ -0,0 +1,104 @@
+package rpc
+
+import (
+	"log"
+	"net"
+	"sort"
+	"sync"
+)
+
+/*
+服务发现：
+设置 Registry 后，Accept 开始时把监听地址和服务名登记上去，监听关闭、Accept 返回时注销。
+SetHealthy 修改健康状态后重新登记。
+内存和目录两种实现以及客户端的解析在 registry 包中。
+*/
+
+//一个服务端的登记信息
+type Endpoint struct {
+	Network  string
+	Addr     string
+	Services []string
+	Healthy  bool
+}
+
+type Registry interface {
+	//登记或更新，以 Network 和 Addr 区分
+	Register(ep Endpoint) error
+	Deregister(ep Endpoint) error
+	//提供 service 服务的所有端点，包括不健康的
+	Lookup(service string) ([]Endpoint, error)
+}
+
+type announcer struct {
+	mu        sync.Mutex
+	registry  Registry
+	unhealthy bool
+	endpoints map[string]Endpoint //正在监听的地址
+}
+
+func (server *Server) SetRegistry(r Registry) {
+	server.announcer.mu.Lock()
+	server.announcer.registry = r
+	server.announcer.mu.Unlock()
+}
+
+//修改健康状态并通知 Registry
+func (server *Server) SetHealthy(healthy bool) {
+	a := &server.announcer
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.unhealthy = !healthy
+	if a.registry == nil {
+		return
+	}
+	for key, ep := range a.endpoints {
+		ep.Healthy = healthy
+		a.endpoints[key] = ep
+		if err := a.registry.Register(ep); err != nil {
+			log.Println("rpc: registry update ", ep.Addr, ": ", err.Error())
+		}
+	}
+}
+
+//已注册的服务名，不含内置服务
+func (server *Server) serviceNames() []string {
+	var names []string
+	server.serviceMap.Range(func(name, svci interface{}) bool {
+		if !isRPCService(svci.(*service)) {
+			names = append(names, name.(string))
+		}
+		return true
+	})
+	sort.Strings(names)
+	return names
+}
+
+//登记监听地址，返回注销函数
+func (server *Server) announce(addr net.Addr) func() {
+	a := &server.announcer
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.registry == nil {
+		return func() {}
+	}
+	reg := a.registry
+	ep := Endpoint{Network: addr.Network(), Addr: addr.String(), Services: server.serviceNames(), Healthy: !a.unhealthy}
+	if err := reg.Register(ep); err != nil {
+		log.Println("rpc: registry register ", ep.Addr, ": ", err.Error())
+	}
+	key := ep.Network + " " + ep.Addr
+	if a.endpoints == nil {
+		a.endpoints = make(map[string]Endpoint)
+	}
+	a.endpoints[key] = ep
+
+	return func() {
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		delete(a.endpoints, key)
+		if err := reg.Deregister(ep); err != nil {
+			log.Println("rpc: registry deregister ", ep.Addr, ": ", err.Error())
+		}
+	}
+}
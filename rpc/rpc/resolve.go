@@ -0,0 +1,121 @@
+package rpc
+
+import (
+	"errors"
+	"strings"
+)
+
+/*
+服务名和方法名的解析：
+ServiceMethod 以最后一个 "." 分为服务名和方法名，服务名本身可以带命名空间，
+例如 "billing.v2.Invoice.Create" 对应用 RegisterName 注册的 "billing.v2.Invoice" 服务的 Create 方法。
+
+可选功能（默认关闭）：
+  - 方法别名：RegisterAlias("Invoice.New", "Invoice.Create")
+  - 大小写不敏感：SetCaseInsensitive(true) 后精确匹配失败时忽略大小写再查一次
+*/
+
+//检查带命名空间的服务名，不能有空的段，"@" 后的版本不能为空
+func validServiceName(name string) bool {
+	if strings.HasSuffix(name, "@") {
+		return false
+	}
+	name, version := splitVersion(name)
+	if strings.Contains(name, "@") || strings.Contains(version, ".") {
+		return false
+	}
+	for _, seg := range strings.Split(name, ".") {
+		if seg == "" {
+			return false
+		}
+	}
+	return true
+}
+
+func splitServiceMethod(serviceMethod string) (serviceName, methodName string, ok bool) {
+	dot := strings.LastIndex(serviceMethod, ".")
+	if dot <= 0 || dot == len(serviceMethod)-1 {
+		return "", "", false
+	}
+	return serviceMethod[:dot], serviceMethod[dot+1:], true
+}
+
+//为 target 注册一个别名，两者都是 "Service.Method" 形式，target 必须已经注册
+func (server *Server) RegisterAlias(alias, target string) error {
+	if _, _, ok := splitServiceMethod(alias); !ok {
+		return errors.New("rpc: alias ill-formed: " + alias)
+	}
+	svc, mtype, err := server.lookup(target)
+	if err != nil {
+		return err
+	}
+	if _, dup := server.aliases.LoadOrStore(alias, &methodRef{svc, mtype}); dup {
+		return errors.New("rpc: alias has already defined: " + alias)
+	}
+	return nil
+}
+
+type methodRef struct {
+	svc   *service
+	mtype *methodType
+}
+
+//开启后服务名和方法名匹配忽略大小写，必须在开始服务之前调用
+func (server *Server) SetCaseInsensitive(on bool) {
+	server.caseInsensitive = on
+}
+
+//解析 ServiceMethod，顺序为：精确匹配、别名、忽略大小写
+func (server *Server) lookup(serviceMethod string) (svc *service, mtype *methodType, err error) {
+	serviceName, methodName, ok := splitServiceMethod(serviceMethod)
+	if !ok {
+		return nil, nil, errors.New("rpc: service/method request ill-formed: " + serviceMethod)
+	}
+
+	if svci, ok := server.serviceMap.Load(serviceName); ok {
+		svc = svci.(*service)
+		if mtype = svc.method[methodName]; mtype != nil {
+			return svc, mtype, nil
+		}
+	}
+
+	if ref, ok := server.aliases.Load(serviceMethod); ok {
+		r := ref.(*methodRef)
+		return r.svc, r.mtype, nil
+	}
+
+	if server.caseInsensitive {
+		if svc == nil {
+			if svci, ok := server.serviceFold.Load(strings.ToLower(serviceName)); ok {
+				svc = svci.(*service)
+			}
+		}
+		if svc != nil {
+			if mtype = svc.methodFold[strings.ToLower(methodName)]; mtype != nil {
+				return svc, mtype, nil
+			}
+		}
+	}
+
+	if svc == nil {
+		return nil, nil, errors.New("rpc: can't find service " + serviceMethod)
+	}
+	return nil, nil, errors.New("rpc: can't find method " + serviceMethod)
+}
+
+//小写名字到方法的索引，只差大小写的重名方法不参与忽略大小写的匹配
+func foldMethods(methods map[string]*methodType) map[string]*methodType {
+	fold := make(map[string]*methodType, len(methods))
+	ambiguous := make(map[string]bool)
+	for name, m := range methods {
+		key := strings.ToLower(name)
+		if _, dup := fold[key]; dup {
+			ambiguous[key] = true
+		}
+		fold[key] = m
+	}
+	for key := range ambiguous {
+		delete(fold, key)
+	}
+	return fold
+}
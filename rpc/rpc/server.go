@@ -1,7 +1,7 @@
 package rpc
 
 import (
-	"bufio"
+	"context"
 	"encoding/gob"
 	"errors"
 	"io"
@@ -11,6 +11,8 @@ import (
 	"reflect"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 	"unicode"
 	"unicode/utf8"
 )
@@ -50,6 +52,11 @@ Go官方提供了一个RPC库: net/rpc。
 
 func (t *T) MethodName(argType T1, replyType *T2) error
 
+方法也可以多接收一个 context.Context 作为第一个参数，
+通过 ConnFromContext 拿到所在连接，用来向客户端推送消息。
+
+func (t *T) MethodName(ctx context.Context, argType T1, replyType *T2) error
+
 这个方法的第一个参数代表调用者(client)提供的参数，
 第二个参数代表要返回给调用者的计算结果，
 
@@ -64,31 +71,68 @@ const (
 )
 
 var typeOfError = reflect.TypeOf((*error)(nil)).Elem()
+var typeOfContext = reflect.TypeOf((*context.Context)(nil)).Elem()
 
 //方法 handler
 type methodType struct {
 	sync.Mutex
 	method    reflect.Method
+	withCtx   bool         //第一个参数是 context.Context
+	stub      MethodStub   //生成的分发函数，为空时使用反射
 	ArgType   reflect.Type //T1
 	ReplyType reflect.Type //T2
 	numCalls  uint         //调用次数
+
+	deprecated      string //废弃提示，为空表示未废弃
+	deprecatedCalls uint
+
+	argPool   sync.Pool //*T1 或 T1 本身（T1 为指针时）
+	replyPool sync.Pool //*T2
 }
 
 //服务实例 controller 或者 receiver
 type service struct {
 	name   string
+	rcvri  interface{}            // controller本身，传给生成的 stub
 	rcvr   reflect.Value          // controller的动态值
 	typ    reflect.Type           // controller的动态类型
 	method map[string]*methodType //注册方法
+
+	methodFold map[string]*methodType //小写方法名索引
 }
 
 //rpc服务器 router
 type Server struct {
 	serviceMap sync.Map
-	reqLock    sync.Mutex
-	freeReq    *Request
-	respLock   sync.Mutex
-	freeResp   *Response
+	reqPool    sync.Pool
+	respPool   sync.Pool
+	pooling    bool             //参数和结果值是否复用
+	compress   *CompressOptions //非空时 ServeConn 进行压缩协商
+
+	idleTimeout  time.Duration
+	readTimeout  time.Duration
+	writeTimeout time.Duration
+
+	maxHeaderSize int
+	maxBodySize   int
+
+	aliases         sync.Map //别名 -> *methodRef
+	serviceFold     sync.Map //小写服务名 -> *service，有歧义时为 nil
+	caseInsensitive bool
+	defaultVersions sync.Map //服务名 -> SetDefaultVersion 设置的版本
+	firstVersions   sync.Map //服务名 -> 最先注册的版本
+
+	announcer announcer //服务发现
+	recorder  *Recorder //非空时录制所有连接
+	faults    faultInjector
+
+	idempotency      IdempotencyStore //非空时处理请求元数据中的幂等键
+	idempotencyScope func(ctx context.Context) string
+	jobs             *Jobs //非空时支持异步调用
+	authorizer       Authorizer
+
+	conns    connTable
+	breakers breakerList
 }
 
 func NewServer() *Server {
@@ -145,6 +189,7 @@ func (server *Server) register(rcrv interface{}, name string, useName bool) erro
 
 	s.typ = reflect.TypeOf(rcrv)
 	s.rcvr = reflect.ValueOf(rcrv)
+	s.rcvri = rcrv
 
 	//Indirect returns the value that v points to.
 	//返回其（称之为receiver或者controller）指向值的类型名称 如返回A而非返回*
@@ -162,6 +207,13 @@ func (server *Server) register(rcrv interface{}, name string, useName bool) erro
 		return errors.New(s)
 	}
 
+	//带命名空间的名字不能有空的段，例如 "billing..Invoice"
+	if useName && !validServiceName(sname) {
+		s := "rpc.Register: service name " + sname + " is ill-formed"
+		log.Print(s)
+		return errors.New(s)
+	}
+
 	//未指定名称且结构体小写; 例如 type a struct{}
 	if !useName && !isExported(sname) {
 		s := "rpc.Register: type " + sname + "is not exported"
@@ -190,10 +242,19 @@ func (server *Server) register(rcrv interface{}, name string, useName bool) erro
 		return errors.New(str)
 	}
 
+	s.methodFold = foldMethods(s.method)
+	s.attachStubs()
+
 	//相当于注册controller到router中
 	if _, dup := server.serviceMap.LoadOrStore(sname, s); dup {
 		return errors.New("rpc: service has already defined : " + sname)
 	}
+	if _, dup := server.serviceFold.LoadOrStore(strings.ToLower(sname), s); dup {
+		server.serviceFold.Store(strings.ToLower(sname), (*service)(nil))
+	}
+	if base, version := splitVersion(sname); version != "" {
+		server.firstVersions.LoadOrStore(base, version)
+	}
 	return nil
 }
 
@@ -213,8 +274,12 @@ func suitableMethods(typ reflect.Type, reportErr bool) map[string]*methodType {
 			continue
 		}
 
-		//0 caller 1 arg 2 reply
-		if mtype.NumIn() != 3 {
+		//0 caller 1 arg 2 reply，有 context 时参数依次后移
+		in := 1
+		if mtype.NumIn() == 4 && mtype.In(1) == typeOfContext {
+			in = 2
+		}
+		if mtype.NumIn() != in+2 {
 			if reportErr {
 				log.Printf("rpc.Register: method %q has %d input paramters;needs exactly three \n", mname, mtype.NumIn())
 			}
@@ -222,7 +287,7 @@ func suitableMethods(typ reflect.Type, reportErr bool) map[string]*methodType {
 		}
 
 		//参数是可导出或者内建
-		argType := mtype.In(1)
+		argType := mtype.In(in)
 		if !isExportedOrBuiltinType(argType) {
 			if reportErr {
 				log.Printf("rpc.Register: argument type of method %q is not exported or builtin: %q \n ", mname, argType)
@@ -231,7 +296,7 @@ func suitableMethods(typ reflect.Type, reportErr bool) map[string]*methodType {
 		}
 
 		//结果可导出
-		replyType := mtype.In(2)
+		replyType := mtype.In(in + 1)
 		if !isExportedOrBuiltinType(argType) {
 			if reportErr {
 				log.Printf("rpc.Register: reply type of method %q is not exported or builtin: %q \n ", mname, replyType)
@@ -264,6 +329,7 @@ func suitableMethods(typ reflect.Type, reportErr bool) map[string]*methodType {
 
 		methods[mname] = &methodType{
 			method:    method,
+			withCtx:   in == 2,
 			ArgType:   argType,
 			ReplyType: replyType,
 		}
@@ -280,30 +346,23 @@ var invalidRequest = struct{}{}
 type Request struct {
 	ServiceMethod string
 	Seq           uint64
-	next          *Request
+	Meta          Metadata
+
+	replyMeta Metadata //随响应发送的元数据
 }
 
 //获得一个指向Request{}的指针
 func (server *Server) getRequest() *Request {
-	server.reqLock.Lock()
-	req := server.freeReq
-	if req == nil {
-		req = new(Request)
-	} else {
-		//server.freeReq指向req的next
-		server.freeReq = req.next
+	if req, ok := server.reqPool.Get().(*Request); ok {
 		*req = Request{}
+		return req
 	}
-	server.reqLock.Unlock()
-	return req
+	return new(Request)
 }
 
-//设置server的freeReq
+//放回 server 的 reqPool
 func (server *Server) freeRequest(req *Request) {
-	server.reqLock.Lock()
-	req.next = server.freeReq
-	server.freeReq = req
-	server.reqLock.Unlock()
+	server.reqPool.Put(req)
 }
 
 /*
@@ -314,28 +373,29 @@ type Response struct {
 	ServiceMethod string
 	Seq           uint64
 	Error         string
-	next          *Response
+	Meta          Metadata
+
+	callErr error //客户端编解码器给出的传输错误，只影响这一次调用，不是 ServerError
 }
 
 //get a empty response
 func (server *Server) getResponse() *Response {
-	server.respLock.Lock()
-	resp := server.freeResp
-	if resp == nil {
-		resp = new(Response)
-	} else {
-		server.freeResp = resp.next
+	if resp, ok := server.respPool.Get().(*Response); ok {
 		*resp = Response{}
+		return resp
 	}
-	server.respLock.Unlock()
-	return resp
+	return new(Response)
 }
 
 func (server *Server) freeResponse(resp *Response) {
-	server.respLock.Lock()
-	resp.next = server.freeResp
-	server.freeResp = resp
-	server.respLock.Unlock()
+	server.respPool.Put(resp)
+}
+
+//等正在写的响应写完再关闭，编解码器的关闭和写不能并发
+func closeCodec(sending *sync.Mutex, codec ServerCodec) error {
+	sending.Lock()
+	defer sending.Unlock()
+	return codec.Close()
 }
 
 func (server *Server) sendResponse(sending *sync.Mutex, req *Request, reply interface{}, codec ServerCodec, errmsg string) {
@@ -346,10 +406,11 @@ func (server *Server) sendResponse(sending *sync.Mutex, req *Request, reply inte
 		reply = invalidRequest
 	}
 	resp.Seq = req.Seq
+	resp.Meta = req.replyMeta
 
 	sending.Lock()
 	err := codec.WriteResponse(resp, reply)
-	if debugLog && err != nil {
+	if debugLog.Load() && err != nil {
 		log.Println("rpc: writing response error: ", err)
 	}
 	sending.Unlock()
@@ -365,25 +426,97 @@ func (m *methodType) Numcalls() uint {
 }
 
 //参数调用，写入结果
-func (s *service) call(server *Server, sending *sync.Mutex, wg *sync.WaitGroup, mtype *methodType, req *Request, argv, replyv reflect.Value, codec ServerCodec) {
+func (s *service) call(server *Server, sending *sync.Mutex, wg *sync.WaitGroup, mtype *methodType, req *Request, argv, replyv reflect.Value, codec ServerCodec, ctx context.Context) {
 	if wg != nil {
 		defer wg.Done()
 	}
 	mtype.Lock()
 	mtype.numCalls++
+	warning := mtype.deprecated
+	if warning != "" {
+		mtype.deprecatedCalls++
+	}
+	n := mtype.deprecatedCalls
 	mtype.Unlock()
+	if warning != "" {
+		server.deprecatedCall(req, warning, n)
+	}
+
+	ctx = requestContext(ctx, req)
 
-	f := mtype.method.Func
+	var errInter interface{}
+	dup, finish := server.beginIdempotent(ctx, req, s.name+"."+mtype.method.Name, replyv)
+	var fault *Fault
+	if _, admin := s.rcvri.(*FaultService); !admin && dup == nil {
+		//按解析后的名字匹配，别名和大小写不同的写法命中同样的规则
+		fault = server.faults.inject(ctx, s.name+"."+mtype.method.Name)
+	}
+	switch {
+	case dup != nil:
+		//重复的请求，结果已经解码到 replyv
+		if dup.Error != "" {
+			errInter = errors.New(dup.Error)
+		}
+	case fault != nil && fault.Drop:
+		if finish != nil {
+			finish("", false)
+		}
+		server.freeRequest(req)
+		server.freeValues(mtype, argv, replyv)
+		closeCodec(sending, codec)
+		return
+	case fault != nil && fault.Error != "":
+		errInter = errors.New(fault.Error)
+		//方法没有执行，不作为这个幂等键的结果
+		if finish != nil {
+			finish("", false)
+			finish = nil
+		}
+	case mtype.stub != nil:
+		//stub 接收的参数总是指针
+		argp := argv
+		if mtype.ArgType.Kind() != reflect.Ptr {
+			argp = argv.Addr()
+		}
+		if err := mtype.stub(s.rcvri, ctx, argp.Interface(), replyv.Interface()); err != nil {
+			errInter = err
+		}
+	default:
+		f := mtype.method.Func
 
-	returnValues := f.Call([]reflect.Value{s.rcvr, argv, replyv})
+		var returnValues []reflect.Value
+		if mtype.withCtx {
+			returnValues = f.Call([]reflect.Value{s.rcvr, reflect.ValueOf(ctx), argv, replyv})
+		} else {
+			returnValues = f.Call([]reflect.Value{s.rcvr, argv, replyv})
+		}
+		errInter = returnValues[0].Interface()
+	}
 
-	errInter := returnValues[0].Interface()
 	errmsg := ""
 	if errInter != nil {
 		errmsg = errInter.(error).Error()
 	}
+	if finish != nil {
+		finish(errmsg, true)
+	}
 	server.sendResponse(sending, req, replyv.Interface(), codec, errmsg)
 	server.freeRequest(req)
+
+	//响应写完后参数和结果才能复用
+	server.freeValues(mtype, argv, replyv)
+}
+
+func (server *Server) freeValues(mtype *methodType, argv, replyv reflect.Value) {
+	if !server.pooling {
+		return
+	}
+	if mtype.ArgType.Kind() == reflect.Ptr {
+		mtype.freeArg(argv)
+	} else {
+		mtype.freeArg(argv.Addr())
+	}
+	mtype.freeReply(replyv)
 }
 
 //服务编解码器：
@@ -407,22 +540,38 @@ type ServerCodec interface {
 //实现 ServerCodec 接口
 type gobServerCodec struct {
 	rwc    io.ReadWriteCloser
+	lim    *gobLimitReader
 	dec    *gob.Decoder
 	enc    *gob.Encoder
-	encBuf *bufio.Writer
+	encBuf flushWriter
 	closed bool
+
+	maxHeader int
+	maxBody   int
 }
 
 //
 func (c *gobServerCodec) ReadRequestHeader(r *Request) error {
-	return c.dec.Decode(r)
+	c.lim.reset("header", c.maxHeader)
+	err := c.dec.Decode(r)
+	if e, ok := err.(*FrameTooLargeError); ok {
+		//请求头没有解码，Seq 不可信，回复可能落到别的调用上，只能关闭连接
+		return errors.New("rpc: cannot read request header: " + e.Error())
+	}
+	return err
 }
 
 //
 func (c *gobServerCodec) ReadRequestBody(body interface{}) error {
+	c.lim.reset("body", c.maxBody)
 	return c.dec.Decode(body)
 }
 
+func (c *gobServerCodec) SetSizeLimits(maxHeader, maxBody int) {
+	c.maxHeader = maxHeader
+	c.maxBody = maxBody
+}
+
 //回应encode到c.enc中
 func (c *gobServerCodec) WriteResponse(r *Response, body interface{}) (err error) {
 	if err = c.enc.Encode(r); err != nil {
@@ -446,6 +595,14 @@ func (c *gobServerCodec) WriteResponse(r *Response, body interface{}) (err error
 	return c.encBuf.Flush()
 }
 
+func (c *gobServerCodec) SetReadDeadline(t time.Time) error {
+	return SetReadDeadline(c.rwc, t)
+}
+
+func (c *gobServerCodec) SetWriteDeadline(t time.Time) error {
+	return SetWriteDeadline(c.rwc, t)
+}
+
 //幂等
 func (c *gobServerCodec) Close() error {
 	if c.closed {
@@ -457,64 +614,135 @@ func (c *gobServerCodec) Close() error {
 
 //采用 gobServerCodec 处理连接
 func (server *Server) ServeConn(conn io.ReadWriteCloser) {
-	buf := bufio.NewWriter(conn)
-	srv := &gobServerCodec{
+	peer := PeerOf(conn)
+	if server.compress != nil {
+		c, err := ServerHandshake(conn, server.compress)
+		if err != nil {
+			log.Println("rpc: compression handshake: ", err)
+			conn.Close()
+			return
+		}
+		server.limitDecompressed(c)
+		conn = c
+	}
+
+	server.ServeCodecPeer(NewGobServerCodec(conn), peer)
+}
+
+//gob 编码的 ServerCodec，ServeConn 使用的就是它
+func NewGobServerCodec(conn io.ReadWriteCloser) ServerCodec {
+	buf := newWriteBuffer(conn)
+	lim := newGobLimitReader(conn)
+	return &gobServerCodec{
 		rwc:    conn,
-		dec:    gob.NewDecoder(conn),
-		enc:    gob.NewEncoder(conn),
+		lim:    lim,
+		dec:    gob.NewDecoder(lim),
+		enc:    gob.NewEncoder(buf),
 		encBuf: buf,
 	}
-
-	server.ServeCodec(srv)
 }
 
 //指定ServerCodec处理
 func (server *Server) ServeCodec(codec ServerCodec) {
+	server.ServeCodecPeer(codec, nil)
+}
+
+//同 ServeCodec，处理函数可以通过 PeerFromContext 取得 peer
+func (server *Server) ServeCodecPeer(codec ServerCodec, peer *Peer) {
+	server.applyLimits(codec)
+	codec = server.withTimeouts(codec)
+	if server.recorder != nil {
+		codec = RecordingCodec(codec, server.recorder)
+	}
 	sending := new(sync.Mutex)
+	conn := newConn(codec, sending)
+	conn.peer = peer
+	server.conns.add(conn)
+	defer server.conns.remove(conn)
 
 	wg := new(sync.WaitGroup)
 
 	for {
 		service, mtype, req, argv, replyv, keepReading, err := server.readRequest(codec)
 		if err != nil {
-			if debugLog && err != io.EOF {
+			if debugLog.Load() && err != io.EOF {
 				log.Println("rpc: ", err)
 			}
 
-			if !keepReading {
-				break
-			}
-
+			//请求过大时先回复错误再关闭连接
 			if req != nil {
 				server.sendResponse(sending, req, invalidRequest, codec, err.Error())
 				server.freeRequest(req)
 			}
+
+			if !keepReading {
+				break
+			}
+			continue
+		}
+		if err := server.authorize(conn.ctx, service, mtype, req); err != nil {
+			server.sendResponse(sending, req, invalidRequest, codec, err.Error())
+			server.freeValues(mtype, argv, replyv)
+			server.freeRequest(req)
+			continue
+		}
+		if req.Meta[AsyncKey] != "" {
+			server.startJob(conn.ctx, service, mtype, req, argv, replyv, codec, sending)
 			continue
 		}
 		wg.Add(1)
-		go service.call(server, sending, wg, mtype, req, argv, replyv, codec)
+		atomic.AddInt64(&conn.inFlight, 1)
+		go func() {
+			service.call(server, sending, wg, mtype, req, argv, replyv, codec, conn.ctx)
+			atomic.AddInt64(&conn.inFlight, -1)
+		}()
 
 	}
 	wg.Wait()
+	conn.close()
 	codec.Close()
 }
 
 //ServeRequest类似于ServeCodec，但同步服务于单个请求。
 //完成后不会关闭编解码器。
 func (server *Server) ServerRequest(codec ServerCodec) error {
+	return server.serveRequest(codec, nil)
+}
+
+//同 ServerRequest，处理函数可以通过 PeerFromContext 取得 peer
+func (server *Server) ServeRequestPeer(codec ServerCodec, peer *Peer) error {
+	return server.serveRequest(codec, peer)
+}
+
+func (server *Server) serveRequest(codec ServerCodec, peer *Peer) error {
+	server.applyLimits(codec)
+	codec = server.withTimeouts(codec)
+	if server.recorder != nil {
+		codec = RecordingCodec(codec, server.recorder)
+	}
 	sending := new(sync.Mutex)
-	service, mtype, req, argv, replyv, keepReading, err := server.readRequest(codec)
+	conn := newConn(codec, sending)
+	conn.peer = peer
+	defer conn.close()
+	service, mtype, req, argv, replyv, _, err := server.readRequest(codec)
 	if err != nil {
-		if !keepReading {
-			return err
-		}
 		if req != nil {
 			server.sendResponse(sending, req, invalidRequest, codec, err.Error())
 			server.freeRequest(req)
 		}
 		return err
 	}
-	service.call(server, sending, nil, mtype, req, argv, replyv, codec)
+	if err := server.authorize(conn.ctx, service, mtype, req); err != nil {
+		server.sendResponse(sending, req, invalidRequest, codec, err.Error())
+		server.freeValues(mtype, argv, replyv)
+		server.freeRequest(req)
+		return err
+	}
+	if req.Meta[AsyncKey] != "" {
+		server.startJob(conn.ctx, service, mtype, req, argv, replyv, codec, sending)
+		return nil
+	}
+	service.call(server, sending, nil, mtype, req, argv, replyv, codec, conn.ctx)
 	return nil
 }
 
@@ -532,30 +760,25 @@ func (server *Server) readRequest(codec ServerCodec) (service *service, mtype *m
 		return
 	}
 
-	argIsValue := false
-
-	if mtype.ArgType.Kind() == reflect.Ptr {
-		argv = reflect.New(mtype.ArgType)
-		argIsValue = true
-	}
+	//参数为指针时直接解码到新分配的 T，否则解码到 *T 再取值
+	argv = mtype.newArg(server.pooling)
 
 	if err = codec.ReadRequestBody(argv.Interface()); err != nil {
+		if server.pooling {
+			mtype.freeArg(argv)
+		}
+		//请求体过大时流已经无法继续
+		if _, ok := err.(*FrameTooLargeError); ok {
+			keepReading = false
+		}
 		return
 	}
 
-	if argIsValue {
+	if mtype.ArgType.Kind() != reflect.Ptr {
 		argv = argv.Elem()
 	}
 
-	replyv = reflect.New(mtype.ReplyType.Elem())
-
-	switch mtype.ReplyType.Elem().Kind() {
-	case reflect.Map:
-		replyv.Elem().Set(reflect.MakeMap(mtype.ReplyType.Elem()))
-	case reflect.Slice:
-		replyv.Elem().Set(reflect.MakeSlice(mtype.ReplyType.Elem(), 0, 0))
-	}
-
+	replyv = mtype.newReply(server.pooling)
 	return
 
 }
@@ -567,6 +790,10 @@ func (server *Server) readRequestHeader(codec ServerCodec) (svc *service, mtype
 	//从连接中读请求数据解码到request中
 	err = codec.ReadRequestHeader(req)
 	if err != nil {
+		//请求头过大且编解码器给出了可信的 Seq：保留 req 用于回复错误，不再继续读
+		if _, ok := err.(*FrameTooLargeError); ok {
+			return
+		}
 		req = nil
 		if err == io.EOF || err == io.ErrUnexpectedEOF {
 			return
@@ -577,30 +804,20 @@ func (server *Server) readRequestHeader(codec ServerCodec) (svc *service, mtype
 
 	keepReading = true
 
-	dot := strings.LastIndex(req.ServiceMethod, ".")
-	if dot < 0 {
-		err = errors.New("roc : service/method request ill-formed: " + req.ServiceMethod)
+	//保留给服务端推送的 Seq 不能用于请求
+	if IsNotifySeq(req.Seq) {
+		err = errors.New("rpc: request uses reserved seq")
 		return
 	}
 
-	serviceName := req.ServiceMethod[:dot]
-	methodName := req.ServiceMethod[dot:]
-
-	svci, ok := server.serviceMap.Load(serviceName)
-	if !ok {
-		err = errors.New("rpc: can't find service " + req.ServiceMethod)
-		return
-	}
-	svc = svci.(*service)
-	mtype = svc.method[methodName]
-	if mtype == nil {
-		err = errors.New("rpc: can't find method " + req.ServiceMethod)
-	}
+	svc, mtype, err = server.lookupVersion(req.ServiceMethod, req.Meta[VersionKey])
 	return
 }
 
 //从端口监听中获取连接
 func (server *Server) Accept(lis net.Listener) {
+	leave := server.announce(lis.Addr())
+	defer leave()
 	for {
 		conn, err := lis.Accept()
 		if err != nil {
@@ -634,7 +851,8 @@ func (server *Server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 
 func (server *Server) HandleHTTP(rpcPath, debugPath string) {
 	http.Handle(rpcPath, server)
-	http.Handle(debugPath, debugHTTP{server})
+	http.Handle(debugPath, &debugHTTP{server})
+	http.Handle(debugPath+"/openrpc", openRPCHTTP{server})
 }
 
 //公共函数提供给外界
@@ -648,6 +866,10 @@ func ServeConn(conn io.ReadWriteCloser) {
 	DefaultServer.ServeConn(conn)
 }
 
+func ServeCodec(codec ServerCodec) {
+	DefaultServer.ServeCodec(codec)
+}
+
 func Accept(lis net.Listener) { DefaultServer.Accept(lis) }
 
 func HandleHTTP() {
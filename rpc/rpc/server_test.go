@@ -0,0 +1,362 @@
+package rpc
+
+import (
+	"bytes"
+	"encoding/gob"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+type Echo int
+
+func (e *Echo) Say(args *string, reply *string) error {
+	*reply = *args
+	return nil
+}
+
+//只返回给定请求头的编解码器
+type headerCodec struct {
+	req Request
+}
+
+func (c *headerCodec) ReadRequestHeader(r *Request) error {
+	*r = c.req
+	return nil
+}
+
+func (c *headerCodec) ReadRequestBody(interface{}) error          { return nil }
+func (c *headerCodec) WriteResponse(*Response, interface{}) error { return nil }
+func (c *headerCodec) Close() error                               { return nil }
+
+func FuzzReadRequestHeader(f *testing.F) {
+	server := NewServer()
+	server.Register(new(Echo))
+
+	for _, seed := range []string{"", ".", "Echo.Say", "Echo.", ".Say", "Echo..Say", "Echo.Say.", "RPC.Ping", "a.b.c.d", "\xff.\xfe"} {
+		f.Add(seed, uint64(1))
+	}
+	f.Add("Echo.Say", NotifySeqBit|1)
+
+	f.Fuzz(func(t *testing.T, serviceMethod string, seq uint64) {
+		codec := &headerCodec{req: Request{ServiceMethod: serviceMethod, Seq: seq}}
+		svc, mtype, req, keepReading, err := server.readRequestHeader(codec)
+		if !keepReading {
+			t.Fatalf("%q: header was decoded, should keep reading", serviceMethod)
+		}
+		if req == nil || req.ServiceMethod != serviceMethod {
+			t.Fatalf("%q: request not returned", serviceMethod)
+		}
+		if err != nil {
+			return
+		}
+		if IsNotifySeq(seq) {
+			t.Fatalf("%q: reserved seq %#x accepted", serviceMethod, seq)
+		}
+		if svc == nil || mtype == nil {
+			t.Fatalf("%q: nil service or method without error", serviceMethod)
+		}
+		if want := svc.name + "." + mtype.method.Name; serviceMethod != want {
+			t.Fatalf("%q resolved to %q", serviceMethod, want)
+		}
+	})
+}
+
+func TestMaxBodySize(t *testing.T) {
+	var buf bytes.Buffer
+	enc := gob.NewEncoder(&buf)
+	small := "hello"
+	big := strings.Repeat("x", 4096)
+	for i, body := range []string{small, big} {
+		enc.Encode(&Request{ServiceMethod: "Echo.Say", Seq: uint64(i)})
+		enc.Encode(body)
+	}
+
+	lim := newGobLimitReader(&buf)
+	codec := &gobServerCodec{rwc: nopCloser{}, lim: lim, dec: gob.NewDecoder(lim)}
+	codec.SetSizeLimits(0, 1024)
+
+	var req Request
+	var s string
+	if err := codec.ReadRequestHeader(&req); err != nil {
+		t.Fatal(err)
+	}
+	if err := codec.ReadRequestBody(&s); err != nil || s != small {
+		t.Fatalf("small request: %q, %v", s, err)
+	}
+
+	if err := codec.ReadRequestHeader(&req); err != nil || req.Seq != 1 {
+		t.Fatalf("second header: %+v, %v", req, err)
+	}
+	err := codec.ReadRequestBody(&s)
+	if err == nil || !strings.Contains(err.Error(), "exceeds 1024 bytes") {
+		t.Fatalf("oversized request: got %v", err)
+	}
+}
+
+type nopCloser struct{ io.ReadWriter }
+
+func (nopCloser) Close() error { return nil }
+
+func TestGobLimitReaderRejectsDeclaredLength(t *testing.T) {
+	var buf bytes.Buffer
+	gob.NewEncoder(&buf).Encode(strings.Repeat("y", 1000))
+
+	lim := newGobLimitReader(&buf)
+	lim.reset("body", 100)
+	var s string
+	err := gob.NewDecoder(lim).Decode(&s)
+	if _, ok := err.(*FrameTooLargeError); !ok {
+		t.Fatalf("got %v, want FrameTooLargeError", err)
+	}
+
+	//没有限制时正常解码
+	buf.Reset()
+	gob.NewEncoder(&buf).Encode("ok")
+	lim = newGobLimitReader(io.MultiReader(&buf))
+	lim.reset("body", 0)
+	if err := gob.NewDecoder(lim).Decode(&s); err != nil || s != "ok" {
+		t.Fatalf("unlimited decode: %q, %v", s, err)
+	}
+}
+
+type Invoice int
+
+func (i *Invoice) Create(args *string, reply *string) error { return nil }
+func (i *Invoice) Cancel(args *string, reply *string) error { return nil }
+
+//只差大小写的两个方法
+type Mixed int
+
+func (m *Mixed) Get(args *string, reply *string) error { return nil }
+func (m *Mixed) GET(args *string, reply *string) error { return nil }
+
+func TestLookup(t *testing.T) {
+	newServer := func(caseInsensitive bool) *Server {
+		server := NewServer()
+		server.Register(new(Echo))
+		server.Register(new(Mixed))
+		server.RegisterName(new(Invoice), "billing.v2.Invoice")
+		server.RegisterName(new(Invoice), "billing.v1.Invoice")
+		if err := server.RegisterAlias("Invoice.New", "billing.v2.Invoice.Create"); err != nil {
+			t.Fatal(err)
+		}
+		if err := server.RegisterAlias("billing.v2.Invoice.Void", "billing.v2.Invoice.Cancel"); err != nil {
+			t.Fatal(err)
+		}
+		server.SetCaseInsensitive(caseInsensitive)
+		server.SetKeepAlive(true)
+		return server
+	}
+
+	tests := []struct {
+		serviceMethod   string
+		caseInsensitive bool
+		service         string //空表示应当失败
+		method          string
+		err             string
+	}{
+		{"Echo.Say", false, "Echo", "Say", ""},
+		{"billing.v2.Invoice.Create", false, "billing.v2.Invoice", "Create", ""},
+		{"billing.v1.Invoice.Cancel", false, "billing.v1.Invoice", "Cancel", ""},
+		{"RPC.Ping", false, "RPC", "Ping", ""},
+		{"Echo", false, "", "", "ill-formed"},
+		{"Echo.", false, "", "", "ill-formed"},
+		{".Say", false, "", "", "ill-formed"},
+		{"", false, "", "", "ill-formed"},
+		{"billing.Invoice.Create", false, "", "", "can't find service"},
+		{"Echo.Shout", false, "", "", "can't find method"},
+		{"Echo.say", false, "", "", "can't find method"},
+		{"echo.Say", false, "", "", "can't find service"},
+		{"Invoice.New", false, "billing.v2.Invoice", "Create", ""},
+		{"billing.v2.Invoice.Void", false, "billing.v2.Invoice", "Cancel", ""},
+		{"invoice.new", false, "", "", "can't find service"},
+		{"echo.say", true, "Echo", "Say", ""},
+		{"BILLING.V2.INVOICE.create", true, "billing.v2.Invoice", "Create", ""},
+		{"Mixed.Get", true, "Mixed", "Get", ""},
+		{"Mixed.GET", true, "Mixed", "GET", ""},
+		{"Mixed.get", true, "", "", "can't find method"},
+		{"echo.shout", true, "", "", "can't find method"},
+	}
+
+	servers := map[bool]*Server{false: newServer(false), true: newServer(true)}
+	for _, tt := range tests {
+		svc, mtype, err := servers[tt.caseInsensitive].lookup(tt.serviceMethod)
+		if tt.service == "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("lookup(%q, fold=%v): err %v, want %q", tt.serviceMethod, tt.caseInsensitive, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("lookup(%q, fold=%v): %v", tt.serviceMethod, tt.caseInsensitive, err)
+			continue
+		}
+		if svc.name != tt.service || mtype.method.Name != tt.method {
+			t.Errorf("lookup(%q, fold=%v) = %s.%s, want %s.%s", tt.serviceMethod, tt.caseInsensitive, svc.name, mtype.method.Name, tt.service, tt.method)
+		}
+	}
+}
+
+func TestRegisterNames(t *testing.T) {
+	tests := []struct {
+		name string
+		ok   bool
+	}{
+		{"Invoice", true},
+		{"billing.v2.Invoice", true},
+		{"lower", true},
+		{"", false},
+		{".Invoice", false},
+		{"billing..Invoice", false},
+		{"billing.", false},
+		{"Invoice@v2", true},
+		{"billing.Invoice@2024-01", true},
+		{"Invoice@", false},
+		{"Invoice@v1@v2", false},
+		{"Invoice@v2.1", false},
+		{"@v2", false},
+	}
+	for _, tt := range tests {
+		err := NewServer().RegisterName(new(Invoice), tt.name)
+		if (err == nil) != tt.ok {
+			t.Errorf("RegisterName(%q): %v", tt.name, err)
+		}
+	}
+
+	server := NewServer()
+	server.Register(new(Echo))
+	tests2 := []struct {
+		alias, target string
+		ok            bool
+	}{
+		{"Echo.Hello", "Echo.Say", true},
+		{"Echo.Hello", "Echo.Say", false}, //重复
+		{"Hello", "Echo.Say", false},
+		{"Echo.Hi", "Echo.Missing", false},
+	}
+	for _, tt := range tests2 {
+		err := server.RegisterAlias(tt.alias, tt.target)
+		if (err == nil) != tt.ok {
+			t.Errorf("RegisterAlias(%q, %q): %v", tt.alias, tt.target, err)
+		}
+	}
+}
+
+func TestVersionRouting(t *testing.T) {
+	server := NewServer()
+	server.RegisterName(new(Echo), "Echo@v1")
+	server.RegisterName(new(Echo), "Echo@v2")
+	server.RegisterName(new(Invoice), "Invoice")
+	server.RegisterName(new(Invoice), "Invoice@v2")
+	server.RegisterName(new(Mixed), "Mixed@v1")
+	server.RegisterName(new(Mixed), "Mixed@v2")
+	server.SetDefaultVersion("Mixed", "v2")
+
+	tests := []struct {
+		serviceMethod, version string
+		service                string //空表示应当失败
+	}{
+		{"Echo@v2.Say", "", "Echo@v2"},
+		{"Echo.Say", "", "Echo@v1"}, //最先注册的版本
+		{"Echo.Say", "v2", "Echo@v2"},
+		{"Echo.Say", "v3", ""},
+		{"Echo@v1.Say", "v2", "Echo@v1"}, //名字中的版本优先
+		{"Invoice.Create", "", "Invoice"},
+		{"Invoice.Create", "v2", "Invoice@v2"},
+		{"Mixed.Get", "", "Mixed@v2"},
+		{"Mixed.Get", "v1", "Mixed@v1"},
+	}
+	for _, tt := range tests {
+		svc, _, err := server.lookupVersion(tt.serviceMethod, tt.version)
+		if tt.service == "" {
+			if err == nil {
+				t.Errorf("lookupVersion(%q, %q) = %s, want error", tt.serviceMethod, tt.version, svc.name)
+			}
+			continue
+		}
+		if err != nil || svc.name != tt.service {
+			t.Errorf("lookupVersion(%q, %q) = %v, %v, want %s", tt.serviceMethod, tt.version, svc, err, tt.service)
+		}
+	}
+}
+
+func TestDeprecate(t *testing.T) {
+	server := NewServer()
+	server.RegisterName(new(Echo), "Echo@v1")
+	if err := server.Deprecate("Echo@v1.Say", "use Echo@v2.Say"); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Deprecate("Echo@v1.Shout", ""); err == nil {
+		t.Error("deprecating unknown method succeeded")
+	}
+
+	cli, srv := net.Pipe()
+	go server.ServeConn(srv)
+	client := NewClient(cli)
+	defer client.Close()
+
+	for i := 0; i < 2; i++ {
+		var reply string
+		call := <-client.Go("Echo.Say", "hi", &reply, nil).Done
+		if call.Error != nil || reply != "hi" {
+			t.Fatalf("Echo.Say = %q, %v", reply, call.Error)
+		}
+		if w := call.ReplyMeta[WarningKey]; w != "Echo@v1.Say is deprecated: use Echo@v2.Say" {
+			t.Errorf("warning = %q", w)
+		}
+	}
+	if n := server.DeprecatedCalls()["Echo@v1.Say"]; n != 2 {
+		t.Errorf("deprecated calls = %d, want 2", n)
+	}
+}
+
+func TestMaxBodySizeClosesConn(t *testing.T) {
+	server := NewServer()
+	server.Register(new(Echo))
+	server.SetMaxBodySize(1024)
+
+	cli, srv := net.Pipe()
+	go server.ServeConn(srv)
+	client := NewClient(cli)
+	defer client.Close()
+
+	var reply string
+	small := "hello"
+	if err := client.Call("Echo.Say", &small, &reply); err != nil || reply != small {
+		t.Fatalf("small request: %q, %v", reply, err)
+	}
+
+	big := strings.Repeat("x", 4096)
+	err := client.Call("Echo.Say", &big, &reply)
+	if err == nil || !strings.Contains(err.Error(), "exceeds 1024 bytes") {
+		t.Fatalf("oversized request: got %v", err)
+	}
+
+	//连接已被服务端关闭
+	if err := client.Call("Echo.Say", &small, &reply); err == nil {
+		t.Fatal("connection should be closed after oversized request")
+	}
+}
+
+//请求头过大时 Seq 不可信，不回复直接关闭连接
+func TestMaxHeaderSizeClosesConn(t *testing.T) {
+	server := NewServer()
+	server.Register(new(Echo))
+	server.SetMaxHeaderSize(64)
+
+	cli, srv := net.Pipe()
+	go server.ServeConn(srv)
+	client := NewClient(cli)
+	defer client.Close()
+
+	var reply string
+	err := client.Call("Echo."+strings.Repeat("x", 100), "hi", &reply)
+	if err == nil {
+		t.Fatal("oversized header should fail")
+	}
+	if _, ok := err.(ServerError); ok {
+		t.Fatalf("oversized header got a reply: %v", err)
+	}
+}
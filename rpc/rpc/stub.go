@@ -0,0 +1,37 @@
+package rpc
+
+import (
+	"context"
+	"reflect"
+	"sync"
+)
+
+/*
+生成的分发函数：
+cmd/rpcgen 为服务类型生成 MethodStub，在生成文件的 init 中调用 RegisterStubs 登记。
+Register 时如果接收者类型有登记的 stub，调用就不经过 reflect.Value.Call，否则仍然走反射。
+
+stub 收到的 args 和 reply 都是指针：参数类型为 T 时 args 是 *T，为 *T 时就是 *T 本身。
+*/
+
+type MethodStub func(rcvr interface{}, ctx context.Context, args, reply interface{}) error
+
+var stubs sync.Map // reflect.Type -> map[string]MethodStub
+
+//登记接收者类型的 stub，rcvr 只用来取类型，例如 (*Arith)(nil)
+func RegisterStubs(rcvr interface{}, methods map[string]MethodStub) {
+	stubs.Store(reflect.TypeOf(rcvr), methods)
+}
+
+//把登记过的 stub 关联到服务的方法上
+func (s *service) attachStubs() {
+	v, ok := stubs.Load(s.typ)
+	if !ok {
+		return
+	}
+	for name, stub := range v.(map[string]MethodStub) {
+		if m, ok := s.method[name]; ok {
+			m.stub = stub
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package rpc
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+//手写的 stub，和 rpcgen 生成的形式相同
+func registerAdderStubs(called *int) {
+	RegisterStubs((*Adder)(nil), map[string]MethodStub{
+		"Add": func(rcvr interface{}, ctx context.Context, args, reply interface{}) error {
+			if called != nil {
+				*called++
+			}
+			return rcvr.(*Adder).Add(args.(*PoolArgs), reply.(*PoolReply))
+		},
+	})
+}
+
+func TestStubDispatch(t *testing.T) {
+	called := 0
+	registerAdderStubs(&called)
+	defer stubs.Delete(typeOfAdder)
+
+	server := NewServer()
+	server.Register(new(Adder))
+	codec := &memCodec{args: PoolArgs{A: 2, B: 3}}
+	if err := server.ServerRequest(codec); err != nil {
+		t.Fatal(err)
+	}
+	if called != 1 || codec.sum != 5 {
+		t.Fatalf("stub called %d times, sum %d", called, codec.sum)
+	}
+}
+
+var typeOfAdder = reflect.TypeOf((*Adder)(nil))
+
+func BenchmarkDispatch(b *testing.B) {
+	for _, stub := range []bool{false, true} {
+		name := "reflect"
+		if stub {
+			name = "stub"
+			registerAdderStubs(nil)
+		}
+		b.Run(name, func(b *testing.B) {
+			server := NewServer()
+			server.Register(new(Adder))
+			codec := &memCodec{args: PoolArgs{A: 2, B: 3}}
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				server.ServerRequest(codec)
+			}
+		})
+	}
+	stubs.Delete(typeOfAdder)
+}
@@ -0,0 +1,156 @@
+package rpc
+
+import (
+	"time"
+)
+
+/*
+连接超时：
+idle  等待下一个请求头的最长时间，为 0 时使用 read，同 net/http 的 IdleTimeout
+read  读请求体的最长时间
+write 写一个响应的最长时间
+底层连接（或编解码器）实现了 SetReadDeadline/SetWriteDeadline 时才生效，
+超时后读写返回错误，ServeCodec 结束并关闭连接。
+超时的设置必须在开始服务（Accept、ServeConn 等）之前完成，服务中修改是数据竞争。
+开启 SetKeepAlive 后注册内置服务 "RPC"，客户端可以定期调用 RPC.Ping 保持连接。
+*/
+
+type deadliner interface {
+	SetReadDeadline(t time.Time) error
+	SetWriteDeadline(t time.Time) error
+}
+
+func (server *Server) SetIdleTimeout(d time.Duration) {
+	server.idleTimeout = d
+}
+
+func (server *Server) SetReadTimeout(d time.Duration) {
+	server.readTimeout = d
+}
+
+func (server *Server) SetWriteTimeout(d time.Duration) {
+	server.writeTimeout = d
+}
+
+//注册或移除内置服务 "RPC"，负载均衡的健康检查也依赖 RPC.Ping
+func (server *Server) SetKeepAlive(enabled bool) {
+	if enabled {
+		if svci, ok := server.serviceMap.Load("RPC"); ok && isRPCService(svci.(*service)) {
+			return
+		}
+		server.register(new(rpcService), "RPC", true)
+		return
+	}
+	svci, ok := server.serviceMap.Load("RPC")
+	if !ok || !isRPCService(svci.(*service)) {
+		return
+	}
+	server.serviceMap.Delete("RPC")
+	if cur, ok := server.serviceFold.Load("rpc"); ok && cur == svci {
+		server.serviceFold.Delete("rpc")
+	}
+}
+
+func isRPCService(svc *service) bool {
+	_, ok := svc.rcvri.(*rpcService)
+	return ok
+}
+
+//在读写前设置超时的编解码器
+type timeoutCodec struct {
+	ServerCodec
+	conn                                   deadliner
+	idleTimeout, readTimeout, writeTimeout time.Duration
+}
+
+//编解码器支持超时且设置了超时时包装一层
+func (server *Server) withTimeouts(codec ServerCodec) ServerCodec {
+	if server.idleTimeout == 0 && server.readTimeout == 0 && server.writeTimeout == 0 {
+		return codec
+	}
+	d, ok := codec.(deadliner)
+	if !ok {
+		return codec
+	}
+	idle := server.idleTimeout
+	if idle == 0 {
+		idle = server.readTimeout
+	}
+	return &timeoutCodec{
+		ServerCodec:  codec,
+		conn:         d,
+		idleTimeout:  idle,
+		readTimeout:  server.readTimeout,
+		writeTimeout: server.writeTimeout,
+	}
+}
+
+func deadline(d time.Duration) time.Time {
+	if d == 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(d)
+}
+
+func (c *timeoutCodec) ReadRequestHeader(r *Request) error {
+	c.conn.SetReadDeadline(deadline(c.idleTimeout))
+	return c.ServerCodec.ReadRequestHeader(r)
+}
+
+func (c *timeoutCodec) ReadRequestBody(body interface{}) error {
+	c.conn.SetReadDeadline(deadline(c.readTimeout))
+	return c.ServerCodec.ReadRequestBody(body)
+}
+
+func (c *timeoutCodec) WriteResponse(r *Response, body interface{}) error {
+	c.conn.SetWriteDeadline(deadline(c.writeTimeout))
+	err := c.ServerCodec.WriteResponse(r, body)
+	if err != nil {
+		//响应可能只写了一部分，之后的数据无法对齐，关闭连接让读循环结束
+		c.ServerCodec.Close()
+	}
+	return err
+}
+
+//rwc 支持超时时设置读写超时，供各编解码器转发
+func SetReadDeadline(rwc interface{}, t time.Time) error {
+	if d, ok := rwc.(deadliner); ok {
+		return d.SetReadDeadline(t)
+	}
+	return nil
+}
+
+func SetWriteDeadline(rwc interface{}, t time.Time) error {
+	if d, ok := rwc.(deadliner); ok {
+		return d.SetWriteDeadline(t)
+	}
+	return nil
+}
+
+//内置服务 "RPC"，提供心跳，SetKeepAlive 开启
+type rpcService struct{}
+
+//原样返回参数，客户端可以用来做心跳或测量往返时间
+func (s *rpcService) Ping(args int64, reply *int64) error {
+	*reply = args
+	return nil
+}
+
+//发送一次心跳
+func (client *Client) Ping() error {
+	var reply int64
+	return client.Call("RPC.Ping", time.Now().UnixNano(), &reply)
+}
+
+//每隔 interval 发送一次心跳，直到客户端关闭或心跳失败
+func (client *Client) KeepAlive(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if err := client.Ping(); err != nil {
+				return
+			}
+		}
+	}()
+}
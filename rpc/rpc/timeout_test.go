@@ -0,0 +1,122 @@
+package rpc
+
+import (
+	"encoding/gob"
+	"net"
+	"testing"
+	"time"
+)
+
+//ServeConn 应当在 d 内返回
+func serveUntilClosed(t *testing.T, server *Server, conn net.Conn, d time.Duration) {
+	t.Helper()
+	done := make(chan struct{})
+	go func() {
+		server.ServeConn(conn)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(d):
+		t.Fatal("connection not closed by timeout")
+	}
+}
+
+func TestIdleTimeout(t *testing.T) {
+	server := NewServer()
+	server.SetIdleTimeout(50 * time.Millisecond)
+	cli, srv := net.Pipe()
+	defer cli.Close()
+	serveUntilClosed(t, server, srv, time.Second)
+}
+
+//只设置读超时时等待请求头也有超时
+func TestReadTimeoutCoversHeader(t *testing.T) {
+	server := NewServer()
+	server.SetReadTimeout(50 * time.Millisecond)
+	cli, srv := net.Pipe()
+	defer cli.Close()
+	serveUntilClosed(t, server, srv, time.Second)
+}
+
+//客户端不读响应时写超时关闭连接
+func TestWriteTimeout(t *testing.T) {
+	server := NewServer()
+	server.SetKeepAlive(true)
+	server.SetWriteTimeout(50 * time.Millisecond)
+	cli, srv := net.Pipe()
+	defer cli.Close()
+
+	go func() {
+		enc := gob.NewEncoder(cli)
+		enc.Encode(&Request{ServiceMethod: "RPC.Ping", Seq: 1})
+		enc.Encode(int64(1))
+	}()
+	serveUntilClosed(t, server, srv, time.Second)
+}
+
+//记录设置的超时
+type deadlineCodec struct {
+	ServerCodec
+	read, write time.Time
+}
+
+func (c *deadlineCodec) SetReadDeadline(t time.Time) error  { c.read = t; return nil }
+func (c *deadlineCodec) SetWriteDeadline(t time.Time) error { c.write = t; return nil }
+
+func TestTimeoutDeadlines(t *testing.T) {
+	server := NewServer()
+	server.SetIdleTimeout(time.Hour)
+	server.SetReadTimeout(time.Minute)
+	server.SetWriteTimeout(time.Second)
+	dc := &deadlineCodec{ServerCodec: nopServerCodec{}}
+	codec := server.withTimeouts(dc)
+
+	within := func(name string, got time.Time, d time.Duration) {
+		if wait := time.Until(got); wait <= d/2 || wait > d {
+			t.Errorf("%s deadline in %v, want about %v", name, wait, d)
+		}
+	}
+	codec.ReadRequestHeader(new(Request))
+	within("header", dc.read, time.Hour)
+	codec.ReadRequestBody(nil)
+	within("body", dc.read, time.Minute)
+	codec.WriteResponse(new(Response), nil)
+	within("write", dc.write, time.Second)
+}
+
+type nopServerCodec struct{}
+
+func (nopServerCodec) ReadRequestHeader(*Request) error           { return nil }
+func (nopServerCodec) ReadRequestBody(interface{}) error          { return nil }
+func (nopServerCodec) WriteResponse(*Response, interface{}) error { return nil }
+func (nopServerCodec) Close() error                               { return nil }
+
+func TestKeepAlive(t *testing.T) {
+	server := NewServer()
+	if _, _, err := server.lookup("RPC.Ping"); err == nil {
+		t.Fatal("RPC service registered without SetKeepAlive")
+	}
+	server.SetKeepAlive(true)
+	server.SetIdleTimeout(100 * time.Millisecond)
+
+	cli, srv := net.Pipe()
+	go server.ServeConn(srv)
+	client := NewClient(cli)
+	defer client.Close()
+
+	if err := client.Ping(); err != nil {
+		t.Fatal(err)
+	}
+	//心跳间隔小于空闲超时，连接一直保持
+	client.KeepAlive(20 * time.Millisecond)
+	time.Sleep(300 * time.Millisecond)
+	if err := client.Ping(); err != nil {
+		t.Fatalf("connection closed despite keepalive: %v", err)
+	}
+
+	server.SetKeepAlive(false)
+	if _, _, err := server.lookup("RPC.Ping"); err == nil {
+		t.Fatal("RPC service still registered after SetKeepAlive(false)")
+	}
+}
@@ -0,0 +1,118 @@
+package rpc
+
+import (
+	"errors"
+	"log"
+	"strings"
+)
+
+/*
+服务版本：
+RegisterName(rcvr, "Invoice@v2") 注册 Invoice 服务的 v2 版本，同一个服务可以注册多个版本。
+请求可以直接写 "Invoice@v2.Create"，也可以写 "Invoice.Create" 并在元数据 VersionKey 中指定版本；
+没有指定时使用默认版本：SetDefaultVersion 设置的版本，否则是不带版本注册的服务，否则是最先注册的版本。
+
+方法废弃：
+Deprecate 标记的方法照常执行，调用次数被计数并记录日志，响应元数据的 WarningKey 中带有提示。
+*/
+
+const (
+	VersionKey = "version"
+	WarningKey = "warning"
+)
+
+//"Invoice@v2" 分为 "Invoice" 和 "v2"
+func splitVersion(name string) (base, version string) {
+	if at := strings.LastIndex(name, "@"); at >= 0 {
+		return name[:at], name[at+1:]
+	}
+	return name, ""
+}
+
+//设置请求没有指定版本时使用的版本
+func (server *Server) SetDefaultVersion(serviceName, version string) {
+	server.defaultVersions.Store(serviceName, version)
+}
+
+func (server *Server) defaultVersion(serviceName string) string {
+	if v, ok := server.defaultVersions.Load(serviceName); ok {
+		return v.(string)
+	}
+	if _, ok := server.serviceMap.Load(serviceName); ok {
+		return ""
+	}
+	if v, ok := server.firstVersions.Load(serviceName); ok {
+		return v.(string)
+	}
+	return ""
+}
+
+//按版本解析，显式指定的版本不存在时报错，默认版本上找不到时按原名再查一次（别名等）
+func (server *Server) lookupVersion(serviceMethod, version string) (*service, *methodType, error) {
+	serviceName, methodName, ok := splitServiceMethod(serviceMethod)
+	if !ok || strings.Contains(serviceName, "@") {
+		return server.lookup(serviceMethod)
+	}
+
+	explicit := version != ""
+	if !explicit {
+		version = server.defaultVersion(serviceName)
+	}
+	if version != "" {
+		svc, mtype, err := server.lookup(serviceName + "@" + version + "." + methodName)
+		if err == nil {
+			return svc, mtype, nil
+		}
+		if explicit {
+			if _, ok := server.serviceMap.Load(serviceName + "@" + version); !ok {
+				err = errors.New("rpc: can't find version " + version + " of service " + serviceName)
+			}
+			return nil, nil, err
+		}
+	}
+	return server.lookup(serviceMethod)
+}
+
+//标记方法已废弃，message 附在提示后面，可以为空
+func (server *Server) Deprecate(serviceMethod, message string) error {
+	svc, mtype, err := server.lookup(serviceMethod)
+	if err != nil {
+		return err
+	}
+	warning := svc.name + "." + mtype.method.Name + " is deprecated"
+	if message != "" {
+		warning += ": " + message
+	}
+	mtype.Lock()
+	mtype.deprecated = warning
+	mtype.Unlock()
+	return nil
+}
+
+//各个废弃方法的调用次数
+func (server *Server) DeprecatedCalls() map[string]uint {
+	calls := make(map[string]uint)
+	server.serviceMap.Range(func(_, svci interface{}) bool {
+		svc := svci.(*service)
+		for name, mtype := range svc.method {
+			mtype.Lock()
+			if mtype.deprecated != "" {
+				calls[svc.name+"."+name] = mtype.deprecatedCalls
+			}
+			mtype.Unlock()
+		}
+		return true
+	})
+	return calls
+}
+
+//记录废弃方法的调用，第一次和之后每 100 次写一条日志
+func (server *Server) deprecatedCall(req *Request, warning string, n uint) {
+	if n == 1 || n%100 == 0 {
+		log.Printf("rpc: %s (called as %s, %d calls)", warning, req.ServiceMethod, n)
+	}
+	if req.replyMeta == nil {
+		req.replyMeta = Metadata{}
+	}
+	req.replyMeta[WarningKey] = warning
+}
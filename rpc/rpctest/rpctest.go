@@ -0,0 +1,186 @@
+package rpctest
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/shengzhch/learn/jsonrpc"
+	"github.com/shengzhch/learn/rpc"
+)
+
+/*
+测试服务用的进程内服务端：
+每个客户端通过 net.Pipe 连接到 Server，不需要监听端口。
+可以注入故障：
+  - SetLatency   服务端每次写响应前等待
+  - Drop         断开所有连接，客户端上未完成的调用失败
+  - FailNext     让服务端编解码器的下一次操作返回指定错误
+*/
+
+//编解码器的操作
+type Op int
+
+const (
+	ReadHeader Op = iota
+	ReadBody
+	WriteResponse
+)
+
+type Server struct {
+	*rpc.Server
+
+	latency int64 //time.Duration
+
+	mu     sync.Mutex
+	conns  map[net.Conn]bool
+	faults map[Op]error
+	closed bool
+}
+
+func NewServer() *Server {
+	return &Server{
+		Server: rpc.NewServer(),
+		conns:  make(map[net.Conn]bool),
+		faults: make(map[Op]error),
+	}
+}
+
+//注册 rcvrs 并在测试结束时关闭
+func Start(t testing.TB, rcvrs ...interface{}) *Server {
+	s := NewServer()
+	for _, rcvr := range rcvrs {
+		if err := s.Register(rcvr); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Cleanup(s.Close)
+	return s
+}
+
+//gob 编码的客户端
+func (s *Server) GobClient() *rpc.Client {
+	cli, srv := s.pipe()
+	go s.ServeCodec(s.faulty(rpc.NewGobServerCodec(srv)))
+	return rpc.NewClient(cli)
+}
+
+//jsonrpc 编码的客户端
+func (s *Server) JSONClient() *rpc.Client {
+	cli, srv := s.pipe()
+	go s.ServeCodec(s.faulty(jsonrpc.NewServerCodec(srv)))
+	return jsonrpc.NewClient(cli)
+}
+
+func (s *Server) pipe() (net.Conn, net.Conn) {
+	cli, srv := net.Pipe()
+	c := &slowConn{Conn: srv, latency: &s.latency}
+	s.mu.Lock()
+	if s.closed {
+		srv.Close()
+	} else {
+		s.conns[c] = true
+	}
+	s.mu.Unlock()
+	return cli, c
+}
+
+func (s *Server) SetLatency(d time.Duration) {
+	atomic.StoreInt64(&s.latency, int64(d))
+}
+
+//断开当前所有连接
+func (s *Server) Drop() {
+	s.mu.Lock()
+	conns := s.conns
+	s.conns = make(map[net.Conn]bool)
+	s.mu.Unlock()
+	for c := range conns {
+		c.Close()
+	}
+}
+
+//下一次 op 操作返回 err，只生效一次
+func (s *Server) FailNext(op Op, err error) {
+	s.mu.Lock()
+	s.faults[op] = err
+	s.mu.Unlock()
+}
+
+func (s *Server) fault(op Op) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := s.faults[op]
+	delete(s.faults, op)
+	return err
+}
+
+func (s *Server) Close() {
+	s.mu.Lock()
+	s.closed = true
+	s.mu.Unlock()
+	s.Drop()
+}
+
+type slowConn struct {
+	net.Conn
+	latency *int64
+}
+
+func (c *slowConn) Write(p []byte) (int, error) {
+	if d := time.Duration(atomic.LoadInt64(c.latency)); d > 0 {
+		time.Sleep(d)
+	}
+	return c.Conn.Write(p)
+}
+
+//按 Server 上登记的故障返回错误的编解码器
+type faultyCodec struct {
+	rpc.ServerCodec
+	s *Server
+}
+
+func (s *Server) faulty(codec rpc.ServerCodec) rpc.ServerCodec {
+	return &faultyCodec{codec, s}
+}
+
+//读操作先读完再检查故障，读取前就在等待的连接也能生效
+func (c *faultyCodec) ReadRequestHeader(r *rpc.Request) error {
+	err := c.ServerCodec.ReadRequestHeader(r)
+	if ferr := c.s.fault(ReadHeader); ferr != nil {
+		return ferr
+	}
+	return err
+}
+
+func (c *faultyCodec) ReadRequestBody(body interface{}) error {
+	err := c.ServerCodec.ReadRequestBody(body)
+	if ferr := c.s.fault(ReadBody); ferr != nil {
+		return ferr
+	}
+	return err
+}
+
+func (c *faultyCodec) WriteResponse(r *rpc.Response, body interface{}) error {
+	if err := c.s.fault(WriteResponse); err != nil {
+		return err
+	}
+	return c.ServerCodec.WriteResponse(r, body)
+}
+
+//转发大小限制，Server 上设置的 SetMaxHeaderSize、SetMaxBodySize 才能生效
+func (c *faultyCodec) SetSizeLimits(maxHeader, maxBody int) {
+	if l, ok := c.ServerCodec.(interface{ SetSizeLimits(maxHeader, maxBody int) }); ok {
+		l.SetSizeLimits(maxHeader, maxBody)
+	}
+}
+
+func (c *faultyCodec) SetReadDeadline(t time.Time) error {
+	return rpc.SetReadDeadline(c.ServerCodec, t)
+}
+
+func (c *faultyCodec) SetWriteDeadline(t time.Time) error {
+	return rpc.SetWriteDeadline(c.ServerCodec, t)
+}
@@ -0,0 +1,94 @@
+package rpctest
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shengzhch/learn/rpc"
+)
+
+type Echo int
+
+func (e *Echo) Say(args *string, reply *string) error {
+	*reply = *args
+	return nil
+}
+
+func TestClients(t *testing.T) {
+	s := Start(t, new(Echo))
+	for name, client := range map[string]*rpc.Client{"gob": s.GobClient(), "json": s.JSONClient()} {
+		var reply string
+		if err := client.Call("Echo.Say", "hi", &reply); err != nil || reply != "hi" {
+			t.Errorf("%s: Echo.Say = %q, %v", name, reply, err)
+		}
+		client.Close()
+	}
+}
+
+func TestLatency(t *testing.T) {
+	s := Start(t, new(Echo))
+	client := s.GobClient()
+	defer client.Close()
+
+	s.SetLatency(50 * time.Millisecond)
+	start := time.Now()
+	var reply string
+	if err := client.Call("Echo.Say", "hi", &reply); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d < 50*time.Millisecond {
+		t.Errorf("call took %v, want at least 50ms", d)
+	}
+}
+
+func TestDrop(t *testing.T) {
+	s := Start(t, new(Echo))
+	client := s.JSONClient()
+	defer client.Close()
+
+	s.Drop()
+	var reply string
+	if err := client.Call("Echo.Say", "hi", &reply); err == nil {
+		t.Fatal("call on dropped connection succeeded")
+	}
+}
+
+func TestFailNext(t *testing.T) {
+	s := Start(t, new(Echo))
+	client := s.GobClient()
+	defer client.Close()
+
+	//请求体读取失败时服务端回复错误，连接继续可用
+	s.FailNext(ReadBody, errors.New("corrupt body"))
+	var reply string
+	if err := client.Call("Echo.Say", "hi", &reply); err == nil {
+		t.Fatal("expected error from injected body failure")
+	}
+	if err := client.Call("Echo.Say", "again", &reply); err != nil || reply != "again" {
+		t.Fatalf("after fault: %q, %v", reply, err)
+	}
+
+	//请求头读取失败时服务端断开连接
+	s.FailNext(ReadHeader, errors.New("corrupt header"))
+	if err := client.Call("Echo.Say", "hi", &reply); err == nil {
+		t.Fatal("expected error from injected header failure")
+	}
+}
+
+//Server 上的大小限制经过故障注入的编解码器也能生效
+func TestSizeLimits(t *testing.T) {
+	s := Start(t, new(Echo))
+	s.SetMaxHeaderSize(1024)
+	s.SetMaxBodySize(64)
+	//整个请求不超过请求头和请求体之和，JSON-RPC 读完整个请求后按请求体报错
+	big := strings.Repeat("x", 200)
+	for name, client := range map[string]*rpc.Client{"gob": s.GobClient(), "json": s.JSONClient()} {
+		var reply string
+		if err := client.Call("Echo.Say", big, &reply); err == nil || !strings.Contains(err.Error(), "exceeds 64 bytes") {
+			t.Errorf("%s: oversized request: %v", name, err)
+		}
+		client.Close()
+	}
+}
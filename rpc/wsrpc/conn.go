@@ -0,0 +1,249 @@
+package wsrpc
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/shengzhch/learn/rpc"
+)
+
+//RFC 6455 帧的最小实现，足够承载 rpc 的流式编解码
+
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xA
+)
+
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+//控制帧的负载最大 125 字节
+const maxControlPayload = 125
+
+var (
+	errProtocol = errors.New("wsrpc: protocol error")
+	errClosed   = errors.New("wsrpc: connection closed")
+)
+
+//计算握手应答 Sec-WebSocket-Accept
+func acceptKey(key string) string {
+	h := sha1.New()
+	h.Write([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+//Conn 把 websocket 消息看作一个连续的字节流，实现 io.ReadWriteCloser
+//每次 Write 发送一个完整的数据帧，Read 依次读出数据帧的负载
+type Conn struct {
+	rwc    io.ReadWriteCloser
+	br     *bufio.Reader
+	client bool //客户端发出的帧必须加掩码
+	opcode byte //Write 使用的数据帧类型
+
+	rmu       sync.Mutex
+	remaining int64
+	masked    bool
+	mask      [4]byte
+	maskPos   int
+	eof       bool
+
+	wmu    sync.Mutex
+	closed bool
+}
+
+func newConn(rwc io.ReadWriteCloser, br *bufio.Reader, client bool, opcode byte) *Conn {
+	if br == nil {
+		br = bufio.NewReader(rwc)
+	}
+	return &Conn{rwc: rwc, br: br, client: client, opcode: opcode}
+}
+
+func (c *Conn) Read(p []byte) (int, error) {
+	c.rmu.Lock()
+	defer c.rmu.Unlock()
+
+	for c.remaining == 0 {
+		if c.eof {
+			return 0, io.EOF
+		}
+		if err := c.nextFrame(); err != nil {
+			return 0, err
+		}
+	}
+
+	if int64(len(p)) > c.remaining {
+		p = p[:c.remaining]
+	}
+	n, err := c.br.Read(p)
+	c.unmask(p[:n])
+	c.remaining -= int64(n)
+	return n, err
+}
+
+func (c *Conn) unmask(p []byte) {
+	if !c.masked {
+		return
+	}
+	for i := range p {
+		p[i] ^= c.mask[c.maskPos&3]
+		c.maskPos++
+	}
+}
+
+//读下一个数据帧的头，控制帧在这里直接处理
+func (c *Conn) nextFrame() error {
+	for {
+		var hdr [2]byte
+		if _, err := io.ReadFull(c.br, hdr[:]); err != nil {
+			return err
+		}
+		if hdr[0]&0x70 != 0 {
+			return errProtocol
+		}
+		opcode := hdr[0] & 0x0f
+		c.masked = hdr[1]&0x80 != 0
+		//服务端只接收加了掩码的帧，客户端只接收未加掩码的帧
+		if c.masked == c.client {
+			return errProtocol
+		}
+
+		length := int64(hdr[1] & 0x7f)
+		switch length {
+		case 126:
+			var b [2]byte
+			if _, err := io.ReadFull(c.br, b[:]); err != nil {
+				return err
+			}
+			length = int64(binary.BigEndian.Uint16(b[:]))
+		case 127:
+			var b [8]byte
+			if _, err := io.ReadFull(c.br, b[:]); err != nil {
+				return err
+			}
+			length = int64(binary.BigEndian.Uint64(b[:]))
+			if length < 0 {
+				return errProtocol
+			}
+		}
+		if c.masked {
+			if _, err := io.ReadFull(c.br, c.mask[:]); err != nil {
+				return err
+			}
+		}
+		c.maskPos = 0
+
+		switch opcode {
+		case opContinuation, opText, opBinary:
+			c.remaining = length
+			return nil
+		case opPing, opPong, opClose:
+			if length > maxControlPayload {
+				return errProtocol
+			}
+			payload := make([]byte, length)
+			if _, err := io.ReadFull(c.br, payload); err != nil {
+				return err
+			}
+			c.unmask(payload)
+			switch opcode {
+			case opPing:
+				if err := c.writeFrame(opPong, payload); err != nil {
+					return err
+				}
+			case opClose:
+				//回一个关闭帧，之后的读都返回 EOF
+				c.writeFrame(opClose, payload)
+				c.eof = true
+				return io.EOF
+			}
+		default:
+			return errProtocol
+		}
+	}
+}
+
+func (c *Conn) Write(p []byte) (int, error) {
+	if err := c.writeFrame(c.opcode, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+//写一个 FIN 置位的完整帧
+func (c *Conn) writeFrame(opcode byte, p []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if c.closed {
+		return errClosed
+	}
+
+	buf := make([]byte, 0, 14+len(p))
+	buf = append(buf, 0x80|opcode)
+
+	var maskBit byte
+	if c.client {
+		maskBit = 0x80
+	}
+	switch n := len(p); {
+	case n < 126:
+		buf = append(buf, maskBit|byte(n))
+	case n <= 0xffff:
+		buf = append(buf, maskBit|126, byte(n>>8), byte(n))
+	default:
+		buf = append(buf, maskBit|127)
+		buf = binary.BigEndian.AppendUint64(buf, uint64(n))
+	}
+
+	if !c.client {
+		buf = append(buf, p...)
+	} else {
+		var mask [4]byte
+		if _, err := rand.Read(mask[:]); err != nil {
+			return err
+		}
+		buf = append(buf, mask[:]...)
+		for i, b := range p {
+			buf = append(buf, b^mask[i&3])
+		}
+	}
+
+	_, err := c.rwc.Write(buf)
+	return err
+}
+
+//底层的网络连接，不是 net.Conn 时返回 nil
+func (c *Conn) NetConn() net.Conn {
+	nc, _ := c.rwc.(net.Conn)
+	return nc
+}
+
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	return rpc.SetReadDeadline(c.rwc, t)
+}
+
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	return rpc.SetWriteDeadline(c.rwc, t)
+}
+
+//发送关闭帧后关闭底层连接，可重复调用
+func (c *Conn) Close() error {
+	c.writeFrame(opClose, []byte{0x03, 0xe8}) //1000 normal closure
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	return c.rwc.Close()
+}
@@ -0,0 +1,62 @@
+package wsrpc
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+)
+
+func TestConnRoundTrip(t *testing.T) {
+	//net.Pipe 没有缓冲，自动应答 pong 时会互相阻塞，这里用回环 TCP
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+	cp, err := net.Dial("tcp", lis.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sp, err := lis.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := newConn(cp, nil, true, opBinary)
+	server := newConn(sp, nil, false, opBinary)
+
+	sizes := []int{0, 5, 125, 126, 70000}
+	go func() {
+		for _, n := range sizes {
+			client.Write(bytes.Repeat([]byte{'x'}, n))
+		}
+		//ping 由服务端在读时自动应答
+		client.writeFrame(opPing, []byte("hi"))
+		client.Write([]byte("end"))
+	}()
+
+	total := 3
+	for _, n := range sizes {
+		total += n
+	}
+	buf := make([]byte, total)
+	if _, err := io.ReadFull(server, buf); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasSuffix(buf, []byte("xend")) {
+		t.Fatalf("unexpected payload tail %q", buf[len(buf)-4:])
+	}
+
+	//客户端读到 pong 后继续读关闭帧
+	go server.Close()
+	if _, err := client.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("want EOF after close, got %v", err)
+	}
+}
+
+func TestAcceptKey(t *testing.T) {
+	//RFC 6455 1.3 中的示例
+	if got := acceptKey("dGhlIHNhbXBsZSBub25jZQ=="); got != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("acceptKey = %q", got)
+	}
+}
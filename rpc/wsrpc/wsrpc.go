@@ -0,0 +1,251 @@
+package wsrpc
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/shengzhch/learn/jsonrpc"
+	"github.com/shengzhch/learn/rpc"
+)
+
+/*
+WebSocket 传输：
+升级连接后通过 Sec-WebSocket-Protocol 协商编解码器，
+"jsonrpc" 使用文本帧承载 JSON-RPC，"gob" 使用二进制帧承载 gob。
+未指定子协议时默认使用 JSON-RPC，方便浏览器直接使用。
+
+浏览器不限制跨站的 WebSocket 连接，握手时需要检查 Origin：
+默认只接受同源请求（以及没有 Origin 的非浏览器客户端），其他来源用 AllowOrigins 放开。
+*/
+
+const (
+	ProtocolJSON = "jsonrpc"
+	ProtocolGob  = "gob"
+)
+
+//判断是否接受握手请求的来源
+type OriginChecker func(req *http.Request) bool
+
+//Origin 的 host 与请求的 Host 相同，或者没有 Origin
+func SameOrigin(req *http.Request) bool {
+	origin := req.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(u.Host, req.Host)
+}
+
+//同源，或者 Origin 是给定的某个值，例如 "https://app.example.com"
+func AllowOrigins(origins ...string) OriginChecker {
+	return func(req *http.Request) bool {
+		if SameOrigin(req) {
+			return true
+		}
+		origin := req.Header.Get("Origin")
+		for _, o := range origins {
+			if strings.EqualFold(o, origin) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+type handler struct {
+	server *rpc.Server
+	origin OriginChecker
+}
+
+//返回升级为 websocket 并交给 server 处理的 http.Handler，只接受同源请求
+func Handler(server *rpc.Server) http.Handler {
+	return HandlerWithOrigin(server, SameOrigin)
+}
+
+//同 Handler，由 check 决定接受哪些来源
+func HandlerWithOrigin(server *rpc.Server, check OriginChecker) http.Handler {
+	if server == nil {
+		server = rpc.DefaultServer
+	}
+	return &handler{server: server, origin: check}
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	conn, proto, err := UpgradeOrigin(w, req, h.origin)
+	if err != nil {
+		log.Print("wsrpc: upgrade ", req.RemoteAddr, " : ", err.Error())
+		return
+	}
+
+	//ServeConn 通过 Conn.NetConn 取得对端信息
+	switch proto {
+	case ProtocolGob:
+		h.server.ServeConn(conn)
+	default:
+		h.server.ServeCodecPeer(jsonrpc.NewServerCodec(conn), rpc.PeerOf(conn))
+	}
+}
+
+//选择客户端提供的第一个支持的子协议
+func selectProtocol(req *http.Request) string {
+	for _, v := range req.Header["Sec-Websocket-Protocol"] {
+		for _, p := range strings.Split(v, ",") {
+			switch p = strings.TrimSpace(p); p {
+			case ProtocolJSON, ProtocolGob:
+				return p
+			}
+		}
+	}
+	return ""
+}
+
+func headerContains(h http.Header, name, token string) bool {
+	for _, v := range h[http.CanonicalHeaderKey(name)] {
+		for _, s := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(s), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+//完成服务端握手，返回连接和协商出的子协议，只接受同源请求
+func Upgrade(w http.ResponseWriter, req *http.Request) (*Conn, string, error) {
+	return UpgradeOrigin(w, req, SameOrigin)
+}
+
+//同 Upgrade，check 为 nil 时不检查来源
+func UpgradeOrigin(w http.ResponseWriter, req *http.Request, check OriginChecker) (*Conn, string, error) {
+	if req.Method != "GET" {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "405 must GET", http.StatusMethodNotAllowed)
+		return nil, "", errors.New("method must be GET")
+	}
+	if !headerContains(req.Header, "Connection", "upgrade") || !headerContains(req.Header, "Upgrade", "websocket") {
+		http.Error(w, "400 not a websocket handshake", http.StatusBadRequest)
+		return nil, "", errors.New("not a websocket handshake")
+	}
+	if req.Header.Get("Sec-Websocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "426 unsupported websocket version", http.StatusUpgradeRequired)
+		return nil, "", errors.New("unsupported websocket version")
+	}
+	if check != nil && !check(req) {
+		http.Error(w, "403 origin not allowed", http.StatusForbidden)
+		return nil, "", errors.New("origin " + req.Header.Get("Origin") + " not allowed")
+	}
+	key := req.Header.Get("Sec-Websocket-Key")
+	if key == "" {
+		http.Error(w, "400 missing Sec-WebSocket-Key", http.StatusBadRequest)
+		return nil, "", errors.New("missing Sec-WebSocket-Key")
+	}
+
+	proto := selectProtocol(req)
+
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "500 connection cannot be hijacked", http.StatusInternalServerError)
+		return nil, "", errors.New("connection cannot be hijacked")
+	}
+	rwc, brw, err := hj.Hijack()
+	if err != nil {
+		return nil, "", err
+	}
+
+	resp := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + acceptKey(key) + "\r\n"
+	if proto != "" {
+		resp += "Sec-WebSocket-Protocol: " + proto + "\r\n"
+	}
+	if _, err := io.WriteString(rwc, resp+"\r\n"); err != nil {
+		rwc.Close()
+		return nil, "", err
+	}
+
+	opcode := byte(opText)
+	if proto == ProtocolGob {
+		opcode = opBinary
+	}
+	return newConn(rwc, brw.Reader, false, opcode), proto, nil
+}
+
+//以客户端身份连接 ws:// 地址，protocol 为空时使用服务端默认的 JSON-RPC
+func Dial(rawurl, protocol string) (*Conn, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "ws" {
+		return nil, errors.New("wsrpc: unsupported scheme " + u.Scheme)
+	}
+	host := u.Host
+	if u.Port() == "" {
+		host = net.JoinHostPort(u.Hostname(), "80")
+	}
+
+	conn, err := net.Dial("tcp", host)
+	if err != nil {
+		return nil, err
+	}
+
+	var nonce [16]byte
+	if _, err := rand.Read(nonce[:]); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	key := base64.StdEncoding.EncodeToString(nonce[:])
+
+	req := &http.Request{
+		Method: "GET",
+		URL:    u,
+		Host:   u.Host,
+		Header: http.Header{
+			"Upgrade":               {"websocket"},
+			"Connection":            {"Upgrade"},
+			"Sec-WebSocket-Key":     {key},
+			"Sec-WebSocket-Version": {"13"},
+		},
+	}
+	if protocol != "" {
+		req.Header.Set("Sec-WebSocket-Protocol", protocol)
+	}
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		conn.Close()
+		return nil, errors.New("wsrpc: unexpected HTTP response: " + resp.Status)
+	}
+	if resp.Header.Get("Sec-Websocket-Accept") != acceptKey(key) {
+		conn.Close()
+		return nil, errors.New("wsrpc: bad Sec-WebSocket-Accept")
+	}
+
+	opcode := byte(opText)
+	if resp.Header.Get("Sec-Websocket-Protocol") == ProtocolGob {
+		opcode = opBinary
+	}
+	return newConn(conn, br, true, opcode), nil
+}
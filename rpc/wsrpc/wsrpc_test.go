@@ -0,0 +1,128 @@
+package wsrpc
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/shengzhch/learn/jsonrpc"
+	"github.com/shengzhch/learn/rpc"
+)
+
+type Echo int
+
+func (e *Echo) Say(args *string, reply *string) error {
+	*reply = *args
+	return nil
+}
+
+func TestHandlerEndToEnd(t *testing.T) {
+	server := rpc.NewServer()
+	server.Register(new(Echo))
+	ts := httptest.NewServer(Handler(server))
+	defer ts.Close()
+	url := "ws" + strings.TrimPrefix(ts.URL, "http")
+
+	for _, proto := range []string{"", ProtocolJSON, ProtocolGob} {
+		conn, err := Dial(url, proto)
+		if err != nil {
+			t.Fatalf("%q: %v", proto, err)
+		}
+		var client *rpc.Client
+		if proto == ProtocolGob {
+			client = rpc.NewClient(conn)
+		} else {
+			client = jsonrpc.NewClient(conn)
+		}
+		for _, s := range []string{"hi", strings.Repeat("x", 70000)} {
+			var reply string
+			if err := client.Call("Echo.Say", s, &reply); err != nil || reply != s {
+				t.Errorf("%q: Echo.Say = %d bytes, %v", proto, len(reply), err)
+			}
+		}
+		client.Close()
+	}
+}
+
+func handshake(t *testing.T, url, origin string) int {
+	req, _ := http.NewRequest("GET", url, nil)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	if origin != "" {
+		req.Header.Set("Origin", origin)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestOriginCheck(t *testing.T) {
+	server := rpc.NewServer()
+	same := httptest.NewServer(Handler(server))
+	defer same.Close()
+	allow := httptest.NewServer(HandlerWithOrigin(server, AllowOrigins("https://app.example.com")))
+	defer allow.Close()
+
+	for _, tt := range []struct {
+		url, origin string
+		code        int
+	}{
+		{same.URL, "", http.StatusSwitchingProtocols},
+		{same.URL, same.URL, http.StatusSwitchingProtocols},
+		{same.URL, "https://evil.example.com", http.StatusForbidden},
+		{same.URL, "null", http.StatusForbidden},
+		{allow.URL, "https://app.example.com", http.StatusSwitchingProtocols},
+		{allow.URL, "https://evil.example.com", http.StatusForbidden},
+	} {
+		if code := handshake(t, tt.url, tt.origin); code != tt.code {
+			t.Errorf("Origin %q on %s: status %d, want %d", tt.origin, tt.url, code, tt.code)
+		}
+	}
+}
+
+//返回调用方的地址
+type Who int
+
+func (w *Who) Addr(ctx context.Context, args int, reply *string) error {
+	if p, ok := rpc.PeerFromContext(ctx); ok {
+		*reply = p.Addr
+	}
+	return nil
+}
+
+//两种编解码器都能通过 PeerFromContext 取得对端地址
+func TestHandlerPeer(t *testing.T) {
+	server := rpc.NewServer()
+	server.Register(new(Who))
+	ts := httptest.NewServer(Handler(server))
+	defer ts.Close()
+	url := "ws" + strings.TrimPrefix(ts.URL, "http")
+
+	for _, proto := range []string{ProtocolJSON, ProtocolGob} {
+		conn, err := Dial(url, proto)
+		if err != nil {
+			t.Fatalf("%q: %v", proto, err)
+		}
+		var client *rpc.Client
+		if proto == ProtocolGob {
+			client = rpc.NewClient(conn)
+		} else {
+			client = jsonrpc.NewClient(conn)
+		}
+		var addr string
+		if err := client.Call("Who.Addr", 0, &addr); err != nil {
+			t.Fatalf("%q: %v", proto, err)
+		}
+		if want := conn.NetConn().LocalAddr().String(); addr != want {
+			t.Errorf("%q: peer = %q, want %q", proto, addr, want)
+		}
+		client.Close()
+	}
+}